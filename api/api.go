@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/binary"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/fberrez/horus/client"
 	"github.com/fberrez/horus/lifx"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -30,6 +33,26 @@ type (
 
 		// selectors is an array which contains all selectors.
 		selectors []*selector
+
+		// registry contains the known devices.
+		// Every operation on the devices goes through it.
+		registry *lifx.Registry
+
+		// saving serializes the writings of the config file.
+		saving sync.Mutex
+
+		// animations contains the animations played in background, by device.
+		animations map[*lifx.Lifx]*playback
+
+		// animating guards the animations.
+		animating sync.Mutex
+
+		// ctx is the context of the API. It is canceled when the API is closed,
+		// which aborts every operation in progress.
+		ctx context.Context
+
+		// cancel cancels the context of the API.
+		cancel context.CancelFunc
 	}
 
 	// Config contains all informations needed to run the application.
@@ -40,25 +63,92 @@ type (
 		// Source is the source identifier, used to identify the client from others.
 		Source uint32 `yaml:"source" json:"source"`
 
+		// Domain is the network where all the LIFX devices are connected.
+		// It is written with the CIDR form (ex: 192.168.1.0/24).
+		Domain string `yaml:"domain" json:"domain"`
+
 		// MaxBrightness is the maximum brightness value.
 		// It is used on /lights/toggle.
 		// Range from 0 to 65535.
 		MaxBrightness uint16 `yaml:"maxBrightness" json:"maxBrightness"`
 
+		// Retry defines how the messages sent to the devices are retried
+		// when they are not acknowledged.
+		Retry *client.Backoff `yaml:"retry" json:"retry"`
+
+		// PollInterval is the interval between two refreshes of the devices state.
+		PollInterval time.Duration `yaml:"pollInterval" json:"pollInterval"`
+
+		// PollJitter is the maximum random duration added to the poll interval,
+		// so the devices are not always refreshed at the same time.
+		PollJitter time.Duration `yaml:"pollJitter" json:"pollJitter"`
+
+		// Workers is the maximum number of devices contacted at the same time by a request.
+		Workers int `yaml:"workers" json:"workers"`
+
+		// RequestTimeout is the maximum duration of the operations performed by a request.
+		RequestTimeout time.Duration `yaml:"requestTimeout" json:"requestTimeout"`
+
 		// Lifx contains informations of all Lifx connected devices.
+		// It is only used to load and save the devices, which are handled by the registry of the API.
 		Lifx []*lifx.Lifx `yaml:"lifx" json:"lifx"`
 	}
 
+	// selector is a type of selector, such as `label` or `all`.
 	selector struct {
-		name      string
+		// name is the name of the selector, before the colon of a dynamic selector.
+		name string
+
+		// isDynamic determines if the selector has a value, matched against a field of the devices.
 		isDynamic bool
-		value     string
+
+		// field returns the field of a device matched by the value of a dynamic selector.
+		// It returns false if the field of the device is unknown.
+		field func(snapshot *lifx.Lifx) (string, bool)
+
+		// normalize verifies a value without wildcards, and returns the value as formatted by the field.
+		normalize func(value string) (string, error)
+	}
+
+	// playback is an animation played on a device.
+	playback struct {
+		// cancel stops the animation.
+		cancel context.CancelFunc
+	}
+
+	// collection describes how the groups or the locations of the devices are read and written,
+	// so both are handled the same way.
+	collection struct {
+		// name is the name of the collection, used in the errors.
+		name string
+
+		// read returns the ID, the label and the update time of the collection of a device.
+		// It returns false if the collection of the device is unknown.
+		read func(snapshot *lifx.Lifx) (lifx.ID, string, time.Time, bool)
+
+		// write moves a device to the collection with the given ID, label and update time.
+		write func(ctx context.Context, device *lifx.Lifx, id lifx.ID, label string, updatedAt time.Time) (*lifx.Delivery, error)
 	}
 )
 
 const (
 	configFile            = "CONFIG_FILE"
 	defaultConfigFilePath = "config.yaml"
+
+	defaultPollInterval   = time.Second * 30
+	defaultPollJitter     = time.Second * 5
+	defaultWorkers        = 16
+	defaultRequestTimeout = time.Second * 10
+
+	defaultEffectPeriod = 1000
+	defaultEffectCycles = 1
+
+	defaultFirmwareEffectSpeed = 5000
+	defaultCloudSaturationMin  = 50
+	defaultCloudSaturationMax  = 180
+
+	// maxImageSize is the maximum size of an uploaded image, in bytes.
+	maxImageSize = 10 << 20
 )
 
 var (
@@ -67,40 +157,128 @@ var (
 		isDynamic: false,
 	}
 
+	random = &selector{
+		name:      "random",
+		isDynamic: false,
+	}
+
 	label = &selector{
 		name:      "label",
 		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			return snapshot.Label, true
+		},
 	}
 
 	id = &selector{
 		name:      "uuid",
 		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			return snapshot.UUID, true
+		},
+	}
+
+	serial = &selector{
+		name:      "serial",
+		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			return snapshot.Serial.String(), !snapshot.Serial.IsZero()
+		},
+		normalize: func(value string) (string, error) {
+			serial, err := lifx.ParseSerial(value)
+			return serial.String(), err
+		},
 	}
 
 	groupID = &selector{
 		name:      "group_id",
 		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			if snapshot.Group == nil {
+				return "", false
+			}
+
+			return snapshot.Group.ID.String(), true
+		},
+		normalize: func(value string) (string, error) {
+			id, err := lifx.ParseID(value)
+			return id.String(), err
+		},
 	}
 
 	group = &selector{
 		name:      "group",
 		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			if snapshot.Group == nil {
+				return "", false
+			}
+
+			return snapshot.Group.Label, true
+		},
 	}
 
 	locationID = &selector{
 		name:      "location_id",
 		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			if snapshot.Location == nil {
+				return "", false
+			}
+
+			return snapshot.Location.ID.String(), true
+		},
+		normalize: func(value string) (string, error) {
+			id, err := lifx.ParseID(value)
+			return id.String(), err
+		},
 	}
 
 	location = &selector{
 		name:      "location",
 		isDynamic: true,
+		field: func(snapshot *lifx.Lifx) (string, bool) {
+			if snapshot.Location == nil {
+				return "", false
+			}
+
+			return snapshot.Location.Label, true
+		},
 	}
 
+	// sceneID is not implemented: it has no field.
 	sceneID = &selector{
 		name:      "scene_id",
 		isDynamic: true,
 	}
+
+	groups = &collection{
+		name: "group",
+		read: func(snapshot *lifx.Lifx) (lifx.ID, string, time.Time, bool) {
+			if snapshot.Group == nil {
+				return lifx.ID{}, "", time.Time{}, false
+			}
+
+			return snapshot.Group.ID, snapshot.Group.Label, snapshot.Group.UpdatedAt, true
+		},
+		write: func(ctx context.Context, device *lifx.Lifx, id lifx.ID, label string, updatedAt time.Time) (*lifx.Delivery, error) {
+			return device.SetGroupContext(ctx, &lifx.Group{ID: id, Label: label, UpdatedAt: updatedAt})
+		},
+	}
+
+	locations = &collection{
+		name: "location",
+		read: func(snapshot *lifx.Lifx) (lifx.ID, string, time.Time, bool) {
+			if snapshot.Location == nil {
+				return lifx.ID{}, "", time.Time{}, false
+			}
+
+			return snapshot.Location.ID, snapshot.Location.Label, snapshot.Location.UpdatedAt, true
+		},
+		write: func(ctx context.Context, device *lifx.Lifx, id lifx.ID, label string, updatedAt time.Time) (*lifx.Delivery, error) {
+			return device.SetLocationContext(ctx, &lifx.Location{ID: id, Label: label, UpdatedAt: updatedAt})
+		},
+	}
 )
 
 // New parses the config file and initializes a new API.
@@ -113,19 +291,39 @@ func New() (*API, error) {
 	// Setting source
 	binary.LittleEndian.PutUint32(lifx.Source[:], config.Source)
 
+	// Setting backoff
+	if config.Retry != nil {
+		lifx.DefaultBackoff = config.Retry
+	}
+
 	log.Info("Initializing API")
 	f := fizz.New()
 
 	// Initializes the array of selectors
-	selectors := append([]*selector{}, all, label, id, groupID,
+	selectors := append([]*selector{}, all, random, label, id, serial, groupID,
 		group, locationID, location, sceneID)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	api := &API{
-		fizz:      f,
-		config:    config,
-		selectors: selectors,
+		fizz:       f,
+		config:     config,
+		selectors:  selectors,
+		registry:   lifx.NewRegistry(config.Lifx),
+		animations: map[*lifx.Lifx]*playback{},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	// Discovers the devices of the domain
+	if len(config.Domain) > 0 {
+		if _, err := api.discoverLifx(ctx); err != nil {
+			log.WithField("domain", config.Domain).Warnf("Cannot discover devices: %v", err)
+		}
 	}
 
+	// Refreshes the state of the devices in background
+	go api.poll()
+
 	// API informations
 	infos := &openapi.Info{
 		Title:       "Horus - Up your local LIFX devices",
@@ -165,6 +363,178 @@ func New() (*API, error) {
 		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
 	}, tonic.Handler(api.toggle, http.StatusOK))
 
+	lightsGroup.PUT("/zones", []fizz.OperationOption{
+		fizz.Summary("Updates the zones of the corresponding multizone lights."),
+		fizz.Description("Sets a color on a range of zones, or a color on each zone, of the lights. The lights which are not multizone are not updated."),
+		fizz.Response("400", "the zones are not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.setZones, http.StatusOK))
+
+	lightsGroup.GET("/tiles", []fizz.OperationOption{
+		fizz.Summary("Gets the tiles of the corresponding matrix lights."),
+		fizz.Description("Returns the tiles of the lights, with their position, their orientation and the colors of their zones."),
+		fizz.Response("404", "cannot find corresponding matrix lights to the selector.", nil, nil),
+	}, tonic.Handler(api.getTiles, http.StatusOK))
+
+	lightsGroup.PUT("/tiles", []fizz.OperationOption{
+		fizz.Summary("Updates a tile of the corresponding matrix lights."),
+		fizz.Description("Sets the color of each zone of a tile of the lights. The lights which are not matrix lights are not updated."),
+		fizz.Response("400", "the colors are not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.setTile, http.StatusOK))
+
+	lightsGroup.GET("/groups", []fizz.OperationOption{
+		fizz.Summary("Gets the groups of the corresponding lights."),
+		fizz.Description("Returns the groups with their lights. The label of a group is the most recently updated one."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.getGroups, http.StatusOK))
+
+	lightsGroup.POST("/groups", []fizz.OperationOption{
+		fizz.Summary("Creates a group with the corresponding lights."),
+		fizz.Description("Generates the ID of a new group and moves the lights to it."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.createGroup, http.StatusCreated))
+
+	lightsGroup.PUT("/groups/:id", []fizz.OperationOption{
+		fizz.Summary("Renames a group."),
+		fizz.Description("Writes the new label of the group to each of its lights."),
+		fizz.Response("404", "cannot find the group.", nil, nil),
+	}, tonic.Handler(api.renameGroup, http.StatusOK))
+
+	lightsGroup.PUT("/groups/:id/lights", []fizz.OperationOption{
+		fizz.Summary("Moves the corresponding lights to a group."),
+		fizz.Description("Moves the lights from their current group to an existing one."),
+		fizz.Response("404", "cannot find the group or corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.moveToGroup, http.StatusOK))
+
+	lightsGroup.GET("/locations", []fizz.OperationOption{
+		fizz.Summary("Gets the locations of the corresponding lights."),
+		fizz.Description("Returns the locations with their lights. The label of a location is the most recently updated one."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.getLocations, http.StatusOK))
+
+	lightsGroup.POST("/locations", []fizz.OperationOption{
+		fizz.Summary("Creates a location with the corresponding lights."),
+		fizz.Description("Generates the ID of a new location and moves the lights to it."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.createLocation, http.StatusCreated))
+
+	lightsGroup.PUT("/locations/:id", []fizz.OperationOption{
+		fizz.Summary("Renames a location."),
+		fizz.Description("Writes the new label of the location to each of its lights."),
+		fizz.Response("404", "cannot find the location.", nil, nil),
+	}, tonic.Handler(api.renameLocation, http.StatusOK))
+
+	lightsGroup.PUT("/locations/:id/lights", []fizz.OperationOption{
+		fizz.Summary("Moves the corresponding lights to a location."),
+		fizz.Description("Moves the lights from their current location to an existing one."),
+		fizz.Response("404", "cannot find the location or corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.moveToLocation, http.StatusOK))
+
+	lightsGroup.GET("/ping", []fizz.OperationOption{
+		fizz.Summary("Probes the latency of the corresponding lights."),
+		fizz.Description("Sends echo probes to the lights, without changing their state, and returns the round trip times and the loss of the probes."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.ping, http.StatusOK))
+
+	lightsGroup.GET("/diagnostics", []fizz.OperationOption{
+		fizz.Summary("Gets the diagnostics of the corresponding lights."),
+		fizz.Description("Reads the firmwares, the wifi signal, the counters, the uptime and the clock skew of the lights."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.getDiagnostics, http.StatusOK))
+
+	lightsGroup.GET("/hev", []fizz.OperationOption{
+		fizz.Summary("Gets the HEV cycles of the corresponding HEV lights."),
+		fizz.Description("Returns the current HEV cycle, with its remaining time, the configuration of the cycles and the result of the last cycle of the lights."),
+		fizz.Response("404", "cannot find corresponding HEV lights to the selector.", nil, nil),
+	}, tonic.Handler(api.getHev, http.StatusOK))
+
+	lightsGroup.POST("/hev/start", []fizz.OperationOption{
+		fizz.Summary("Starts a HEV cycle on the corresponding HEV lights."),
+		fizz.Description("Starts a disinfection cycle of the given duration, or of the default duration of the lights. The lights which are not HEV lights are not updated."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.startHevCycle, http.StatusOK))
+
+	lightsGroup.POST("/hev/stop", []fizz.OperationOption{
+		fizz.Summary("Stops the HEV cycle of the corresponding HEV lights."),
+		fizz.Description("Stops the running disinfection cycle. The lights which are not HEV lights are not updated."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.stopHevCycle, http.StatusOK))
+
+	lightsGroup.PUT("/hev/configuration", []fizz.OperationOption{
+		fizz.Summary("Configures the HEV cycles of the corresponding HEV lights."),
+		fizz.Description("Sets the default duration of the disinfection cycles and determines if the lights flash when a cycle ends. The lights which are not HEV lights are not updated."),
+		fizz.Response("400", "the configuration is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.setHevConfiguration, http.StatusOK))
+
+	lightsGroup.POST("/image", []fizz.OperationOption{
+		fizz.Summary("Draws an image on the corresponding matrix or multizone lights."),
+		fizz.Description("Scales a PNG, JPEG or GIF image, sent as the image field of a multipart form or as the body, over the tiles or along the zones of the lights. Animated GIFs are played in background until another image is drawn or the animation is stopped."),
+		fizz.Response("400", "the image is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, api.drawImage)
+
+	lightsGroup.DELETE("/image", []fizz.OperationOption{
+		fizz.Summary("Stops the animations played on the corresponding lights."),
+		fizz.Description("Stops the animated GIFs played in background. The lights keep the last drawn frame."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.stopAnimations, http.StatusOK))
+
+	lightsGroup.POST("/effects/pulse", []fizz.OperationOption{
+		fizz.Summary("Performs a pulse effect on the corresponding lights."),
+		fizz.Description("Switches the lights between their color, or the starting color, and the color of the effect."),
+		fizz.Response("400", "the effect is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.pulse, http.StatusOK))
+
+	lightsGroup.POST("/effects/breathe", []fizz.OperationOption{
+		fizz.Summary("Performs a breathe effect on the corresponding lights."),
+		fizz.Description("Fades the lights smoothly between their color, or the starting color, and the color of the effect."),
+		fizz.Response("400", "the effect is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.breathe, http.StatusOK))
+
+	lightsGroup.POST("/effects/move", []fizz.OperationOption{
+		fizz.Summary("Runs a move effect on the corresponding multizone lights."),
+		fizz.Description("Moves the colors of the zones along the strips. The effect is run by the firmware of the lights, until it is stopped."),
+		fizz.Response("400", "the effect is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.move, http.StatusOK))
+
+	lightsGroup.POST("/effects/morph", []fizz.OperationOption{
+		fizz.Summary("Runs a morph effect on the corresponding matrix lights."),
+		fizz.Description("Morphs the colors of a palette over the tiles. The effect is run by the firmware of the lights, until it is stopped."),
+		fizz.Response("400", "the effect is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.morph, http.StatusOK))
+
+	lightsGroup.POST("/effects/flame", []fizz.OperationOption{
+		fizz.Summary("Runs a flame effect on the corresponding matrix lights."),
+		fizz.Description("Displays flames on the tiles. The effect is run by the firmware of the lights, until it is stopped."),
+		fizz.Response("400", "the effect is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.flame, http.StatusOK))
+
+	lightsGroup.POST("/effects/sky", []fizz.OperationOption{
+		fizz.Summary("Runs a sky effect on the corresponding matrix lights."),
+		fizz.Description("Displays a sunrise, a sunset or clouds on the tiles. The effect is run by the firmware of the lights, until it is stopped."),
+		fizz.Response("400", "the effect is not valid.", nil, nil),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.sky, http.StatusOK))
+
+	lightsGroup.POST("/effects/off", []fizz.OperationOption{
+		fizz.Summary("Stops the effects of the corresponding lights."),
+		fizz.Description("Stops the effects run by the firmware of the multizone and matrix lights."),
+		fizz.Response("404", "cannot find corresponding lights to the selector.", nil, nil),
+	}, tonic.Handler(api.effectsOff, http.StatusOK))
+
+	lightsGroup.POST("/discover", []fizz.OperationOption{
+		fizz.Summary("Discovers the lights of the local network."),
+		fizz.Description("Broadcasts a GetService message on the domain and adds the new lights to the list of known lights. Returns the new lights."),
+		fizz.Response("400", "the domain is not valid.", nil, nil),
+	}, tonic.Handler(api.discover, http.StatusOK))
+
 	tonic.SetErrorHook(jujerr.ErrHook)
 
 	return api, nil
@@ -179,10 +549,15 @@ func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"request":     r.RequestURI,
 	}).Info("Request received.")
 
-	a.updateLifx()
 	a.fizz.ServeHTTP(w, r)
 }
 
+// Close aborts every operation in progress on the devices.
+func (a *API) Close() {
+	log.Info("Closing API")
+	a.cancel()
+}
+
 // verifyKey verifies the value of the API key
 func (a *API) verifyKey(c *gin.Context) {
 	log.Debug("verifying api key")
@@ -240,5 +615,21 @@ func loadConfig() (*Config, error) {
 		return nil, errors.Annotate(err, "Cannot unmarshal config file")
 	}
 
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+
+	if config.PollJitter <= 0 {
+		config.PollJitter = defaultPollJitter
+	}
+
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+
 	return &config, nil
 }
@@ -1,9 +1,20 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/fberrez/horus/lifx"
 	"github.com/gin-gonic/gin"
 	"github.com/juju/errors"
+	"github.com/loopfz/gadgeto/tonic/utils/jujerr"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,6 +32,55 @@ type (
 		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
 	}
 
+	// DevicesIn is the input struct, used to get a list of lights.
+	DevicesIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are returned. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Fresh determines if the state of the lights is read from the lights instead of the cache.
+		Fresh bool `query:"fresh" description:"Reads the state from the lights instead of the cache." default:"false"`
+	}
+
+	// CollectionIn is the input struct, used to create a group or a location.
+	CollectionIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be moved to the new group or location.
+		Selector string `query:"selector" description:"The selector of the lights moved to the new group or location. More informations about format here: https://api.developer.lifx.com/docs/selectors"`
+
+		// Label is the label of the new group or location.
+		Label string `json:"label" description:"Label of the group or the location" validate:"required,max=32"`
+	}
+
+	// RenameIn is the input struct, used to rename a group or a location.
+	RenameIn struct {
+		// ID is the hex-encoded ID of the group or the location.
+		ID string `path:"id" description:"Hex-encoded ID of the group or the location"`
+
+		// Label is the new label of the group or the location.
+		Label string `json:"label" description:"New label of the group or the location" validate:"required,max=32"`
+	}
+
+	// MoveIn is the input struct, used to move lights to a group or a location.
+	MoveIn struct {
+		// ID is the hex-encoded ID of the group or the location.
+		ID string `path:"id" description:"Hex-encoded ID of the group or the location"`
+
+		// Selector is a unique identifier to select lights
+		// which will be moved to the group or the location.
+		Selector string `query:"selector" description:"The selector of the moved lights. More informations about format here: https://api.developer.lifx.com/docs/selectors"`
+	}
+
+	// PingIn is the input struct, used to probe the latency of lights.
+	PingIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be probed by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are probed. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Count is the number of echo probes sent to each light.
+		Count int `query:"count" description:"The number of echo probes sent to each light." validate:"min=1,max=20" default:"5"`
+	}
+
 	// StateIn is the input struct, used in requests which edit lights state
 	StateIn struct {
 		// Selector is a unique identifier to select lights
@@ -30,15 +90,18 @@ type (
 		// HSBK is the color of the light
 		HSBK *HSBKIn `json:"hsbk" decription:"HSBK contains Hue, Saturation, Brightness and Kelvin. Used to represented the color."`
 
-		// Duration determines how long in milliseconds will take the power action. Range: 0 – 4294967295 (~49 days)
+		// Duration determines how long in milliseconds will take the color and power transitions. Range: 0 – 4294967295 (~49 days)
 		// Its default value is 0.
-		Duration uint32 `json:"duration" description:"The time is seconds to spend perfoming the power toggle." validate:"min=0,max=4294967295" default:"0"`
+		Duration uint32 `json:"duration" description:"The time in milliseconds to spend performing the color and power transitions." validate:"min=0,max=4294967295" default:"0"`
 
 		// Power is the current power level of the light
 		Power string `json:"power" description:"The power state you want to set on the selector. on or off" enum:"on,off"`
 
 		// Label is the name of the light
 		Label string `json:"label" description:"new label of the LIFX device"`
+
+		// Infrared is the brightness of the infrared channel of the light.
+		Infrared *uint16 `json:"infrared" description:"The brightness of the infrared channel, from 0 to 65535. Only infrared lights, like the LIFX+, accept it." validate:"omitempty,min=0,max=65535"`
 	}
 
 	// HSBKIn is used to represent the color and color temperature of a light.
@@ -48,21 +111,157 @@ type (
 	HSBKIn struct {
 		// Hue is the color hue.
 		// Range from 0 to 65535
-		Hue uint16 `yaml:"hue" json:"hue" description:"The color hue" validate:"min=0,max=65535,required" `
+		Hue uint16 `yaml:"hue" json:"hue" description:"The color hue" validate:"min=0,max=65535"`
 
 		// Saturation is the color saturation.
 		// Range from 0 to 65535.
-		Saturation uint16 `yaml:"saturation" json:"saturation" description:"The color saturation" validate:"min=0,max=65535,required"`
+		Saturation uint16 `yaml:"saturation" json:"saturation" description:"The color saturation" validate:"min=0,max=65535"`
 
 		// Brightness is the color brightness.
 		// Range from 0 to 65535.
-		Brightness uint16 `yaml:"brightness" json:"brightness" description:"The color brightness" validate:"min=0,max=65535,required"`
+		Brightness uint16 `yaml:"brightness" json:"brightness" description:"The color brightness" validate:"min=0,max=65535"`
 
 		// Kelvin is the color temperature.
 		// Range from 2500(warm) to 9000(cool)
 		Kelvin uint16 `yaml:"kelvin" json:"kelvin" description:"The color temperature" validate:"min=2500,max=9000,required"`
 	}
 
+	// EffectIn is used on the effects routes. Its parameters are modelled on the LIFX HTTP API.
+	EffectIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Color is the color of the effect.
+		Color *HSBKIn `json:"color" description:"The color of the effect." validate:"required"`
+
+		// FromColor is the color set before the effect starts.
+		// If it is not set, the effect starts from the current color of the lights.
+		FromColor *HSBKIn `json:"from_color" description:"The color to start the effect from. Defaults to the current color of the lights."`
+
+		// Period is the duration of a cycle in milliseconds. Its default value is 1000.
+		Period uint32 `json:"period" description:"The time in milliseconds of a cycle of the effect. Defaults to 1000."`
+
+		// Cycles is the number of cycles. Its default value is 1.
+		Cycles float32 `json:"cycles" description:"The number of times to repeat the effect. Defaults to 1." validate:"min=0"`
+
+		// Persist determines if the lights keep the last color of the effect.
+		Persist bool `json:"persist" description:"If true, the lights keep the last color of the effect. Else, they return to their original color."`
+
+		// PowerOn determines if the lights are turned on before the effect. Its default value is true.
+		PowerOn *bool `json:"power_on" description:"If true, the lights are turned on if they are off. Defaults to true."`
+
+		// Peak defines where in a cycle the color of the effect is at its maximum, from 0 to 1.
+		// Its default value is 0.5.
+		Peak *float64 `json:"peak" description:"Where in a cycle the color of the effect is at its maximum, from 0 to 1. Defaults to 0.5." validate:"omitempty,min=0,max=1"`
+
+		// SetHue determines if the hue of the lights is changed by the effect. Its default value is true.
+		SetHue *bool `json:"set_hue" description:"If false, the hue of the lights is not changed by the effect. Defaults to true."`
+
+		// SetSaturation determines if the saturation of the lights is changed by the effect. Its default value is true.
+		SetSaturation *bool `json:"set_saturation" description:"If false, the saturation of the lights is not changed by the effect. Defaults to true."`
+
+		// SetBrightness determines if the brightness of the lights is changed by the effect. Its default value is true.
+		SetBrightness *bool `json:"set_brightness" description:"If false, the brightness of the lights is not changed by the effect. Defaults to true."`
+
+		// SetKelvin determines if the kelvin of the lights is changed by the effect. Its default value is true.
+		SetKelvin *bool `json:"set_kelvin" description:"If false, the kelvin of the lights is not changed by the effect. Defaults to true."`
+	}
+
+	// FirmwareEffectIn is used to run an effect with the firmware of multizone or matrix lights.
+	FirmwareEffectIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Speed is the duration of a cycle of the effect in milliseconds. Its default value is 5000.
+		Speed uint32 `json:"speed" description:"The time in milliseconds of a cycle of the effect. Defaults to 5000."`
+
+		// Duration is how long the effect runs in milliseconds. The effect never stops if it is 0.
+		Duration uint32 `json:"duration" description:"The time in milliseconds to run the effect. The effect never stops if it is 0." validate:"min=0,max=4294967295" default:"0"`
+
+		// Reverse determines if the colors of a move effect move toward the first zone.
+		Reverse bool `json:"reverse" description:"If true, the colors of a move effect move toward the first zone."`
+
+		// Palette contains the colors of a morph effect.
+		Palette []*HSBKIn `json:"palette" description:"The colors of a morph effect, 16 at most. Defaults to a rainbow." validate:"max=16"`
+
+		// Sky is the type of a sky effect. Its default value is sunrise.
+		Sky string `json:"sky" description:"The type of a sky effect: sunrise, sunset or clouds. Defaults to sunrise."`
+
+		// CloudSaturationMin is the minimum saturation of the clouds of a sky effect. Its default value is 50.
+		CloudSaturationMin *uint8 `json:"cloud_saturation_min" description:"The minimum saturation of the clouds of a sky effect, from 0 to 255. Defaults to 50."`
+
+		// CloudSaturationMax is the maximum saturation of the clouds of a sky effect. Its default value is 180.
+		CloudSaturationMax *uint8 `json:"cloud_saturation_max" description:"The maximum saturation of the clouds of a sky effect, from 0 to 255. Defaults to 180."`
+	}
+
+	// ZonesIn is used to set the zones of multizone lights.
+	// It either sets a single color on a range of zones, or a color on each zone.
+	ZonesIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Start is the index of the first zone to set.
+		Start uint16 `json:"start" description:"The index of the first zone to set." validate:"min=0,max=65535" default:"0"`
+
+		// End is the index of the last zone to set with the hsbk. Its default value is the start index.
+		End *uint16 `json:"end" description:"The index of the last zone to set with the hsbk. Defaults to the start index." validate:"omitempty,min=0,max=65535"`
+
+		// HSBK is the color set on every zone from start to end.
+		HSBK *HSBKIn `json:"hsbk" description:"The color set on every zone from start to end."`
+
+		// Colors contains the color of each zone, from the start index.
+		Colors []*HSBKIn `json:"colors" description:"The color of each zone, from the start index. It is used instead of hsbk."`
+
+		// Duration determines how long in milliseconds will take the color transition.
+		Duration uint32 `json:"duration" description:"The time in milliseconds to spend performing the color transition." validate:"min=0,max=4294967295" default:"0"`
+
+		// Apply determines when the colors are applied.
+		Apply string `json:"apply" description:"apply applies the colors with the stored ones, no_apply stores the colors without applying them and apply_only applies the stored colors. Defaults to apply." enum:"apply,no_apply,apply_only"`
+	}
+
+	// TileIn is used to set the colors of a tile of matrix lights.
+	TileIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Index is the index of the tile in the chain of the lights.
+		Index int `json:"index" description:"The index of the tile in the chain of the lights." validate:"min=0,max=15" default:"0"`
+
+		// Colors contains the color of each zone of the tile, row by row.
+		Colors []*HSBKIn `json:"colors" description:"The color of each zone of the tile, row by row (64 colors for a 8x8 tile)." validate:"required"`
+
+		// Duration determines how long in milliseconds will take the color transition.
+		Duration uint32 `json:"duration" description:"The time in milliseconds to spend performing the color transition." validate:"min=0,max=4294967295" default:"0"`
+	}
+
+	// HevCycleIn is used to start a HEV cycle of HEV lights.
+	HevCycleIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Duration is the duration of the cycle in milliseconds, rounded down to the second.
+		// The default duration of the lights is used if it is 0.
+		Duration uint32 `json:"duration" description:"The time in milliseconds of the cycle. Defaults to the default duration of the lights." validate:"min=0,max=4294967295" default:"0"`
+	}
+
+	// HevConfigurationIn is used to configure the HEV cycles of HEV lights.
+	HevConfigurationIn struct {
+		// Selector is a unique identifier to select lights
+		// which will be controlled by the request.
+		Selector string `query:"selector" description:"The selector to limit which lights are controlled. More informations about format here: https://api.developer.lifx.com/docs/selectors" default:"all"`
+
+		// Duration is the default duration of the cycles in milliseconds, rounded down to the second.
+		Duration uint32 `json:"duration" description:"The default time in milliseconds of the cycles, at least 1000." validate:"required,min=1000,max=4294967295"`
+
+		// Indication determines if the lights briefly flash when a cycle ends.
+		Indication bool `json:"indication" description:"If true, the lights briefly flash when a cycle ends."`
+	}
+
 	// DurationIn is used on the toggle route. It contains a selector and a duration in milliseconds.
 	DurationIn struct {
 		// Selector is a unique identifier to select lights
@@ -81,120 +280,818 @@ type (
 		// UUID is the UUID of the LIFX device
 		UUID string `json:"uuid" description:"UUID of the LIFX device"`
 
+		// Serial is the serial number of the LIFX device
+		Serial lifx.Serial `json:"serial" description:"Serial number of the LIFX device"`
+
 		// Label is the label of the LIFX device
 		Label string `json:"label" description:"Label of the LIFX device"`
 
 		// Error contains informations about the error when the operation has not been successfull.
-		Error error `json:"error" description:"Informations concerning the error are here"`
+		Error string `json:"error,omitempty" description:"Informations concerning the error are here"`
+
+		// Skipped determines if the LIFX device has been skipped because it is unreachable.
+		Skipped bool `json:"skipped" description:"The LIFX device has been skipped because it is unreachable"`
+
+		// Attempts is the number of packets sent to the LIFX device.
+		Attempts int `json:"attempts" description:"Number of packets sent to the LIFX device"`
+
+		// RTT is the longest round trip time of the acknowledged packets.
+		// The packets acknowledged after being sent again are not measured.
+		RTT time.Duration `json:"rtt" description:"Longest round trip time of the packets acknowledged at their first attempt, in nanoseconds"`
+	}
+
+	// HevOut contains the state of the HEV light of a HEV light.
+	HevOut struct {
+		// UUID is the UUID of the LIFX device
+		UUID string `json:"uuid" description:"UUID of the LIFX device"`
+
+		// Serial is the serial number of the LIFX device
+		Serial lifx.Serial `json:"serial" description:"Serial number of the LIFX device"`
+
+		// Label is the label of the LIFX device
+		Label string `json:"label" description:"Label of the LIFX device"`
+
+		// Hev contains the current cycle, the configuration and the last cycle result of the LIFX device.
+		Hev *lifx.Hev `json:"hev" description:"Current cycle, configuration and last cycle result of the LIFX device. The durations are in nanoseconds"`
+	}
+
+	// LightOut identifies a light.
+	LightOut struct {
+		// UUID is the UUID of the LIFX device.
+		UUID string `json:"uuid" description:"UUID of the LIFX device"`
+
+		// Serial is the serial number of the LIFX device.
+		Serial lifx.Serial `json:"serial" description:"Serial number of the LIFX device"`
+
+		// Label is the label of the LIFX device.
+		Label string `json:"label" description:"Label of the LIFX device"`
+	}
+
+	// CollectionOut contains a group or a location.
+	CollectionOut struct {
+		// ID is the ID of the group or the location.
+		ID lifx.ID `json:"id" description:"Hex-encoded ID of the group or the location"`
+
+		// Label is the label of the group or the location.
+		Label string `json:"label" description:"Label of the group or the location"`
+
+		// UpdatedAt is the last update time of the group or the location.
+		UpdatedAt time.Time `json:"updatedAt" description:"Last update time of the group or the location"`
+
+		// Lights contains the lights in the group or the location.
+		Lights []*LightOut `json:"lights,omitempty" description:"Lights in the group or the location"`
+
+		// Results contains the result of the update of each light.
+		Results []*ResultOut `json:"results,omitempty" description:"Result of the update of each light"`
+	}
+
+	// PingOut contains the latency of a light.
+	PingOut struct {
+		*ResultOut
+
+		// Latency contains the statistics of the echo probes sent by the request.
+		Latency *lifx.Latency `json:"latency,omitempty" description:"Statistics of the echo probes sent by the request. The durations are in nanoseconds"`
+	}
+
+	// DiagnosticsOut contains the diagnostics of a light.
+	DiagnosticsOut struct {
+		*ResultOut
+
+		// Diagnostics contains the firmwares, the signals, the counters and the clock of the LIFX device.
+		Diagnostics *lifx.Diagnostics `json:"diagnostics,omitempty" description:"Firmwares, signals, counters and clock of the LIFX device. The durations are in nanoseconds"`
+	}
+
+	// ChainOut contains the tiles of a matrix light.
+	ChainOut struct {
+		// UUID is the UUID of the LIFX device
+		UUID string `json:"uuid" description:"UUID of the LIFX device"`
+
+		// Serial is the serial number of the LIFX device
+		Serial lifx.Serial `json:"serial" description:"Serial number of the LIFX device"`
+
+		// Label is the label of the LIFX device
+		Label string `json:"label" description:"Label of the LIFX device"`
+
+		// Chain contains the tiles of the LIFX device, with the colors of their zones.
+		Chain []*lifx.Tile `json:"chain" description:"Tiles of the LIFX device, with the colors of their zones"`
 	}
 )
 
-// getLights returns the list of corresponding lights in the selector.
-func (a *API) getDevices(c *gin.Context, in *SelectorIn) ([]*lifx.Lifx, error) {
-	logger := log.WithField("action", "get-devices")
+// applicationRequests contains the application requests of the zones route, by name.
+var applicationRequests = map[string]lifx.ApplicationRequest{
+	"":           lifx.Apply,
+	"apply":      lifx.Apply,
+	"no_apply":   lifx.NoApply,
+	"apply_only": lifx.ApplyOnly,
+}
+
+// toHSBK converts the input HSBK to a lifx HSBK.
+func (h *HSBKIn) toHSBK() *lifx.HSBK {
+	return &lifx.HSBK{
+		Hue:        h.Hue,
+		Saturation: h.Saturation,
+		Brightness: h.Brightness,
+		Kelvin:     h.Kelvin,
+	}
+}
+
+// toEffect converts the input effect to a lifx effect with the given waveform.
+func (in *EffectIn) toEffect(waveform lifx.Waveform) *lifx.Effect {
+	effect := &lifx.Effect{
+		Waveform:  waveform,
+		Color:     in.Color.toHSBK(),
+		Period:    in.Period,
+		Cycles:    in.Cycles,
+		SkewRatio: lifx.SkewRatio(0.5),
+		Persist:   in.Persist,
+		PowerOn:   isTrueOrNil(in.PowerOn),
+	}
+
+	if in.FromColor != nil {
+		effect.FromColor = in.FromColor.toHSBK()
+	}
+
+	if effect.Period == 0 {
+		effect.Period = defaultEffectPeriod
+	}
+
+	if effect.Cycles == 0 {
+		effect.Cycles = defaultEffectCycles
+	}
+
+	if in.Peak != nil {
+		effect.SkewRatio = lifx.SkewRatio(*in.Peak)
+	}
+
+	// The channels are only defined if one of them is set,
+	// so the effect is performed with a SetWaveformOptional message.
+	if in.SetHue != nil || in.SetSaturation != nil || in.SetBrightness != nil || in.SetKelvin != nil {
+		effect.Channels = &lifx.Channels{
+			Hue:        isTrueOrNil(in.SetHue),
+			Saturation: isTrueOrNil(in.SetSaturation),
+			Brightness: isTrueOrNil(in.SetBrightness),
+			Kelvin:     isTrueOrNil(in.SetKelvin),
+		}
+	}
+
+	return effect
+}
+
+// toFirmwareEffect converts the input firmware effect to a lifx firmware effect of the given type.
+func (in *FirmwareEffectIn) toFirmwareEffect(effectType lifx.FirmwareEffectType) (*lifx.FirmwareEffect, error) {
+	effect := &lifx.FirmwareEffect{
+		Type:               effectType,
+		Speed:              in.Speed,
+		Duration:           time.Duration(in.Duration) * time.Millisecond,
+		Reverse:            in.Reverse,
+		Sky:                lifx.SkyType(in.Sky),
+		CloudSaturationMin: defaultCloudSaturationMin,
+		CloudSaturationMax: defaultCloudSaturationMax,
+	}
+
+	if effect.Speed == 0 {
+		effect.Speed = defaultFirmwareEffectSpeed
+	}
+
+	if len(effect.Sky) == 0 {
+		effect.Sky = lifx.SkySunrise
+	}
+
+	if in.CloudSaturationMin != nil {
+		effect.CloudSaturationMin = *in.CloudSaturationMin
+	}
 
-	if len(a.config.Lifx) == 0 {
-		return nil, errors.NewNotProvisioned(nil, "list of Lifx devices")
+	if in.CloudSaturationMax != nil {
+		effect.CloudSaturationMax = *in.CloudSaturationMax
+	}
+
+	for i, color := range in.Palette {
+		if color == nil {
+			return nil, errors.NotValidf("color %d of the palette", i)
+		}
+		effect.Palette = append(effect.Palette, *color.toHSBK())
+	}
+
+	return effect, nil
+}
+
+// isTrueOrNil returns true if the value is not set or true.
+func isTrueOrNil(value *bool) bool {
+	return value == nil || *value
+}
+
+// newResultOut returns the result of an operation performed on a device.
+func newResultOut(device *lifx.Lifx, delivery *lifx.Delivery, err error) *ResultOut {
+	snapshot := device.Snapshot()
+	result := &ResultOut{
+		UUID:   snapshot.UUID,
+		Serial: snapshot.Serial,
+		Label:  snapshot.Label,
 	}
 
-	// Updates the list of lifx devices
-	err := a.updateLifx()
 	if err != nil {
-		return nil, err
+		result.Error = err.Error()
+	}
+
+	if delivery != nil {
+		result.Attempts = delivery.Attempts
+		result.RTT = delivery.RTT
+	}
+
+	return result
+}
+
+// newSkippedResultOut returns the result of a device which has been skipped
+// because it is unreachable.
+func newSkippedResultOut(device *lifx.Lifx) *ResultOut {
+	snapshot := device.Snapshot()
+	return &ResultOut{
+		UUID:    snapshot.UUID,
+		Serial:  snapshot.Serial,
+		Label:   snapshot.Label,
+		Error:   fmt.Sprintf("device unreachable until %s: %s", snapshot.RetryAt().Format(time.RFC3339), snapshot.LastError),
+		Skipped: true,
 	}
+}
 
-	// Parses the selector
-	selector, err := a.parseSelector(in.Selector)
+// getLights returns the list of corresponding lights in the selector.
+// The lights are served from the cache, unless a fresh state is requested.
+// It returns snapshots of the lights, so they are not modified while they are encoded.
+func (a *API) getDevices(c *gin.Context, in *DevicesIn) ([]*lifx.Lifx, error) {
+	devices, err := a.selectDevices("get-devices", in.Selector)
 	if err != nil {
 		return nil, err
 	}
 
-	logger.WithField("selector", selector).Debug("selector found")
-	return a.sortBySelector(selector)
+	// Updates the selected devices
+	if in.Fresh {
+		ctx, cancel := a.context(c)
+		defer cancel()
+
+		if err := a.updateLifx(ctx, devices); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshots := make([]*lifx.Lifx, len(devices))
+	for i, device := range devices {
+		snapshots[i] = device.Snapshot()
+	}
+
+	return snapshots, nil
 }
 
 // setState sets a new state to the corresponding lights in the selector.
 func (a *API) setState(c *gin.Context, in *StateIn) ([]*ResultOut, error) {
-	logger := log.WithField("action", "set-state")
+	var hsbk *lifx.HSBK
+	if in.HSBK != nil {
+		hsbk = in.HSBK.toHSBK()
+	}
 
-	// Parses the selector
-	selector, err := a.parseSelector(in.Selector)
+	state := &lifx.State{
+		Power:    lifx.Power(in.Power),
+		HSBK:     hsbk,
+		Label:    in.Label,
+		Infrared: in.Infrared,
+	}
+
+	return a.perform(c, "set-state", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetStateContext(ctx, state, in.Duration)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// toggle toggles the power of the corresponding lights in the selector.
+func (a *API) toggle(c *gin.Context, in *DurationIn) ([]*ResultOut, error) {
+	return a.perform(c, "toggle", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.ToggleContext(ctx, a.config.MaxBrightness, in.Duration)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// pulse performs a pulse effect on the corresponding lights in the selector.
+// The lights switch between their color, or the starting color, and the color of the effect.
+func (a *API) pulse(c *gin.Context, in *EffectIn) ([]*ResultOut, error) {
+	return a.performEffect(c, "pulse", in, lifx.WaveformPulse)
+}
+
+// breathe performs a breathe effect on the corresponding lights in the selector.
+// The lights fade smoothly between their color, or the starting color, and the color of the effect.
+func (a *API) breathe(c *gin.Context, in *EffectIn) ([]*ResultOut, error) {
+	return a.performEffect(c, "breathe", in, lifx.WaveformSine)
+}
+
+// performEffect performs a waveform effect on the corresponding lights in the selector.
+func (a *API) performEffect(c *gin.Context, action string, in *EffectIn, waveform lifx.Waveform) ([]*ResultOut, error) {
+	if in.Color == nil {
+		return nil, errors.NewNotValid(nil, "color of the effect is missing")
+	}
+
+	effect := in.toEffect(waveform)
+	return a.perform(c, action, in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetWaveformContext(ctx, effect)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// move runs a move effect with the firmware of the corresponding multizone lights in the selector.
+// The colors of the zones move along the strips.
+func (a *API) move(c *gin.Context, in *FirmwareEffectIn) ([]*ResultOut, error) {
+	return a.performFirmwareEffect(c, "move", in, lifx.EffectMove)
+}
+
+// morph runs a morph effect with the firmware of the corresponding matrix lights in the selector.
+// The colors of the palette morph over the tiles.
+func (a *API) morph(c *gin.Context, in *FirmwareEffectIn) ([]*ResultOut, error) {
+	return a.performFirmwareEffect(c, "morph", in, lifx.EffectMorph)
+}
+
+// flame runs a flame effect with the firmware of the corresponding matrix lights in the selector.
+func (a *API) flame(c *gin.Context, in *FirmwareEffectIn) ([]*ResultOut, error) {
+	return a.performFirmwareEffect(c, "flame", in, lifx.EffectFlame)
+}
+
+// sky runs a sky effect with the firmware of the corresponding matrix lights in the selector.
+func (a *API) sky(c *gin.Context, in *FirmwareEffectIn) ([]*ResultOut, error) {
+	return a.performFirmwareEffect(c, "sky", in, lifx.EffectSky)
+}
+
+// effectsOff stops the effects run by the firmware of the corresponding lights in the selector.
+func (a *API) effectsOff(c *gin.Context, in *SelectorIn) ([]*ResultOut, error) {
+	return a.performFirmwareEffect(c, "effects-off", &FirmwareEffectIn{Selector: in.Selector}, lifx.EffectOff)
+}
+
+// performFirmwareEffect runs an effect with the firmware of the corresponding lights in the selector.
+// The animations played on the lights are stopped, so they do not hide the effect.
+// The lights which do not support the effect get an error result.
+func (a *API) performFirmwareEffect(c *gin.Context, action string, in *FirmwareEffectIn, effectType lifx.FirmwareEffectType) ([]*ResultOut, error) {
+	effect, err := in.toFirmwareEffect(effectType)
 	if err != nil {
 		return nil, err
 	}
 
-	logger.WithField("selector", selector).Debug("selector found")
+	return a.perform(c, action, in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		a.stopAnimation(device)
+		delivery, err := device.SetFirmwareEffectContext(ctx, effect)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// setZones sets the colors of the zones of the corresponding multizone lights in the selector.
+// The lights which are not multizone get an error result.
+func (a *API) setZones(c *gin.Context, in *ZonesIn) ([]*ResultOut, error) {
+	apply, ok := applicationRequests[in.Apply]
+	if !ok {
+		return nil, errors.NotValidf("apply `%s`", in.Apply)
+	}
+
+	if (in.HSBK == nil) == (len(in.Colors) == 0) {
+		return nil, errors.NewNotValid(nil, "either hsbk or colors must be set")
+	}
+
+	// Sets a color on each zone
+	if len(in.Colors) > 0 {
+		colors := make([]lifx.HSBK, len(in.Colors))
+		for i, color := range in.Colors {
+			if color == nil {
+				return nil, errors.NotValidf("color of zone %d", int(in.Start)+i)
+			}
+			colors[i] = *color.toHSBK()
+		}
+
+		return a.perform(c, "set-zones", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+			delivery, err := device.SetZonesContext(ctx, in.Start, colors, in.Duration, apply)
+			return newResultOut(device, delivery, err)
+		})
+	}
+
+	// Sets a single color on a range of zones
+	start := in.Start
+	end := start
+	if in.End != nil {
+		end = *in.End
+	}
+
+	if end < start {
+		return nil, errors.NotValidf("zones from %d to %d", start, end)
+	}
 
-	devices, err := a.sortBySelector(selector)
+	hsbk := in.HSBK.toHSBK()
+	return a.perform(c, "set-zones", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetZoneRangeContext(ctx, start, end, hsbk, in.Duration, apply)
+		return newResultOut(device, delivery, err)
+	})
+}
 
+// getTiles returns the tiles of the corresponding matrix lights in the selector.
+// The tiles are served from the cache, unless a fresh state is requested.
+func (a *API) getTiles(c *gin.Context, in *DevicesIn) ([]*ChainOut, error) {
+	devices, err := a.getDevices(c, in)
 	if err != nil {
 		return nil, err
 	}
 
-	var hsbk *lifx.HSBK
-	if in.HSBK != nil {
-		hsbk = &lifx.HSBK{
-			Hue:        in.HSBK.Hue,
-			Saturation: in.HSBK.Saturation,
-			Brightness: in.HSBK.Brightness,
-			Kelvin:     in.HSBK.Kelvin,
+	chains := []*ChainOut{}
+	for _, device := range devices {
+		if device.Chain == nil {
+			continue
 		}
+
+		chains = append(chains, &ChainOut{
+			UUID:   device.UUID,
+			Serial: device.Serial,
+			Label:  device.Label,
+			Chain:  device.Chain,
+		})
 	}
 
-	state := &lifx.State{
-		Power: lifx.Power(in.Power),
-		HSBK:  hsbk,
-		Label: in.Label,
+	if len(chains) == 0 {
+		return nil, errors.NotFoundf("matrix devices corresponding to selector %s", in.Selector)
 	}
 
-	// results contains the result of each performed operation.
-	results := []*ResultOut{}
-	for _, device := range devices {
-		err := device.SetState(state, in.Duration)
-		result := &ResultOut{
-			UUID:  device.UUID,
-			Label: device.Label,
-			Error: err,
+	return chains, nil
+}
+
+// setTile sets the colors of a tile of the corresponding matrix lights in the selector.
+// The lights which are not matrix lights get an error result.
+func (a *API) setTile(c *gin.Context, in *TileIn) ([]*ResultOut, error) {
+	colors := make([]lifx.HSBK, len(in.Colors))
+	for i, color := range in.Colors {
+		if color == nil {
+			return nil, errors.NotValidf("color of zone %d", i)
 		}
-		results = append(results, result)
+		colors[i] = *color.toHSBK()
 	}
 
-	return results, nil
+	return a.perform(c, "set-tile", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetTileContext(ctx, in.Index, colors, in.Duration)
+		return newResultOut(device, delivery, err)
+	})
 }
 
-// toggle toggles the power of the corresponding lights in the selector.
-func (a *API) toggle(c *gin.Context, in *DurationIn) ([]*ResultOut, error) {
-	logger := log.WithField("action", "toggle")
+// getGroups returns the groups of the corresponding lights in the selector.
+func (a *API) getGroups(c *gin.Context, in *DevicesIn) ([]*CollectionOut, error) {
+	return a.getCollections(c, in, groups)
+}
+
+// createGroup creates a group with the corresponding lights in the selector.
+func (a *API) createGroup(c *gin.Context, in *CollectionIn) (*CollectionOut, error) {
+	return a.createCollection(c, in, groups)
+}
+
+// renameGroup renames a group.
+func (a *API) renameGroup(c *gin.Context, in *RenameIn) (*CollectionOut, error) {
+	return a.renameCollection(c, in, groups)
+}
+
+// moveToGroup moves the corresponding lights in the selector to a group.
+func (a *API) moveToGroup(c *gin.Context, in *MoveIn) (*CollectionOut, error) {
+	return a.moveToCollection(c, in, groups)
+}
+
+// getLocations returns the locations of the corresponding lights in the selector.
+func (a *API) getLocations(c *gin.Context, in *DevicesIn) ([]*CollectionOut, error) {
+	return a.getCollections(c, in, locations)
+}
+
+// createLocation creates a location with the corresponding lights in the selector.
+func (a *API) createLocation(c *gin.Context, in *CollectionIn) (*CollectionOut, error) {
+	return a.createCollection(c, in, locations)
+}
+
+// renameLocation renames a location.
+func (a *API) renameLocation(c *gin.Context, in *RenameIn) (*CollectionOut, error) {
+	return a.renameCollection(c, in, locations)
+}
+
+// moveToLocation moves the corresponding lights in the selector to a location.
+func (a *API) moveToLocation(c *gin.Context, in *MoveIn) (*CollectionOut, error) {
+	return a.moveToCollection(c, in, locations)
+}
+
+// getCollections returns the groups or the locations of the corresponding lights in the selector.
+func (a *API) getCollections(c *gin.Context, in *DevicesIn, coll *collection) ([]*CollectionOut, error) {
+	snapshots, err := a.getDevices(c, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return coll.collect(snapshots), nil
+}
+
+// createCollection generates the ID of a new group or location,
+// and moves the corresponding lights in the selector to it.
+func (a *API) createCollection(c *gin.Context, in *CollectionIn, coll *collection) (*CollectionOut, error) {
+	id, err := lifx.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &CollectionOut{
+		ID:        id,
+		Label:     in.Label,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	out.Results, err = a.perform(c, "create-"+coll.name, in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := coll.write(ctx, device, id, out.Label, out.UpdatedAt)
+		return newResultOut(device, delivery, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// renameCollection writes the new label of a group or a location to each of its lights.
+// The lights which are not renamed keep an older update time, so the new label wins.
+func (a *API) renameCollection(c *gin.Context, in *RenameIn, coll *collection) (*CollectionOut, error) {
+	id, err := lifx.ParseID(in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, devices, err := coll.find(a.registry, id)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &CollectionOut{
+		ID:        id,
+		Label:     in.Label,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	out.Results = a.performOn(c, devices, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := coll.write(ctx, device, id, out.Label, out.UpdatedAt)
+		return newResultOut(device, delivery, err)
+	})
+
+	return out, nil
+}
+
+// moveToCollection moves the corresponding lights in the selector to an existing group or location.
+func (a *API) moveToCollection(c *gin.Context, in *MoveIn, coll *collection) (*CollectionOut, error) {
+	id, err := lifx.ParseID(in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, _, err := coll.find(a.registry, id)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &CollectionOut{
+		ID:        current.ID,
+		Label:     current.Label,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	out.Results, err = a.perform(c, "move-to-"+coll.name, in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := coll.write(ctx, device, id, out.Label, out.UpdatedAt)
+		return newResultOut(device, delivery, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ping sends echo probes to the corresponding lights in the selector,
+// and returns the statistics of the probes of each light.
+// The probes do not change the state of the lights.
+func (a *API) ping(c *gin.Context, in *PingIn) ([]*PingOut, error) {
+	devices, err := a.selectDevices("ping", in.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := a.context(c)
+	defer cancel()
+
+	// The unreachable devices are skipped.
+	devices, skipped := reachable(devices)
+
+	outs := make([]*PingOut, len(devices), len(devices)+len(skipped))
+	results := a.fanOut(ctx, devices, func(ctx context.Context, i int, device *lifx.Lifx) *ResultOut {
+		latency, err := device.PingContext(ctx, in.Count)
+		outs[i] = &PingOut{
+			ResultOut: newResultOut(device, nil, err),
+			Latency:   latency,
+		}
 
-	if len(a.config.Lifx) == 0 {
-		return nil, errors.NewNotProvisioned(nil, "list of Lifx devices")
+		return outs[i].ResultOut
+	})
+
+	// The devices which have not been probed before the timeout only get their result.
+	for i, result := range results {
+		if outs[i] == nil {
+			outs[i] = &PingOut{ResultOut: result}
+		}
+	}
+
+	for _, device := range skipped {
+		outs = append(outs, &PingOut{ResultOut: newSkippedResultOut(device)})
 	}
 
-	// Parses the selector
-	selector, err := a.parseSelector(in.Selector)
+	return outs, nil
+}
+
+// getDiagnostics reads the diagnostics of the corresponding lights in the selector.
+// The lights which cannot be read get an error result, without diagnostics.
+func (a *API) getDiagnostics(c *gin.Context, in *SelectorIn) ([]*DiagnosticsOut, error) {
+	devices, err := a.selectDevices("diagnostics", in.Selector)
 	if err != nil {
 		return nil, err
 	}
 
-	logger.WithField("selector", selector).Debug("selector found")
-	// Sorts the array of known devices to return every corresponding devices
-	// to the selector.
-	devices, err := a.sortBySelector(selector)
+	ctx, cancel := a.context(c)
+	defer cancel()
+
+	// The unreachable devices are skipped.
+	devices, skipped := reachable(devices)
+
+	outs := make([]*DiagnosticsOut, len(devices), len(devices)+len(skipped))
+	results := a.fanOut(ctx, devices, func(ctx context.Context, i int, device *lifx.Lifx) *ResultOut {
+		diagnostics, err := device.ReadDiagnosticsContext(ctx)
+		outs[i] = &DiagnosticsOut{
+			ResultOut:   newResultOut(device, nil, err),
+			Diagnostics: diagnostics,
+		}
+
+		return outs[i].ResultOut
+	})
+
+	// The devices which have not been read before the timeout only get their result.
+	for i, result := range results {
+		if outs[i] == nil {
+			outs[i] = &DiagnosticsOut{ResultOut: result}
+		}
+	}
+
+	for _, device := range skipped {
+		outs = append(outs, &DiagnosticsOut{ResultOut: newSkippedResultOut(device)})
+	}
+
+	return outs, nil
+}
+
+// getHev returns the state of the HEV light of the corresponding HEV lights in the selector.
+func (a *API) getHev(c *gin.Context, in *DevicesIn) ([]*HevOut, error) {
+	devices, err := a.getDevices(c, in)
 	if err != nil {
 		return nil, err
 	}
 
-	// results contains the result of each performed operation.
-	results := []*ResultOut{}
+	hevs := []*HevOut{}
 	for _, device := range devices {
-		err := device.Toggle(a.config.MaxBrightness, in.Duration)
-		result := &ResultOut{
-			UUID:  device.UUID,
-			Label: device.Label,
-			Error: err,
+		if device.Hev == nil {
+			continue
+		}
+
+		hevs = append(hevs, &HevOut{
+			UUID:   device.UUID,
+			Serial: device.Serial,
+			Label:  device.Label,
+			Hev:    device.Hev,
+		})
+	}
+
+	if len(hevs) == 0 {
+		return nil, errors.NotFoundf("HEV devices corresponding to selector %s", in.Selector)
+	}
+
+	return hevs, nil
+}
+
+// startHevCycle starts a HEV cycle on the corresponding HEV lights in the selector.
+// The lights which are not HEV lights get an error result.
+func (a *API) startHevCycle(c *gin.Context, in *HevCycleIn) ([]*ResultOut, error) {
+	duration := time.Duration(in.Duration) * time.Millisecond
+	return a.perform(c, "start-hev-cycle", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetHevCycleContext(ctx, true, duration)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// stopHevCycle stops the HEV cycle of the corresponding HEV lights in the selector.
+// The lights which are not HEV lights get an error result.
+func (a *API) stopHevCycle(c *gin.Context, in *SelectorIn) ([]*ResultOut, error) {
+	return a.perform(c, "stop-hev-cycle", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetHevCycleContext(ctx, false, 0)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// setHevConfiguration sets the default duration and the indication of the HEV cycles
+// of the corresponding HEV lights in the selector.
+// The lights which are not HEV lights get an error result.
+func (a *API) setHevConfiguration(c *gin.Context, in *HevConfigurationIn) ([]*ResultOut, error) {
+	duration := time.Duration(in.Duration) * time.Millisecond
+	return a.perform(c, "set-hev-configuration", in.Selector, func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		delivery, err := device.SetHevCycleConfigurationContext(ctx, in.Indication, duration)
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// drawImage draws an uploaded image on the corresponding matrix or multizone lights in the selector.
+// The image is the image field of a multipart form, or the body of the request.
+// The animated GIFs are played in background.
+// It is not a tonic handler, because tonic binds the body of the requests as JSON.
+func (a *API) drawImage(c *gin.Context) {
+	results, err := a.performImage(c)
+	if err != nil {
+		c.JSON(jujerr.ErrHook(c, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// performImage decodes the uploaded image and draws its first frame on the lights.
+// The animation previously played on a light is replaced.
+func (a *API) performImage(c *gin.Context) ([]*ResultOut, error) {
+	var duration uint64
+	if value := c.Query("duration"); len(value) > 0 {
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, errors.NewNotValid(err, "duration")
+		}
+		duration = parsed
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageSize)
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, _, err := c.Request.FormFile("image")
+		if err != nil {
+			return nil, errors.NewNotValid(err, "image field")
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "cannot read image")
+	}
+
+	animation, err := lifx.DecodeAnimation(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return a.perform(c, "draw-image", c.Query("selector"), func(ctx context.Context, device *lifx.Lifx) *ResultOut {
+		a.stopAnimation(device)
+		delivery, err := device.DrawContext(ctx, animation.Frames[0], uint32(duration))
+		if err == nil && len(animation.Frames) > 1 {
+			a.animate(device, animation)
 		}
-		results = append(results, result)
+
+		return newResultOut(device, delivery, err)
+	})
+}
+
+// stopAnimations stops the animations played on the corresponding lights in the selector.
+// No message is sent to the lights, so the animations of the unreachable lights are stopped too.
+func (a *API) stopAnimations(c *gin.Context, in *SelectorIn) ([]*ResultOut, error) {
+	devices, err := a.selectDevices("stop-animations", in.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*ResultOut, len(devices))
+	for i, device := range devices {
+		if !a.stopAnimation(device) {
+			results[i] = newResultOut(device, nil, errors.NotFoundf("animation of device %s", device.UUID))
+			continue
+		}
+
+		results[i] = newResultOut(device, nil, nil)
 	}
 
 	return results, nil
 }
+
+// discover discovers the lights of the local network and returns the new ones.
+func (a *API) discover(c *gin.Context) ([]*lifx.Lifx, error) {
+	logger := log.WithField("action", "discover")
+
+	if len(a.config.Domain) == 0 {
+		return nil, errors.NewNotProvisioned(nil, "domain")
+	}
+
+	ctx, cancel := a.context(c)
+	defer cancel()
+
+	devices, err := a.discoverLifx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithField("count", len(devices)).Debug("new devices discovered")
+	return devices, nil
+}
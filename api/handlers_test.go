@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/lifx"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/juju/errors"
+)
+
+func TestToEffect(t *testing.T) {
+	color := &HSBKIn{Hue: 21845, Saturation: 65535, Brightness: 65535, Kelvin: 3500}
+	from := &HSBKIn{Hue: 0, Saturation: 0, Brightness: 32768, Kelvin: 2700}
+	off := false
+	peak := 0.25
+
+	// The default values are modelled on the LIFX HTTP API.
+	effect := (&EffectIn{Color: color}).toEffect(lifx.WaveformPulse)
+	expected := lifx.Effect{
+		Waveform:  lifx.WaveformPulse,
+		Color:     color.toHSBK(),
+		Period:    defaultEffectPeriod,
+		Cycles:    defaultEffectCycles,
+		SkewRatio: lifx.SkewRatio(0.5),
+		PowerOn:   true,
+	}
+	if effect.FromColor != nil || *effect.Color != *expected.Color || effect.Waveform != expected.Waveform ||
+		effect.Period != expected.Period || effect.Cycles != expected.Cycles || effect.SkewRatio != expected.SkewRatio ||
+		effect.Persist || !effect.PowerOn {
+		t.Errorf("expected %+v, got %+v", expected, effect)
+	}
+
+	in := &EffectIn{Color: color, FromColor: from, Period: 500, Cycles: 2.5, Persist: true, PowerOn: &off, Peak: &peak}
+	effect = in.toEffect(lifx.WaveformSine)
+	if effect.FromColor == nil || *effect.FromColor != *from.toHSBK() || effect.Waveform != lifx.WaveformSine ||
+		effect.Period != 500 || effect.Cycles != 2.5 || effect.SkewRatio != lifx.SkewRatio(peak) ||
+		!effect.Persist || effect.PowerOn {
+		t.Errorf("unexpected effect %+v", effect)
+	}
+}
+
+func TestEffectWithoutColor(t *testing.T) {
+	a := newTestAPI(&lifx.Lifx{})
+	c := &gin.Context{Request: httptest.NewRequest("POST", "/lights/effects/pulse", nil)}
+	if _, err := a.pulse(c, &EffectIn{Selector: "all"}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+}
+
+func TestToEffectChannels(t *testing.T) {
+	color := &HSBKIn{Hue: 21845, Saturation: 65535, Brightness: 65535, Kelvin: 3500}
+	off := false
+
+	// The channels are only defined if one of them is set.
+	if effect := (&EffectIn{Color: color}).toEffect(lifx.WaveformPulse); effect.Channels != nil {
+		t.Errorf("expected no channels, got %+v", effect.Channels)
+	}
+
+	effect := (&EffectIn{Color: color, SetSaturation: &off, SetKelvin: &off}).toEffect(lifx.WaveformPulse)
+	expected := lifx.Channels{Hue: true, Saturation: false, Brightness: true, Kelvin: false}
+	if effect.Channels == nil || *effect.Channels != expected {
+		t.Errorf("expected %+v, got %+v", expected, effect.Channels)
+	}
+}
+
+func TestStopAnimations(t *testing.T) {
+	defer quickBackoff()()
+
+	// The animated device stops answering.
+	animated, conn, _ := silentDevice(t)
+	defer conn.Close()
+
+	animated.UUID = uuid.New().String()
+	if err := animated.Update(); err == nil {
+		t.Fatalf("expected the silent device to fail")
+	}
+
+	still := &lifx.Lifx{UUID: uuid.New().String()}
+	a := newTestAPI(animated, still)
+	ctx, cancel := context.WithCancel(context.Background())
+	a.animations[animated] = &playback{cancel: cancel}
+
+	// The animation of the unreachable device is stopped, without any message.
+	c := &gin.Context{Request: httptest.NewRequest("DELETE", "/lights/image", nil)}
+	results, err := a.stopAnimations(c, &SelectorIn{Selector: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	for _, result := range results {
+		switch result.UUID {
+		case animated.UUID:
+			if result.Skipped || len(result.Error) != 0 || ctx.Err() == nil {
+				t.Errorf("expected the animation to be stopped, got %+v", result)
+			}
+		case still.UUID:
+			if !strings.Contains(result.Error, "not found") {
+				t.Errorf("expected a not found error, got %+v", result)
+			}
+		default:
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	if len(a.animations) != 0 {
+		t.Errorf("expected no animation, got %d", len(a.animations))
+	}
+}
+
+func TestToFirmwareEffect(t *testing.T) {
+	// The default values are used for the missing settings.
+	effect, err := (&FirmwareEffectIn{}).toFirmwareEffect(lifx.EffectSky)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &lifx.FirmwareEffect{
+		Type:               lifx.EffectSky,
+		Speed:              defaultFirmwareEffectSpeed,
+		Sky:                lifx.SkySunrise,
+		CloudSaturationMin: defaultCloudSaturationMin,
+		CloudSaturationMax: defaultCloudSaturationMax,
+	}
+	if !reflect.DeepEqual(effect, expected) {
+		t.Errorf("expected %+v, got %+v", expected, effect)
+	}
+
+	low, high := uint8(0), uint8(255)
+	color := &HSBKIn{Hue: 21845, Saturation: 65535, Brightness: 65535, Kelvin: 3500}
+	in := &FirmwareEffectIn{Speed: 1000, Duration: 1500, Reverse: true, Palette: []*HSBKIn{color}, Sky: "clouds", CloudSaturationMin: &low, CloudSaturationMax: &high}
+	effect, err = in.toFirmwareEffect(lifx.EffectMorph)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = &lifx.FirmwareEffect{
+		Type:               lifx.EffectMorph,
+		Speed:              1000,
+		Duration:           1500 * time.Millisecond,
+		Reverse:            true,
+		Palette:            []lifx.HSBK{*color.toHSBK()},
+		Sky:                lifx.SkyClouds,
+		CloudSaturationMin: 0,
+		CloudSaturationMax: 255,
+	}
+	if !reflect.DeepEqual(effect, expected) {
+		t.Errorf("expected %+v, got %+v", expected, effect)
+	}
+
+	// The colors of the palette must be set.
+	if _, err := (&FirmwareEffectIn{Palette: []*HSBKIn{color, nil}}).toFirmwareEffect(lifx.EffectMorph); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+}
+
+func TestGetHev(t *testing.T) {
+	hev := &lifx.Hev{Duration: time.Hour, Remaining: time.Minute, LastResult: lifx.HevBusy}
+	clean := &lifx.Lifx{UUID: uuid.New().String(), Label: "Kitchen", Hev: hev}
+	a := newTestAPI(clean, &lifx.Lifx{UUID: uuid.New().String(), Label: "Desk"})
+	c := &gin.Context{Request: httptest.NewRequest("GET", "/lights/hev", nil)}
+
+	// Only the HEV lights are returned.
+	hevs, err := a.getHev(c, &DevicesIn{Selector: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hevs) != 1 || hevs[0].UUID != clean.UUID || hevs[0].Label != "Kitchen" || *hevs[0].Hev != *hev {
+		t.Fatalf("expected the HEV light, got %d lights", len(hevs))
+	}
+
+	if _, err := a.getHev(c, &DevicesIn{Selector: "label:Desk"}); !errors.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestGetDiagnostics(t *testing.T) {
+	defer quickBackoff()()
+
+	answering, conn := replyingDevice(t, func(msgType lifx.MessageType) *lifx.Message {
+		switch msgType {
+		case lifx.GetHostFirmware:
+			return lifx.NewMessageWithPayload(lifx.StateHostFirmware, &lifx.FirmwarePayload{VersionMajor: 3, VersionMinor: 70})
+		case lifx.GetWifiFirmware:
+			return lifx.NewMessageWithPayload(lifx.StateWifiFirmware, &lifx.FirmwarePayload{VersionMajor: 2, VersionMinor: 1})
+		case lifx.GetHostInfo:
+			return lifx.NewMessageWithPayload(lifx.StateHostInfo, &lifx.SignalPayload{})
+		case lifx.GetWifiInfo:
+			return lifx.NewMessageWithPayload(lifx.StateWifiInfo, &lifx.SignalPayload{Signal: 1e-6})
+		case lifx.GetInfo:
+			return lifx.NewMessageWithPayload(lifx.StateInfo, &lifx.StateInfoPayload{Time: uint64(time.Now().UnixNano())})
+		}
+		return nil
+	})
+	defer conn.Close()
+
+	silent, conn, _ := silentDevice(t)
+	defer conn.Close()
+
+	// The unreachable device stopped answering before.
+	unreachable, conn, _ := silentDevice(t)
+	defer conn.Close()
+	if err := unreachable.Update(); err == nil {
+		t.Fatalf("expected the silent device to fail")
+	}
+
+	answering.UUID, silent.UUID, unreachable.UUID = "answering", "silent", "unreachable"
+	a := newTestAPI(unreachable, silent, answering)
+	c := &gin.Context{Request: httptest.NewRequest("GET", "/lights/diagnostics", nil)}
+	outs, err := a.getDiagnostics(c, &SelectorIn{Selector: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outs) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(outs))
+	}
+
+	// The skipped devices come last.
+	if out := outs[2]; out.UUID != "unreachable" || !out.Skipped || out.Diagnostics != nil {
+		t.Errorf("expected the unreachable device to be skipped, got %+v", out)
+	}
+
+	for _, out := range outs[:2] {
+		switch out.UUID {
+		case "answering":
+			if len(out.Error) != 0 || out.Diagnostics == nil || out.Diagnostics.HostFirmware.Version != "3.70" || out.Diagnostics.Wifi.RSSI != -60 {
+				t.Errorf("expected the diagnostics of the answering device, got %+v", out)
+			}
+		case "silent":
+			if len(out.Error) == 0 || out.Diagnostics != nil {
+				t.Errorf("expected an error without diagnostics, got %+v", out)
+			}
+		default:
+			t.Errorf("unexpected result %+v", out)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	defer quickBackoff()()
+
+	silent, conn, _ := silentDevice(t)
+	defer conn.Close()
+
+	// The unreachable device stopped answering before.
+	unreachable, conn, _ := silentDevice(t)
+	defer conn.Close()
+	if err := unreachable.Update(); err == nil {
+		t.Fatalf("expected the silent device to fail")
+	}
+
+	silent.UUID, unreachable.UUID = "silent", "unreachable"
+	a := newTestAPI(unreachable, silent)
+	c := &gin.Context{Request: httptest.NewRequest("GET", "/lights/ping", nil)}
+	outs, err := a.ping(c, &PingIn{Selector: "all", Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outs) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(outs))
+	}
+
+	// The lost probes are counted, and the skipped devices come last.
+	if out := outs[0]; out.UUID != "silent" || len(out.Error) == 0 || out.Latency == nil || out.Latency.Lost != 1 {
+		t.Errorf("expected the probe of the silent device to be lost, got %+v", out)
+	}
+
+	if out := outs[1]; out.UUID != "unreachable" || !out.Skipped || out.Latency != nil {
+		t.Errorf("expected the unreachable device to be skipped, got %+v", out)
+	}
+}
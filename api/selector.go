@@ -0,0 +1,433 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"unicode"
+
+	"github.com/fberrez/horus/lifx"
+	"github.com/juju/errors"
+)
+
+type (
+	// expression is a parsed selector: a list of terms separated by commas.
+	// It selects the devices matched by any of its terms, except the devices matched by its negated terms.
+	// If one of its terms is random, only one of the selected devices is kept, picked at random.
+	expression struct {
+		// text is the selector as written in the request.
+		text string
+
+		// terms contains the terms of the selector.
+		terms []*term
+	}
+
+	// term is a part of a selector, such as `label:Desk*` or `!group:Office`.
+	term struct {
+		// selector is the type of the term.
+		selector *selector
+
+		// pattern is the value of a dynamic term.
+		pattern pattern
+
+		// negated determines if the matched devices are excluded from the selection.
+		negated bool
+
+		// random determines if only one of the devices selected by the expression is kept, picked at random.
+		random bool
+	}
+
+	// pattern is the value of a dynamic term, matched regardless of the case.
+	// Its unescaped `*` and `?` characters are wildcards, matching any characters or any single character.
+	pattern []patternChar
+
+	// patternChar is a character of a pattern.
+	patternChar struct {
+		// r is the character.
+		r rune
+
+		// literal determines if the character has been escaped or quoted,
+		// so it is neither a wildcard nor a separator.
+		literal bool
+	}
+
+	// selectorParser parses a selector, character by character.
+	selectorParser struct {
+		// text is the parsed selector.
+		text string
+
+		// runes contains the characters of the parsed selector.
+		runes []rune
+
+		// pos is the position of the next character to parse.
+		pos int
+
+		// selectors contains the known types of selectors.
+		selectors []*selector
+	}
+)
+
+// randomSuffix is the suffix of the dynamic terms which keep only one of the selected devices.
+const randomSuffix = ":random"
+
+// parseSelector parses a selector, made of terms separated by commas. For example:
+//
+//	label:Desk,group:Kitchen    the light called `Desk` and the lights of the group `Kitchen`
+//	label:desk*                 the lights whose label starts with `desk`, regardless of the case
+//	group:Office,!label:TV      the lights of the group `Office`, except the light called `TV`
+//	group:Kitchen:random        one light of the group `Kitchen`, picked at random
+//	label:Desk*,random          one of the lights whose label starts with `Desk`, picked at random
+//	label:"Desk, left"          the light called `Desk, left`
+//
+// A backslash escapes the next character, and a quoted text is taken literally.
+// The errors point to the position of the first invalid character.
+func (a *API) parseSelector(text string) (*expression, error) {
+	// If the selector is empty, it selects every device.
+	if strings.TrimSpace(text) == "" {
+		return &expression{text: all.name, terms: []*term{{selector: all}}}, nil
+	}
+
+	p := &selectorParser{
+		text:      text,
+		runes:     []rune(text),
+		selectors: a.selectors,
+	}
+
+	expr := &expression{text: text}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		expr.terms = append(expr.terms, t)
+
+		p.skipSpaces()
+		if p.done() {
+			return expr, nil
+		}
+
+		if p.peek() != ',' {
+			return nil, p.errorf(p.pos, "expected `,` instead of `%c`", p.peek())
+		}
+		p.pos++
+	}
+}
+
+// term parses a term, until the next comma.
+func (p *selectorParser) term() (*term, error) {
+	p.skipSpaces()
+	t := &term{}
+	if !p.done() && p.peek() == '!' {
+		t.negated = true
+		p.pos++
+		p.skipSpaces()
+	}
+
+	// Parses the type of the term
+	start := p.pos
+	for !p.done() && (unicode.IsLetter(p.peek()) || p.peek() == '_') {
+		p.pos++
+	}
+
+	name := strings.ToLower(string(p.runes[start:p.pos]))
+	if name == "" {
+		if p.done() || p.peek() == ',' {
+			return nil, p.errorf(start, "empty term")
+		}
+
+		return nil, p.errorf(start, "expected a selector type instead of `%c`", p.peek())
+	}
+
+	for _, s := range p.selectors {
+		if name == s.name {
+			t.selector = s
+		}
+	}
+
+	if t.selector == nil {
+		return nil, p.errorf(start, "unknown selector type `%s`", name)
+	}
+
+	p.skipSpaces()
+	if !t.selector.isDynamic {
+		if !p.done() && p.peek() == ':' {
+			return nil, p.errorf(p.pos, "unexpected value of the static selector `%s`", name)
+		}
+
+		if t.negated && t.selector == random {
+			return nil, p.errorf(start, "the static selector `%s` cannot be negated", name)
+		}
+
+		t.random = t.selector == random
+		return t, nil
+	}
+
+	if p.done() || p.peek() != ':' {
+		return nil, p.errorf(p.pos, "expected `:` after the dynamic selector `%s`", name)
+	}
+	p.pos++
+
+	if t.selector.field == nil {
+		return nil, errors.NotImplementedf("selector %s", name)
+	}
+
+	// Parses the value of the term
+	p.skipSpaces()
+	start = p.pos
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	t.pattern, t.random = value.cutRandom()
+	if len(t.pattern) == 0 {
+		return nil, p.errorf(start, "empty value of the selector `%s`", name)
+	}
+
+	// The values of the selectors which have a format, such as the serials, are verified.
+	if t.selector.normalize != nil && !t.pattern.hasWildcards() {
+		normalized, err := t.selector.normalize(t.pattern.String())
+		if err != nil {
+			return nil, p.errorf(start, "%s", err)
+		}
+
+		t.pattern = literalPattern(normalized)
+	}
+
+	return t, nil
+}
+
+// value parses the value of a dynamic term, until the next comma which is neither escaped nor quoted.
+// The spaces around the value are ignored, unless they are escaped or quoted.
+func (p *selectorParser) value() (pattern, error) {
+	value := pattern{}
+	for !p.done() && p.peek() != ',' {
+		r := p.next()
+		switch r {
+		case '"':
+			quote := p.pos - 1
+			for {
+				if p.done() {
+					return nil, p.errorf(quote, "unterminated quoted text")
+				}
+
+				r = p.next()
+				if r == '"' {
+					break
+				}
+
+				if r == '\\' {
+					if p.done() {
+						return nil, p.errorf(p.pos-1, "unterminated escape sequence")
+					}
+					r = p.next()
+				}
+				value = append(value, patternChar{r: r, literal: true})
+			}
+		case '\\':
+			if p.done() {
+				return nil, p.errorf(p.pos-1, "unterminated escape sequence")
+			}
+			value = append(value, patternChar{r: p.next(), literal: true})
+		default:
+			value = append(value, patternChar{r: r})
+		}
+	}
+
+	return value.trimSpaces(), nil
+}
+
+// skipSpaces skips the spaces before the next character to parse.
+func (p *selectorParser) skipSpaces() {
+	for !p.done() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// done returns true if every character has been parsed.
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.runes)
+}
+
+// peek returns the next character to parse.
+func (p *selectorParser) peek() rune {
+	return p.runes[p.pos]
+}
+
+// next returns the next character to parse and moves past it.
+func (p *selectorParser) next() rune {
+	r := p.runes[p.pos]
+	p.pos++
+	return r
+}
+
+// errorf returns an error pointing to the character at the given position.
+// The characters are counted from 1.
+func (p *selectorParser) errorf(pos int, format string, args ...interface{}) error {
+	return errors.NewNotValid(nil, fmt.Sprintf("selector `%s` not valid: %s at character %d", p.text, fmt.Sprintf(format, args...), pos+1))
+}
+
+// literalPattern returns a pattern matching the given text only.
+func literalPattern(text string) pattern {
+	value := pattern{}
+	for _, r := range text {
+		value = append(value, patternChar{r: r, literal: true})
+	}
+
+	return value
+}
+
+// cutRandom removes the `:random` suffix of the pattern, if it is neither escaped nor quoted.
+// It returns true if the suffix has been removed.
+func (v pattern) cutRandom() (pattern, bool) {
+	suffix := []rune(randomSuffix)
+	if len(v) < len(suffix) {
+		return v, false
+	}
+
+	tail := v[len(v)-len(suffix):]
+	for i, c := range tail {
+		if c.literal || unicode.ToLower(c.r) != suffix[i] {
+			return v, false
+		}
+	}
+
+	return v[:len(v)-len(suffix)].trimSpaces(), true
+}
+
+// trimSpaces removes the spaces at the end of the pattern, unless they are escaped or quoted.
+func (v pattern) trimSpaces() pattern {
+	for len(v) > 0 && !v[len(v)-1].literal && unicode.IsSpace(v[len(v)-1].r) {
+		v = v[:len(v)-1]
+	}
+
+	return v
+}
+
+// isWildcard returns true if the character matches any characters (`*`) or any single character (`?`).
+func (c patternChar) isWildcard() bool {
+	return !c.literal && (c.r == '*' || c.r == '?')
+}
+
+// hasWildcards returns true if the pattern contains a wildcard.
+func (v pattern) hasWildcards() bool {
+	for _, c := range v {
+		if c.isWildcard() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the characters of the pattern.
+func (v pattern) String() string {
+	runes := make([]rune, len(v))
+	for i, c := range v {
+		runes[i] = c.r
+	}
+
+	return string(runes)
+}
+
+// match returns true if the text matches the pattern, regardless of the case.
+// When a character does not match, the last `*` wildcard is extended by one character.
+func (v pattern) match(text string) bool {
+	runes := []rune(text)
+	i, j := 0, 0
+	star, mark := -1, 0
+	for j < len(runes) {
+		switch {
+		case i < len(v) && v[i].isWildcard() && v[i].r == '*':
+			star, mark = i, j
+			i++
+		case i < len(v) && (v[i].isWildcard() || unicode.ToLower(v[i].r) == unicode.ToLower(runes[j])):
+			i++
+			j++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+
+	for i < len(v) && v[i].isWildcard() && v[i].r == '*' {
+		i++
+	}
+
+	return i == len(v)
+}
+
+// matches returns true if the device is matched by the term, regardless of its negation.
+// The static terms match every device.
+func (t *term) matches(snapshot *lifx.Lifx) bool {
+	if !t.selector.isDynamic {
+		return true
+	}
+
+	value, ok := t.selector.field(snapshot)
+	return ok && t.pattern.match(value)
+}
+
+// String returns the selector as written in the request.
+func (e *expression) String() string {
+	return e.text
+}
+
+// sortBySelector returns the devices selected by the expression, in the order of the registry.
+// The devices matched by the negated terms are excluded from the devices matched by the other terms,
+// or from every device if every term is negated. The `random` terms do not match any device:
+// once the selection is complete, they keep only one of the selected devices, picked at random.
+// If no device is selected, it returns an error.
+func (a *API) sortBySelector(expr *expression) ([]*lifx.Lifx, error) {
+	devices := a.registry.Devices()
+
+	// The fields of the devices are read from snapshots,
+	// since the devices may be updated at the same time.
+	snapshots := make([]*lifx.Lifx, len(devices))
+	for i, device := range devices {
+		snapshots[i] = device.Snapshot()
+	}
+
+	included := make([]bool, len(devices))
+	excluded := make([]bool, len(devices))
+	hasIncluding, pick := false, false
+	for _, t := range expr.terms {
+		pick = pick || t.random
+		if t.selector == random {
+			continue
+		}
+
+		for i, snapshot := range snapshots {
+			if !t.matches(snapshot) {
+				continue
+			}
+
+			if t.negated {
+				excluded[i] = true
+			} else {
+				included[i] = true
+			}
+		}
+
+		hasIncluding = hasIncluding || !t.negated
+	}
+
+	selected := []*lifx.Lifx{}
+	for i, device := range devices {
+		if (included[i] || !hasIncluding) && !excluded[i] {
+			selected = append(selected, device)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.NotFoundf("devices corresponding to selector `%s`", expr)
+	}
+
+	if pick {
+		selected = []*lifx.Lifx{selected[rand.Intn(len(selected))]}
+	}
+
+	return selected, nil
+}
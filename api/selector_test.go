@@ -0,0 +1,206 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fberrez/horus/lifx"
+	"github.com/juju/errors"
+)
+
+// newSelectorAPI returns an API whose registry contains a few devices in several groups.
+func newSelectorAPI(t *testing.T) *API {
+	kitchen, err := lifx.ParseID("0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := func(uuid, label, serial string, group *lifx.Group) *lifx.Lifx {
+		s, err := lifx.ParseSerial(serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &lifx.Lifx{UUID: uuid, Label: label, Serial: s, Group: group}
+	}
+
+	office := &lifx.Group{Label: "Office"}
+	kitchenGroup := &lifx.Group{ID: kitchen, Label: "Kitchen"}
+	living := &lifx.Group{Label: "Living"}
+
+	return &API{
+		selectors: []*selector{all, random, label, id, serial, groupID, group, locationID, location, sceneID},
+		registry: lifx.NewRegistry([]*lifx.Lifx{
+			device("1", "Desk", "d073d5000001", office),
+			device("2", "Desk lamp", "d073d5000002", office),
+			device("3", "Printer lamp", "d073d5000003", office),
+			device("4", "Fridge", "d073d5000004", kitchenGroup),
+			device("5", "Toaster", "d073d5000005", kitchenGroup),
+			device("6", "TV: backlight", "d073d5000006", living),
+			device("7", "A, B", "d073d5000007", living),
+		}),
+	}
+}
+
+// selectLabels returns the labels of the devices selected by the selector.
+func selectLabels(a *API, text string) ([]string, error) {
+	expr, err := a.parseSelector(text)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := a.sortBySelector(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, len(devices))
+	for i, device := range devices {
+		labels[i] = device.Snapshot().Label
+	}
+
+	return labels, nil
+}
+
+func TestSelectors(t *testing.T) {
+	a := newSelectorAPI(t)
+	everyDevice := []string{"Desk", "Desk lamp", "Printer lamp", "Fridge", "Toaster", "TV: backlight", "A, B"}
+
+	tests := []struct {
+		selector string
+		labels   []string
+	}{
+		{"", everyDevice},
+		{"all", everyDevice},
+		{"label:Desk", []string{"Desk"}},
+		{"label:desk", []string{"Desk"}},
+		{"LABEL: Desk ", []string{"Desk"}},
+		{"label:Desk*", []string{"Desk", "Desk lamp"}},
+		{"label:*LAMP", []string{"Desk lamp", "Printer lamp"}},
+		{"label:D?sk", []string{"Desk"}},
+		{"label:Desk,group:Kitchen", []string{"Desk", "Fridge", "Toaster"}},
+		{"group:Kitchen,label:Desk", []string{"Desk", "Fridge", "Toaster"}},
+		{"group:Office,!label:Printer lamp", []string{"Desk", "Desk lamp"}},
+		{"group:Office, !label:printer*", []string{"Desk", "Desk lamp"}},
+		{"!group:office", []string{"Fridge", "Toaster", "TV: backlight", "A, B"}},
+		{"!group:Office,!group:Living", []string{"Fridge", "Toaster"}},
+		{"label:*lamp,!label:printer*", []string{"Desk lamp"}},
+		{"label:TV: backlight", []string{"TV: backlight"}},
+		{`label:"A, B"`, []string{"A, B"}},
+		{`label:A\, B`, []string{"A, B"}},
+		{`label:"Desk" lamp`, []string{"Desk lamp"}},
+		{`label:"Desk*"*`, nil},
+		{`label:Desk\*`, nil},
+		{"uuid:3", []string{"Printer lamp"}},
+		{"serial:d0:73:d5:00:00:04", []string{"Fridge"}},
+		{"serial:D073D5000004", []string{"Fridge"}},
+		{"serial:d073d500000?", everyDevice},
+		{"group_id:0123456789ABCDEF0123456789abcdef", []string{"Fridge", "Toaster"}},
+		{"group_id:0123*", []string{"Fridge", "Toaster"}},
+		{"label:Nope", nil},
+		{"group:Office,!group:Office", nil},
+	}
+
+	for _, test := range tests {
+		labels, err := selectLabels(a, test.selector)
+		if test.labels == nil {
+			if !errors.IsNotFound(err) {
+				t.Errorf("selector `%s`: expected a not found error, got %v (%v)", test.selector, labels, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("selector `%s`: unexpected error: %v", test.selector, err)
+			continue
+		}
+
+		if strings.Join(labels, "|") != strings.Join(test.labels, "|") {
+			t.Errorf("selector `%s`: expected %q, got %q", test.selector, test.labels, labels)
+		}
+	}
+}
+
+func TestRandomSelectors(t *testing.T) {
+	a := newSelectorAPI(t)
+
+	tests := []struct {
+		selector string
+		labels   []string
+	}{
+		{"random", []string{"Desk", "Desk lamp", "Printer lamp", "Fridge", "Toaster", "TV: backlight", "A, B"}},
+		{"group:Kitchen:random", []string{"Fridge", "Toaster"}},
+		{"group:Kitchen:RANDOM", []string{"Fridge", "Toaster"}},
+		{"group:Kitchen:random,!label:Toaster", []string{"Fridge"}},
+		{"label:Desk*,random", []string{"Desk", "Desk lamp"}},
+		{"random,label:Desk*,!label:Desk", []string{"Desk lamp"}},
+		{"group:Kitchen:random,label:Desk", []string{"Desk", "Fridge", "Toaster"}},
+	}
+
+	for _, test := range tests {
+		// The devices are picked at random, so each selector is evaluated several times.
+		for i := 0; i < 50; i++ {
+			labels, err := selectLabels(a, test.selector)
+			if err != nil {
+				t.Fatalf("selector `%s`: unexpected error: %v", test.selector, err)
+			}
+
+			if len(labels) != 1 || !strings.Contains("|"+strings.Join(test.labels, "|")+"|", "|"+labels[0]+"|") {
+				t.Fatalf("selector `%s`: expected one of %q, got %q", test.selector, test.labels, labels)
+			}
+		}
+	}
+
+	// A quoted or escaped suffix is a part of the label.
+	for _, selector := range []string{`label:"Desk:random"`, `label:Desk\:random`} {
+		if _, err := selectLabels(a, selector); !errors.IsNotFound(err) {
+			t.Errorf("selector `%s`: expected a not found error, got %v", selector, err)
+		}
+	}
+}
+
+func TestSelectorErrors(t *testing.T) {
+	a := newSelectorAPI(t)
+
+	tests := []struct {
+		selector string
+		message  string
+	}{
+		{"label", "expected `:` after the dynamic selector `label` at character 6"},
+		{"label:", "empty value of the selector `label` at character 7"},
+		{"label: :random", "empty value of the selector `label` at character 8"},
+		{"foo:bar", "unknown selector type `foo` at character 1"},
+		{"label:Desk,foo", "unknown selector type `foo` at character 12"},
+		{"all:x", "unexpected value of the static selector `all` at character 4"},
+		{"!random", "the static selector `random` cannot be negated at character 2"},
+		{"label:Desk,,group:x", "empty term at character 12"},
+		{",label:Desk", "empty term at character 1"},
+		{"label:Desk,", "empty term at character 12"},
+		{"!", "empty term at character 2"},
+		{"!!label:x", "expected a selector type instead of `!` at character 2"},
+		{`label:"Desk`, "unterminated quoted text at character 7"},
+		{`label:Desk\`, "unterminated escape sequence at character 11"},
+		{`label:"Desk\`, "unterminated escape sequence at character 12"},
+		{"all label:Desk", "expected `,` instead of `l` at character 5"},
+		{"serial:zz", "serial `zz` not valid at character 8"},
+		{"group_id:12", "ID `12` not valid at character 10"},
+		{"label:Desk,location_id: 12", "ID `12` not valid at character 25"},
+	}
+
+	for _, test := range tests {
+		_, err := a.parseSelector(test.selector)
+		if !errors.IsNotValid(err) {
+			t.Errorf("selector `%s`: expected a not valid error, got %v", test.selector, err)
+			continue
+		}
+
+		expected := "selector `" + test.selector + "` not valid: " + test.message
+		if err.Error() != expected {
+			t.Errorf("selector `%s`: expected error %q, got %q", test.selector, expected, err.Error())
+		}
+	}
+
+	if _, err := a.parseSelector("scene_id:1"); !errors.IsNotImplemented(err) {
+		t.Errorf("selector `scene_id:1`: expected a not implemented error, got %v", err)
+	}
+}
@@ -1,163 +1,419 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
-	"strings"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/fberrez/horus/lifx"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
 )
 
-// updateLifx updates the list of Lifx devices.
-func (a *API) updateLifx() error {
-	for _, device := range a.config.Lifx {
-		err := device.Update()
-		if err != nil {
-			return err
+// updateLifx updates the given Lifx devices.
+// The unavailable devices are skipped and the devices which fail to be updated
+// are flagged as disconnected, keeping their last known state.
+// It only returns an error if the context is done.
+func (a *API) updateLifx(ctx context.Context, devices []*lifx.Lifx) error {
+	a.forEach(ctx, devices, func(ctx context.Context, i int, device *lifx.Lifx) {
+		if !device.Available() {
+			return
 		}
+
+		if err := a.updateDevice(ctx, device); err != nil && ctx.Err() == nil {
+			log.WithField("uuid", device.UUID).Warnf("Cannot update device: %v", err)
+		}
+	})
+
+	return ctx.Err()
+}
+
+// perform performs an operation on the corresponding lights in the selector,
+// within the request timeout. The unreachable devices are skipped.
+// It returns the result of the operation on every device.
+func (a *API) perform(c *gin.Context, action, selectorStr string, operation func(context.Context, *lifx.Lifx) *ResultOut) ([]*ResultOut, error) {
+	devices, err := a.selectDevices(action, selectorStr)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return a.performOn(c, devices, operation), nil
 }
 
-// saveConfig saves the actual config status in the config file.
-func (a *API) saveConfig() error {
-	filename := os.Getenv(configFile)
+// selectDevices returns the known devices corresponding to the selector.
+func (a *API) selectDevices(action, selectorStr string) ([]*lifx.Lifx, error) {
+	logger := log.WithField("action", action)
 
-	if filename == "" {
-		filename = defaultConfigFilePath
+	if a.registry.Len() == 0 {
+		return nil, errors.NewNotProvisioned(nil, "list of Lifx devices")
 	}
-	log.WithField("filename", filename).Info("Writing in config file")
 
-	configYaml, err := yaml.Marshal(a.config)
+	// Parses the selector
+	selector, err := a.parseSelector(selectorStr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := ioutil.WriteFile(filename, configYaml, 0755); err != nil {
-		return err
+	logger.WithField("selector", selector).Debug("selector found")
+	// Sorts the array of known devices to return every corresponding devices
+	// to the selector.
+	return a.sortBySelector(selector)
+}
+
+// performOn performs an operation on the given devices, within the request timeout.
+// The unreachable devices are skipped.
+// It returns the result of the operation on every device.
+func (a *API) performOn(c *gin.Context, devices []*lifx.Lifx, operation func(context.Context, *lifx.Lifx) *ResultOut) []*ResultOut {
+	ctx, cancel := a.context(c)
+	defer cancel()
+
+	// The unreachable devices are skipped.
+	devices, skipped := reachable(devices)
+
+	// results contains the result of each performed operation.
+	// The operations are performed on every device at the same time.
+	results := a.fanOut(ctx, devices, func(ctx context.Context, i int, device *lifx.Lifx) *ResultOut {
+		return operation(ctx, device)
+	})
+
+	for _, device := range skipped {
+		results = append(results, newSkippedResultOut(device))
 	}
-	return nil
+
+	return results
+}
+
+// forEach calls the function for every device concurrently,
+// with at most `workers` calls at the same time.
+// It returns when every call has returned.
+// The calls which have not started yet when the context is done are not performed.
+func (a *API) forEach(ctx context.Context, devices []*lifx.Lifx, fn func(context.Context, int, *lifx.Lifx)) {
+	workers := make(chan struct{}, a.config.Workers)
+	var wg sync.WaitGroup
+	for i, device := range devices {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(i int, device *lifx.Lifx) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			fn(ctx, i, device)
+		}(i, device)
+	}
+
+	wg.Wait()
 }
 
-// parseSelector parses a selector
-func (a *API) parseSelector(selectorStr string) (*selector, error) {
-	// Defines error message
-	errNotValid := errors.NotValidf("selector `%s`", selectorStr)
-	// If the selector does not exist, so it returns the default selector (all).
-	if len(selectorStr) == 0 {
-		return all, nil
+// fanOut performs an operation on every device concurrently, within the request timeout.
+// The operation is given the index of the device, so it can store other outputs by device.
+// It returns the results in the order of the devices.
+// The devices which have not been contacted before the timeout get an error result.
+func (a *API) fanOut(ctx context.Context, devices []*lifx.Lifx, operation func(context.Context, int, *lifx.Lifx) *ResultOut) []*ResultOut {
+	ctx, cancel := context.WithTimeout(ctx, a.config.RequestTimeout)
+	defer cancel()
+
+	results := make([]*ResultOut, len(devices))
+	a.forEach(ctx, devices, func(ctx context.Context, i int, device *lifx.Lifx) {
+		results[i] = operation(ctx, i, device)
+	})
+
+	for i, device := range devices {
+		if results[i] == nil {
+			results[i] = newResultOut(device, nil, errors.Annotate(ctx.Err(), "operation not performed"))
+		}
 	}
 
-	selector := &selector{}
-	// If the selector does not contain a `:`,
-	// we suppose that the selector is static.
-	// Therefore, it compares its name with the existing selectors.
-	if !strings.Contains(selectorStr, ":") {
-		for _, s := range a.selectors {
-			if selectorStr == s.name {
-				// If the found selector is dynamic, the given selector is not valid
-				// because it does not use the format `type:value`
-				if s.isDynamic {
-					annotation := "since it is a dynamic selector, it must have the format `type:value`"
-					return nil, errors.Annotate(errNotValid, annotation)
-				}
+	return results
+}
 
-				return s, nil
-			}
+// reachable splits the given devices between the available ones and the skipped ones,
+// which stopped answering recently.
+func reachable(devices []*lifx.Lifx) ([]*lifx.Lifx, []*lifx.Lifx) {
+	available := []*lifx.Lifx{}
+	skipped := []*lifx.Lifx{}
+	for _, device := range devices {
+		if device.Available() {
+			available = append(available, device)
+		} else {
+			skipped = append(skipped, device)
 		}
+	}
+
+	return available, skipped
+}
 
-		return nil, errors.NotFoundf("selector `%s`", selectorStr)
+// updateDevice updates a Lifx device.
+// If the device does not answer, it tries to relocate it before failing.
+func (a *API) updateDevice(ctx context.Context, device *lifx.Lifx) error {
+	err := device.UpdateContext(ctx)
+	if err == nil {
+		return nil
 	}
 
-	// Else we suppose it is a dynamic selector.
-	parts := strings.Split(selectorStr, ":")
-	// If it does not contain a `:` or there are more than one `:`,
-	// the selector is considered invalid.
-	if len(parts) != 2 {
-		annotation := "since it is a dynamic selector, it must have the format `type:value`"
-		return nil, errors.Annotate(errNotValid, annotation)
+	relocated, errRelocate := a.relocateLifx(ctx, device)
+	if errRelocate != nil || !relocated {
+		return err
 	}
 
-	// It compares the first part of the selector with the existing selectors.
-	for _, s := range a.selectors {
-		if parts[0] == s.name {
-			selector = s
-			selector.value = parts[1]
-			return selector, nil
+	return device.UpdateContext(ctx)
+}
+
+// poll refreshes the state of every device, every poll interval plus a random jitter,
+// until the API is closed.
+// The refreshed devices are served from the cache by the routes.
+func (a *API) poll() {
+	logger := log.WithField("action", "poll")
+	for {
+		if err := a.updateLifx(a.ctx, a.registry.Devices()); err != nil {
+			logger.Debug("Stop polling devices")
+			return
 		}
+
+		wait := a.config.PollInterval + time.Duration(rand.Int63n(int64(a.config.PollJitter)))
+		select {
+		case <-a.ctx.Done():
+			logger.Debug("Stop polling devices")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// relocateLifx looks for a device which stopped answering on the domain, using its serial.
+// If its address has changed, the device is updated and the config file is saved.
+// It returns true if the device has been relocated.
+func (a *API) relocateLifx(ctx context.Context, device *lifx.Lifx) (bool, error) {
+	snapshot := device.Snapshot()
+	if snapshot.Serial.IsZero() || len(a.config.Domain) == 0 {
+		return false, nil
+	}
+
+	logger := log.WithFields(log.Fields{
+		"uuid":   snapshot.UUID,
+		"serial": snapshot.Serial.String(),
+	})
+
+	previous := fmt.Sprintf("%s:%s", snapshot.Address, snapshot.Port)
+	relocated, err := device.RelocateContext(ctx, a.config.Domain, lifx.DefaultDiscoveryWindow)
+	if err != nil {
+		logger.Warnf("Cannot relocate device: %v", err)
+		return false, err
+	}
+
+	if !relocated {
+		return false, nil
+	}
+
+	snapshot = device.Snapshot()
+	logger.WithFields(log.Fields{
+		"previous": previous,
+		"current":  fmt.Sprintf("%s:%s", snapshot.Address, snapshot.Port),
+	}).Warn("Device relocated")
+
+	if err := a.saveConfig(); err != nil {
+		logger.Errorf("Cannot save relocated device: %v", err)
 	}
 
-	// If it does not found anything, the selector is not found
-	return nil, errors.NotFoundf("selector `%s`", selectorStr)
+	return true, nil
 }
 
-// sortBySelector returns an array of devices corresponding to selector.
-// If that array is empty or the selector has not been implemented,
-// it returns an error.
-func (a *API) sortBySelector(selector *selector) ([]*lifx.Lifx, error) {
+// discoverLifx discovers the devices of the domain and adds the new ones
+// to the registry. A device is considered as new if its address
+// and port, or its serial, are not already known.
+// It returns the new devices.
+func (a *API) discoverLifx(ctx context.Context) ([]*lifx.Lifx, error) {
+	discovered, err := lifx.DiscoverContext(ctx, a.config.Domain, lifx.DefaultDiscoveryWindow)
+	if err != nil {
+		return nil, err
+	}
+
 	devices := []*lifx.Lifx{}
+	updated := false
+	for _, device := range discovered {
+		// A discovered device does not have any UUID, so a new one is generated.
+		// It is only kept if the device is new.
+		device.UUID = uuid.New().String()
+		added, changed := a.registry.Merge(device)
+		updated = updated || changed
+		if !added {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"uuid":    device.UUID,
+			"address": device.Address.String(),
+			"port":    device.Port,
+		}).Info("New device discovered")
+
+		devices = append(devices, device.Snapshot())
+	}
+
+	// Saves the config so the new devices are kept.
+	if len(devices) > 0 || updated {
+		if err := a.saveConfig(); err != nil {
+			log.Errorf("Cannot save discovered devices: %v", err)
+		}
+	}
+
+	return devices, nil
+}
+
+// animate plays an animation on the device in background, until it is stopped or the API is closed.
+// The animation previously played on the device is stopped.
+func (a *API) animate(device *lifx.Lifx, animation *lifx.Animation) {
+	ctx, cancel := context.WithCancel(a.ctx)
+	playing := &playback{cancel: cancel}
+
+	a.animating.Lock()
+	if previous, ok := a.animations[device]; ok {
+		previous.cancel()
+	}
+	a.animations[device] = playing
+	a.animating.Unlock()
+
+	go func() {
+		err := device.PlayContext(ctx, animation)
+		if err != nil && ctx.Err() == nil {
+			log.WithField("uuid", device.UUID).Warnf("Animation stopped: %v", err)
+		}
+
+		a.animating.Lock()
+		if a.animations[device] == playing {
+			delete(a.animations, device)
+		}
+		a.animating.Unlock()
+		cancel()
+	}()
+}
+
+// stopAnimation stops the animation played on the device.
+// It returns false if no animation is played on the device.
+func (a *API) stopAnimation(device *lifx.Lifx) bool {
+	a.animating.Lock()
+	defer a.animating.Unlock()
+
+	playing, ok := a.animations[device]
+	if !ok {
+		return false
+	}
+
+	playing.cancel()
+	delete(a.animations, device)
+	return true
+}
+
+// context returns a context derived from the context of the request.
+// It is canceled when the request is canceled or when the API is closed.
+func (a *API) context(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	go func() {
+		select {
+		case <-a.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// collect returns the collections of the given snapshots, with their lights, sorted by label.
+// The label of a collection is the label of its most recently updated light, like in the LIFX app.
+func (coll *collection) collect(snapshots []*lifx.Lifx) []*CollectionOut {
+	outs := []*CollectionOut{}
+	byID := map[lifx.ID]*CollectionOut{}
+	for _, snapshot := range snapshots {
+		id, label, updatedAt, ok := coll.read(snapshot)
+		if !ok {
+			continue
+		}
+
+		out, found := byID[id]
+		if !found {
+			out = &CollectionOut{ID: id}
+			byID[id] = out
+			outs = append(outs, out)
+		}
 
-	// For each lifx devices, it will test the correspondence with the selector.
-	// For example, if the selector is a sorting by name, it will test if a device
-	// corresponds to the value of the selector. If it is successfull, it adds the device
-	// to the array contains all corresponding devices.
-	for _, device := range a.config.Lifx {
-		switch selector.name {
-		case all.name:
-			return a.config.Lifx, nil
-		case label.name:
-			// If the value of the selector is identical to the label of the device,
-			// it adds it to the array
-			if selector.value == device.Label {
-				devices = append(devices, device)
-				continue
-			}
-		case id.name:
-			// If the value of the selector is identical to UUID of the device...
-			if selector.value == device.UUID {
-				devices = append(devices, device)
-				continue
-			}
-		case groupID.name:
-			return nil, errors.NotImplementedf("selector %s", sceneID.name)
-		case group.name:
-			// If the value of the selector is identical to the group label of the device...
-			if selector.value == device.Group.Label {
-				devices = append(devices, device)
-				continue
-			}
-		case locationID.name:
-			return nil, errors.NotImplementedf("selector %s", sceneID.name)
-		case location.name:
-			// If the value of the selector is identical to the location label of the device...
-			if selector.value == device.Location.Label {
-				devices = append(devices, device)
-				continue
-			}
-		case sceneID.name:
-			return nil, errors.NotImplementedf("selector %s", sceneID.name)
-		default:
-			return nil, errors.NotFoundf("sorting by selector %s", selector.name)
+		if !found || updatedAt.After(out.UpdatedAt) {
+			out.Label = label
+			out.UpdatedAt = updatedAt
 		}
+
+		out.Lights = append(out.Lights, &LightOut{
+			UUID:   snapshot.UUID,
+			Serial: snapshot.Serial,
+			Label:  snapshot.Label,
+		})
 	}
 
+	sort.Slice(outs, func(i, j int) bool {
+		if outs[i].Label != outs[j].Label {
+			return outs[i].Label < outs[j].Label
+		}
+		return outs[i].ID.String() < outs[j].ID.String()
+	})
+
+	return outs
+}
+
+// find returns the collection with the given ID, and the devices in it.
+// It returns an error if no known device is in the collection.
+func (coll *collection) find(registry *lifx.Registry, id lifx.ID) (*CollectionOut, []*lifx.Lifx, error) {
+	devices := registry.Select(func(snapshot *lifx.Lifx) bool {
+		deviceID, _, _, ok := coll.read(snapshot)
+		return ok && deviceID == id
+	})
+
 	if len(devices) == 0 {
-		return nil, errors.NotFoundf("devices corresponding to selector %s", selector)
+		return nil, nil, errors.NotFoundf("%s %s", coll.name, id)
 	}
 
-	return devices, nil
+	snapshots := make([]*lifx.Lifx, len(devices))
+	for i, device := range devices {
+		snapshots[i] = device.Snapshot()
+	}
+
+	return coll.collect(snapshots)[0], devices, nil
 }
 
-// String returns a string-formatted selector
-func (s *selector) String() string {
-	if s.isDynamic {
-		return fmt.Sprintf("%s:%s", s.name, s.value)
+// saveConfig saves the actual config status in the config file.
+// The devices are saved from snapshots of the registry.
+func (a *API) saveConfig() error {
+	a.saving.Lock()
+	defer a.saving.Unlock()
+
+	filename := os.Getenv(configFile)
+
+	if filename == "" {
+		filename = defaultConfigFilePath
+	}
+	log.WithField("filename", filename).Info("Writing in config file")
+
+	config := *a.config
+	config.Lifx = a.registry.Snapshots()
+	configYaml, err := yaml.Marshal(&config)
+	if err != nil {
+		return err
 	}
 
-	return s.name
+	if err := ioutil.WriteFile(filename, configYaml, 0755); err != nil {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/client"
+	"github.com/fberrez/horus/lifx"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/juju/errors"
+)
+
+// newTestAPI returns an API controlling the given devices.
+func newTestAPI(devices ...*lifx.Lifx) *API {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &API{
+		config: &Config{
+			PollInterval:   10 * time.Millisecond,
+			PollJitter:     time.Millisecond,
+			Workers:        2,
+			RequestTimeout: time.Second,
+		},
+		selectors:  []*selector{all, label, id, serial, groupID, group, locationID, location, sceneID},
+		registry:   lifx.NewRegistry(devices),
+		animations: map[*lifx.Lifx]*playback{},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// silentDevice returns a device which never replies, and the channel of its received packets.
+func silentDevice(t *testing.T) (*lifx.Lifx, *net.UDPConn, chan []byte) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 64)
+	go func() {
+		for {
+			buffer := make([]byte, 2048)
+			n, _, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			select {
+			case received <- buffer[:n]:
+			default:
+			}
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	device := &lifx.Lifx{Address: &addr.IP, Port: strconv.Itoa(addr.Port), Protocol: client.UDP}
+	return device, conn, received
+}
+
+// replyingDevice returns a device answering each received message with the message returned by the function,
+// unless it is nil.
+func replyingDevice(t *testing.T, reply func(msgType lifx.MessageType) *lifx.Message) (*lifx.Lifx, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			buffer := make([]byte, 2048)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			message, err := lifx.DecodeToMessage(buffer[:n])
+			if err != nil {
+				continue
+			}
+
+			answer := reply(message.Header.Type())
+			if answer == nil {
+				continue
+			}
+
+			// Like a device, the reply has the source and the sequence of the received packet.
+			packet := answer.EncodeToBytes()
+			copy(packet[4:8], buffer[4:8])
+			packet[23] = buffer[23]
+			conn.WriteToUDP(packet, from)
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	device := &lifx.Lifx{Address: &addr.IP, Port: strconv.Itoa(addr.Port), Protocol: client.UDP}
+	return device, conn
+}
+
+// quickBackoff makes the messages fail quickly. It returns a function restoring the default backoff.
+func quickBackoff() func() {
+	backoff := lifx.DefaultBackoff
+	lifx.DefaultBackoff = &client.Backoff{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond, Factor: 1, Deadline: 10 * time.Millisecond}
+	return func() {
+		lifx.DefaultBackoff = backoff
+	}
+}
+
+func TestPoll(t *testing.T) {
+	defer quickBackoff()()
+
+	device, conn, received := silentDevice(t)
+	defer conn.Close()
+
+	a := newTestAPI(device)
+	done := make(chan bool)
+	go func() {
+		a.poll()
+		close(done)
+	}()
+
+	// An unreachable device is not refreshed again until its retry delay is over.
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the device to be refreshed")
+	}
+
+	select {
+	case <-received:
+		t.Fatalf("expected the unreachable device to be skipped")
+	case <-time.After(10 * a.config.PollInterval):
+	}
+
+	a.cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the polling to stop with the API")
+	}
+}
+
+func TestGetDevicesFromCache(t *testing.T) {
+	defer quickBackoff()()
+
+	device, conn, received := silentDevice(t)
+	defer conn.Close()
+
+	a := newTestAPI(device)
+	c := &gin.Context{Request: httptest.NewRequest("GET", "/lights", nil)}
+
+	// The devices are served from the cache, without any message.
+	devices, err := a.getDevices(c, &DevicesIn{Selector: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 1 || devices[0].Port != device.Port || len(received) != 0 {
+		t.Fatalf("expected the cached device without any message, got %d devices and %d messages", len(devices), len(received))
+	}
+
+	// A fresh state is read from the devices. The devices which do not answer
+	// are flagged as disconnected, and skipped until their retry delay is over.
+	if _, err := a.getDevices(c, &DevicesIn{Selector: "all", Fresh: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) == 0 || device.Connected || device.Available() {
+		t.Fatalf("expected the device to be refreshed and flagged as unreachable")
+	}
+
+	<-received
+	if _, err := a.getDevices(c, &DevicesIn{Selector: "all", Fresh: true}); err != nil || len(received) != 0 {
+		t.Fatalf("expected the unreachable device to be skipped, got %d messages and %v", len(received), err)
+	}
+}
+
+func TestReachable(t *testing.T) {
+	defer quickBackoff()()
+
+	device, conn, _ := silentDevice(t)
+	defer conn.Close()
+
+	// The device stops answering.
+	device.UUID = uuid.New().String()
+	device.Label = "Desk"
+	if err := device.Update(); err == nil {
+		t.Fatalf("expected the silent device to fail")
+	}
+
+	healthy := &lifx.Lifx{Label: "Kitchen"}
+	available, skipped := reachable([]*lifx.Lifx{device, healthy})
+	if len(available) != 1 || available[0] != healthy || len(skipped) != 1 || skipped[0] != device {
+		t.Fatalf("expected the silent device to be skipped, got %d available and %d skipped devices", len(available), len(skipped))
+	}
+
+	result := newSkippedResultOut(device)
+	if !result.Skipped || result.UUID != device.UUID || result.Label != "Desk" || !strings.Contains(result.Error, device.LastError) {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	devices := []*lifx.Lifx{}
+	for i := 0; i < 10; i++ {
+		devices = append(devices, &lifx.Lifx{})
+	}
+
+	// At most 2 devices are contacted at the same time, and every device is contacted once.
+	a := newTestAPI(devices...)
+	var mu sync.Mutex
+	running, max := 0, 0
+	called := make([]int, len(devices))
+	a.forEach(context.Background(), devices, func(ctx context.Context, i int, device *lifx.Lifx) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		if devices[i] == device {
+			called[i]++
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	if max != a.config.Workers {
+		t.Errorf("expected %d concurrent calls, got %d", a.config.Workers, max)
+	}
+
+	for i, calls := range called {
+		if calls != 1 {
+			t.Errorf("device %d: expected 1 call, got %d", i, calls)
+		}
+	}
+
+	// The calls are not started once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	a.forEach(ctx, devices, func(ctx context.Context, i int, device *lifx.Lifx) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		cancel()
+		<-ctx.Done()
+	})
+
+	if calls > a.config.Workers {
+		t.Errorf("expected at most %d calls, got %d", a.config.Workers, calls)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	devices := []*lifx.Lifx{{Label: "a"}, {Label: "b"}, {Label: "c"}, {Label: "d"}}
+	a := newTestAPI(devices...)
+	a.config.Workers = 1
+	a.config.RequestTimeout = 50 * time.Millisecond
+
+	// The first device blocks until the request timeout, so the next ones are not contacted.
+	results := a.fanOut(context.Background(), devices, func(ctx context.Context, i int, device *lifx.Lifx) *ResultOut {
+		if device.Label == "a" {
+			<-ctx.Done()
+			return newResultOut(device, nil, ctx.Err())
+		}
+
+		return newResultOut(device, nil, nil)
+	})
+
+	if len(results) != len(devices) {
+		t.Fatalf("expected %d results, got %d", len(devices), len(results))
+	}
+
+	for i, result := range results {
+		if result.Label != devices[i].Label {
+			t.Errorf("result %d: expected device %s, got %s", i, devices[i].Label, result.Label)
+		}
+
+		if len(result.Error) == 0 {
+			t.Errorf("result %d: expected an error", i)
+		}
+	}
+
+	// Every result is kept within the timeout.
+	a.config.Workers = 2
+	a.config.RequestTimeout = time.Second
+	results = a.fanOut(context.Background(), devices, func(ctx context.Context, i int, device *lifx.Lifx) *ResultOut {
+		return newResultOut(device, &lifx.Delivery{Attempts: 1}, nil)
+	})
+
+	for i, result := range results {
+		if result.Label != devices[i].Label || len(result.Error) != 0 || result.Attempts != 1 {
+			t.Errorf("result %d: unexpected result %+v", i, result)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	older, newer := time.Unix(1000, 0), time.Unix(2000, 0)
+	kitchen, bedroom := lifx.ID{1}, lifx.ID{2}
+	snapshots := []*lifx.Lifx{
+		{Label: "Ceiling", Group: &lifx.Group{ID: kitchen, Label: "Old kitchen", UpdatedAt: older}},
+		{Label: "Bedside", Group: &lifx.Group{ID: bedroom, Label: "Bedroom", UpdatedAt: older}},
+		{Label: "Unknown"},
+		{Label: "Counter", Group: &lifx.Group{ID: kitchen, Label: "Kitchen", UpdatedAt: newer}},
+	}
+
+	// The collections are sorted by label, and take the label of their most recently updated light.
+	outs := groups.collect(snapshots)
+	if len(outs) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(outs))
+	}
+
+	if outs[0].Label != "Bedroom" || len(outs[0].Lights) != 1 || outs[0].Lights[0].Label != "Bedside" {
+		t.Errorf("unexpected group %+v", outs[0])
+	}
+
+	if outs[1].ID != kitchen || outs[1].Label != "Kitchen" || !outs[1].UpdatedAt.Equal(newer) || len(outs[1].Lights) != 2 {
+		t.Errorf("unexpected group %+v", outs[1])
+	}
+
+	if outs := locations.collect(snapshots); len(outs) != 0 {
+		t.Errorf("expected no location, got %d", len(outs))
+	}
+}
+
+func TestFindCollection(t *testing.T) {
+	home := lifx.ID{3}
+	a := newTestAPI(
+		&lifx.Lifx{Label: "Desk", Location: &lifx.Location{ID: home, Label: "Home"}},
+		&lifx.Lifx{Label: "Porch"},
+	)
+
+	out, devices, err := locations.find(a.registry, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Label != "Home" || len(devices) != 1 || devices[0].Label != "Desk" {
+		t.Errorf("unexpected location %+v with %d devices", out, len(devices))
+	}
+
+	if _, _, err := groups.find(a.registry, home); !errors.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestSortByCollectionID(t *testing.T) {
+	kitchen, home := lifx.ID{1}, lifx.ID{2}
+	a := newTestAPI(
+		&lifx.Lifx{Label: "Fridge", Group: &lifx.Group{ID: kitchen}, Location: &lifx.Location{ID: home}},
+		&lifx.Lifx{Label: "Porch", Location: &lifx.Location{ID: home}},
+		&lifx.Lifx{Label: "Unknown"},
+	)
+
+	tests := []struct {
+		selector string
+		count    int
+	}{
+		{"group_id:" + kitchen.String(), 1},
+		{"group_id:" + strings.ToUpper(kitchen.String()), 1},
+		{"location_id:" + home.String(), 2},
+		{"location_id:" + kitchen.String(), 0},
+	}
+
+	for _, test := range tests {
+		devices, err := a.selectDevices("test", test.selector)
+		if test.count == 0 {
+			if !errors.IsNotFound(err) {
+				t.Errorf("%s: expected a not found error, got %v", test.selector, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.selector, err)
+			continue
+		}
+
+		if len(devices) != test.count {
+			t.Errorf("%s: expected %d devices, got %d", test.selector, test.count, len(devices))
+		}
+	}
+
+	if _, err := a.selectDevices("test", "group_id:kitchen"); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+}
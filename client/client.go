@@ -1,14 +1,77 @@
 package client
 
 import (
+	"context"
 	"net"
 	"time"
 )
 
 type (
+	// Client sends packets to devices and returns their replies.
+	// The Context variants stop waiting for the replies when the context is done.
 	Client interface {
 		Send(dest *net.IP, port string, packet []byte) ([]byte, error)
+		SendContext(ctx context.Context, dest *net.IP, port string, packet []byte) ([]byte, error)
 		SendWithDeadLine(dest *net.IP, port string, packet []byte, deadline time.Duration) ([]byte, error)
+		Do(request *Request) (*Response, error)
+		DoContext(ctx context.Context, request *Request) (*Response, error)
+	}
+
+	// Request is a packet sent to a device, waiting for one or several replies.
+	Request struct {
+		// Dest is the address of the device.
+		Dest *net.IP
+
+		// Port is the port of the device.
+		Port string
+
+		// Packet is the packet to send.
+		Packet []byte
+
+		// Expect contains the message types accepted as replies.
+		// If it is empty, every reply except acknowledgements is accepted.
+		Expect []uint16
+
+		// Replies is the number of replies to wait for. Its default value is 1.
+		Replies int
+
+		// Deadline is the maximum duration to wait for the replies.
+		// It is ignored if the request is retried.
+		Deadline time.Duration
+
+		// Retry defines how the packet is sent again when no reply is received.
+		// If it is nil, the packet is sent only once.
+		Retry *Backoff
+	}
+
+	// Response contains the replies to a request.
+	Response struct {
+		// Packets contains the received replies, in their order of arrival.
+		Packets [][]byte
+
+		// Attempts is the number of times the packet has been sent.
+		Attempts int
+
+		// RTT is the round trip time between the sending and the first reply.
+		// It is zero if the packet has been sent again before the first reply,
+		// since the answered attempt is unknown.
+		RTT time.Duration
+	}
+
+	// Backoff defines how a packet is retried.
+	// After each attempt, the time to wait for a reply is multiplied by the factor.
+	Backoff struct {
+		// Initial is the time to wait for a reply to the first attempt.
+		Initial time.Duration `yaml:"initial" json:"initial"`
+
+		// Max is the maximum time to wait for a reply to an attempt.
+		Max time.Duration `yaml:"max" json:"max"`
+
+		// Factor multiplies the time to wait after each attempt.
+		Factor float64 `yaml:"factor" json:"factor"`
+
+		// Deadline is the maximum duration of the request, including every attempt.
+		Deadline time.Duration `yaml:"deadline" json:"deadline"`
 	}
 
 	Protocol string
@@ -17,3 +80,18 @@ type (
 const (
 	UDP Protocol = "udp"
 )
+
+// Next returns the time to wait for a reply after an attempt which waited for the given time.
+// The time to wait never decreases.
+func (b *Backoff) Next(wait time.Duration) time.Duration {
+	next := time.Duration(float64(wait) * b.Factor)
+	if next < wait {
+		return wait
+	}
+
+	if next > b.Max {
+		return b.Max
+	}
+
+	return next
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	backoff := &Backoff{Initial: 200 * time.Millisecond, Max: time.Second, Factor: 2, Deadline: 3 * time.Second}
+	tests := []struct {
+		wait time.Duration
+		next time.Duration
+	}{
+		{200 * time.Millisecond, 400 * time.Millisecond},
+		{400 * time.Millisecond, 800 * time.Millisecond},
+		{800 * time.Millisecond, time.Second},
+		{time.Second, time.Second},
+	}
+
+	for _, test := range tests {
+		if next := backoff.Next(test.wait); next != test.next {
+			t.Errorf("wait %v: expected %v, got %v", test.wait, test.next, next)
+		}
+	}
+
+	// The time to wait never decreases.
+	backoff.Factor = 0.5
+	if next := backoff.Next(400 * time.Millisecond); next != 400*time.Millisecond {
+		t.Errorf("expected %v, got %v", 400*time.Millisecond, next)
+	}
+}
@@ -1,60 +1,438 @@
 package udp
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/fberrez/horus/client"
 	"github.com/juju/errors"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	DEFAULT_DEADLINE = time.Second * 2
+
+	// Offsets of the LIFX header fields used to correlate the replies.
+	headerSize     = 36
+	sourceOffset   = 4
+	targetOffset   = 8
+	sequenceOffset = 23
+	typeOffset     = 32
+
+	// acknowledgement is the message type of an Acknowledgement (45) message.
+	acknowledgement uint16 = 45
+)
+
+type (
+	// UDP is a client which owns a single UDP socket.
+	// Each outgoing packet is stamped with a rolling sequence number per device
+	// and the incoming packets are dispatched to the waiting requests
+	// by their source, sequence and message type.
+	UDP struct {
+		Name string `json:"name" yaml:"name"`
+
+		// once starts listening on the socket on the first request.
+		once sync.Once
+
+		// conn is the socket shared by every request.
+		conn *net.UDPConn
+
+		// err is the error returned while listening on the socket.
+		err error
+
+		// mu guards sequences, pending and the received replies of the pending requests.
+		mu sync.Mutex
+
+		// sequences contains the last sequence number sent to each address.
+		sequences map[string]uint8
+
+		// pending contains the requests waiting for replies.
+		pending map[key]*call
+	}
+
+	// Reply is a packet received from a remote device.
+	Reply struct {
+		// Addr is the address of the device which sent the packet.
+		Addr *net.UDPAddr
+
+		// Packet is the received packet.
+		Packet []byte
+	}
+
+	// key identifies the replies to a request.
+	key struct {
+		addr     string
+		source   uint32
+		sequence uint8
+	}
+
+	// call is a request waiting for replies.
+	call struct {
+		target  [8]byte
+		expect  []uint16
+		replies chan []byte
+
+		// received contains the message types and the payloads of the replies already received.
+		// A retried packet keeps its sequence number, so a device may answer several attempts:
+		// the same reply is only delivered once.
+		received map[string]bool
+	}
 )
 
-type UDP struct {
-	Name string `json:"name" yaml:"name"`
+// shared is the UDP client shared by every device.
+var shared = &UDP{Name: "shared"}
+
+// Shared returns the UDP client shared by every device.
+func Shared() *UDP {
+	return shared
 }
 
 func (u *UDP) Send(dest *net.IP, port string, packet []byte) ([]byte, error) {
 	return u.SendWithDeadLine(dest, port, packet, DEFAULT_DEADLINE)
 }
 
-// SendWithDeadLine sends packet to dest and gets back one reply from the bulb
-// Note that the way it's implemented means it's possible it'll get multiple replies
-// (i.e. ack and a response if those are both asked for in the request)
-// and only log the first reply
+// SendContext sends packet to dest and gets back the first reply which is not an acknowledgement.
+// It stops waiting when the context is done.
+func (u *UDP) SendContext(ctx context.Context, dest *net.IP, port string, packet []byte) ([]byte, error) {
+	return u.sendWithDeadLine(ctx, dest, port, packet, DEFAULT_DEADLINE)
+}
+
+// SendWithDeadLine sends packet to dest and gets back the first reply which is not an acknowledgement.
 func (u *UDP) SendWithDeadLine(dest *net.IP, port string, packet []byte, deadline time.Duration) ([]byte, error) {
-	log := logrus.WithField("from", "clientUDP")
-	// Initializes the UDP cient
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%s", dest.String(), port))
+	return u.sendWithDeadLine(context.Background(), dest, port, packet, deadline)
+}
+
+func (u *UDP) sendWithDeadLine(ctx context.Context, dest *net.IP, port string, packet []byte, deadline time.Duration) ([]byte, error) {
+	response, err := u.DoContext(ctx, &client.Request{
+		Dest:     dest,
+		Port:     port,
+		Packet:   packet,
+		Deadline: deadline,
+	})
 	if err != nil {
+		return nil, err
+	}
+
+	return response.Packets[0], nil
+}
+
+// Do sends the packet of the request to its destination and waits for its replies.
+// The packet is stamped with the next sequence number of the destination.
+// If the request defines a backoff, the packet is sent again, with the same sequence number,
+// until every reply is received or the total deadline is exceeded.
+// If the deadline is exceeded, it returns the received replies with a timeout error.
+func (u *UDP) Do(request *client.Request) (*client.Response, error) {
+	return u.DoContext(context.Background(), request)
+}
+
+// DoContext is like Do but it stops waiting for the replies, and does not retry,
+// when the context is done. It returns the error of the context.
+func (u *UDP) DoContext(ctx context.Context, request *client.Request) (*client.Response, error) {
+	log := logrus.WithField("from", "clientUDP")
+	if len(request.Packet) < headerSize {
+		return nil, errors.NotValidf("packet of %d bytes", len(request.Packet))
+	}
+
+	if err := u.listen(); err != nil {
 		return nil, errors.Annotate(err, "cannot send udp packet")
 	}
 
-	conn, err := net.DialUDP("udp", nil, addr)
-	defer conn.Close()
+	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%s", request.Dest.String(), request.Port))
 	if err != nil {
 		return nil, errors.Annotate(err, "cannot send udp packet")
 	}
 
-	log.Debugf("Sending packet to %s", addr.String())
-	// Sends the UDP packet
-	_, err = conn.Write(packet)
+	replies := request.Replies
+	if replies <= 0 {
+		replies = 1
+	}
+
+	deadline := request.Deadline
+	if deadline <= 0 {
+		deadline = DEFAULT_DEADLINE
+	}
+
+	// Stamps the packet with the next sequence number of the destination.
+	packet := append([]byte{}, request.Packet...)
+	k := key{
+		addr:     addr.String(),
+		source:   binary.LittleEndian.Uint32(packet[sourceOffset : sourceOffset+4]),
+		sequence: u.nextSequence(addr.String()),
+	}
+	packet[sequenceOffset] = k.sequence
+
+	c := &call{
+		expect:   request.Expect,
+		replies:  make(chan []byte, replies),
+		received: map[string]bool{},
+	}
+	copy(c.target[:], packet[targetOffset:targetOffset+8])
+
+	u.register(k, c)
+	defer u.unregister(k)
+
+	// Defines the attempts: a single one, or several ones until the total deadline.
+	wait := deadline
+	end := time.Now().Add(deadline)
+	if request.Retry != nil && request.Retry.Initial > 0 {
+		wait = request.Retry.Initial
+		end = time.Now().Add(request.Retry.Deadline)
+	}
+
+	response := &client.Response{Packets: [][]byte{}}
+	for {
+		if err := ctx.Err(); err != nil {
+			return response, errors.Annotatef(err, "sending packet to %s", addr.String())
+		}
+
+		// The last attempt cannot exceed the total deadline.
+		if remaining := time.Until(end); wait > remaining {
+			wait = remaining
+		}
+
+		log.Debugf("Sending packet to %s", addr.String())
+		// Sends the UDP packet
+		if _, err = u.conn.WriteToUDP(packet, addr); err != nil {
+			return nil, errors.Annotate(err, "cannot send udp packet")
+		}
+		response.Attempts++
+		sent := time.Now()
+
+		// Waits for the replies
+		done, err := u.wait(ctx, c, response, replies, sent, wait)
+		if err != nil {
+			return response, errors.Annotatef(err, "waiting for reply from %s", addr.String())
+		}
+
+		if done {
+			return response, nil
+		}
+
+		if request.Retry == nil || !time.Now().Before(end) {
+			return response, errors.Timeoutf("reply from %s after %d attempts", addr.String(), response.Attempts)
+		}
+
+		log.Debugf("Retrying packet to %s", addr.String())
+		wait = request.Retry.Next(wait)
+	}
+}
+
+// wait waits for the replies of a call during the given time.
+// It returns true if every expected reply has been received,
+// or the error of the context if it is done.
+func (u *UDP) wait(ctx context.Context, c *call, response *client.Response, replies int, sent time.Time, wait time.Duration) (bool, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for len(response.Packets) < replies {
+		select {
+		case reply := <-c.replies:
+			// The replies to every attempt have the same sequence number,
+			// so the round trip is only measured if the packet has been sent once.
+			if len(response.Packets) == 0 && response.Attempts == 1 {
+				response.RTT = time.Since(sent)
+			}
+			response.Packets = append(response.Packets, reply)
+		case <-timer.C:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	return true, nil
+}
+
+// Close closes the socket of the client.
+func (u *UDP) Close() error {
+	if u.conn == nil {
+		return nil
+	}
+
+	return u.conn.Close()
+}
+
+// listen opens the socket and starts reading it, once.
+func (u *UDP) listen() error {
+	u.once.Do(func() {
+		u.sequences = map[string]uint8{}
+		u.pending = map[key]*call{}
+		u.conn, u.err = net.ListenUDP("udp4", nil)
+		if u.err == nil {
+			go u.read()
+		}
+	})
+
+	return u.err
+}
+
+// read reads every incoming packet and dispatches it to the waiting request.
+func (u *UDP) read() {
+	log := logrus.WithField("from", "clientUDP")
+	for {
+		p := make([]byte, 2048)
+		n, from, err := u.conn.ReadFromUDP(p)
+		if err != nil {
+			log.Debugf("Stop reading udp socket: %v", err)
+			return
+		}
+
+		if n < headerSize {
+			log.Debugf("Ignoring packet of %d bytes from %s", n, from.String())
+			continue
+		}
+
+		size := int(binary.LittleEndian.Uint16(p[0:2]))
+		if size < headerSize || size > n {
+			size = n
+		}
+
+		u.dispatch(from, p[0:size])
+	}
+}
+
+// dispatch delivers a packet to the request waiting for it.
+// The packets which do not match any request are dropped.
+func (u *UDP) dispatch(from *net.UDPAddr, packet []byte) {
+	k := key{
+		addr:     from.String(),
+		source:   binary.LittleEndian.Uint32(packet[sourceOffset : sourceOffset+4]),
+		sequence: packet[sequenceOffset],
+	}
+	msgType := binary.LittleEndian.Uint16(packet[typeOffset : typeOffset+2])
+
+	u.mu.Lock()
+	c, ok := u.pending[k]
+	accepted := ok && c.accepts(packet, msgType)
+	duplicate := false
+	if accepted {
+		// The replies are compared from their message type,
+		// since the previous fields are identical for every reply of the call.
+		reply := string(packet[typeOffset:])
+		duplicate = c.received[reply]
+		c.received[reply] = true
+	}
+	u.mu.Unlock()
+
+	if !accepted {
+		logrus.WithField("from", "clientUDP").Debugf("Dropping packet of type %d from %s", msgType, from.String())
+		return
+	}
+
+	if duplicate {
+		logrus.WithField("from", "clientUDP").Debugf("Dropping duplicate packet of type %d from %s", msgType, from.String())
+		return
+	}
+
+	select {
+	case c.replies <- packet:
+	default:
+	}
+}
+
+// accepts returns true if the packet is an expected reply of the call.
+func (c *call) accepts(packet []byte, msgType uint16) bool {
+	// A targeted request must be answered by its target.
+	if c.target != [8]byte{} {
+		target := [8]byte{}
+		copy(target[:], packet[targetOffset:targetOffset+8])
+		if target != c.target {
+			return false
+		}
+	}
+
+	if len(c.expect) == 0 {
+		return msgType != acknowledgement
+	}
+
+	for _, expected := range c.expect {
+		if msgType == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextSequence returns the next sequence number of an address.
+func (u *UDP) nextSequence(addr string) uint8 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sequences[addr]++
+	return u.sequences[addr]
+}
+
+func (u *UDP) register(k key, c *call) {
+	u.mu.Lock()
+	u.pending[k] = c
+	u.mu.Unlock()
+}
+
+func (u *UDP) unregister(k key) {
+	u.mu.Lock()
+	delete(u.pending, k)
+	u.mu.Unlock()
+}
+
+// Broadcast sends packet to dest, which is usually a broadcast address,
+// and collects every reply received before the end of the listening window.
+func Broadcast(dest *net.IP, port string, packet []byte, window time.Duration) ([]*Reply, error) {
+	return BroadcastContext(context.Background(), dest, port, packet, window)
+}
+
+// BroadcastContext is like Broadcast but it stops listening when the context is done.
+// It returns the replies received until then.
+func BroadcastContext(ctx context.Context, dest *net.IP, port string, packet []byte, window time.Duration) ([]*Reply, error) {
+	log := logrus.WithField("from", "clientUDP")
+	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%s", dest.String(), port))
 	if err != nil {
-		return nil, errors.Annotate(err, "cannot send udp packet")
+		return nil, errors.Annotate(err, "cannot broadcast udp packet")
 	}
-	// Reads the response
-	conn.SetReadDeadline(time.Now().Add(deadline))
-	p := make([]byte, 2048)
-	_, err = conn.Read(p)
+
+	// Listens on a random port. The replies are sent back to it.
+	conn, err := net.ListenUDP("udp4", nil)
 	if err != nil {
-		return nil, err
+		return nil, errors.Annotate(err, "cannot broadcast udp packet")
+	}
+	defer conn.Close()
+
+	log.Debugf("Broadcasting packet to %s", addr.String())
+	if _, err = conn.WriteToUDP(packet, addr); err != nil {
+		return nil, errors.Annotate(err, "cannot broadcast udp packet")
 	}
 
-	size := binary.LittleEndian.Uint16(p[0:2])
+	// Reads every reply until the deadline is exceeded.
+	// If the context is done before, the deadline is moved to now.
+	replies := []*Reply{}
+	conn.SetReadDeadline(time.Now().Add(window))
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	for {
+		p := make([]byte, 2048)
+		n, from, err := conn.ReadFromUDP(p)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return nil, errors.Annotate(err, "cannot read udp reply")
+		}
+
+		replies = append(replies, &Reply{
+			Addr:   from,
+			Packet: p[0:n],
+		})
+	}
 
-	return p[0:size], nil
+	return replies, nil
 }
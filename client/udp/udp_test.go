@@ -0,0 +1,374 @@
+package udp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/client"
+	"github.com/juju/errors"
+)
+
+// device is a fake device answering each received packet with the given replies,
+// built from the header of the received packet.
+// The received packets are sent to the returned channel.
+// It returns the socket, the address and the port of the device.
+func device(t *testing.T, replies func(packet []byte) [][]byte) (*net.UDPConn, *net.IP, string, chan []byte) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 16)
+	go func() {
+		for {
+			buffer := make([]byte, 2048)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			received <- buffer[:n]
+			for _, reply := range replies(buffer[:n]) {
+				conn.WriteToUDP(reply, from)
+			}
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return conn, &addr.IP, strconv.Itoa(addr.Port), received
+}
+
+// reply returns a reply of the given message type and payload to the packet.
+func reply(packet []byte, msgType uint16, payload string) []byte {
+	reply := append(append([]byte{}, packet[:headerSize]...), payload...)
+	binary.LittleEndian.PutUint16(reply[0:2], uint16(len(reply)))
+	binary.LittleEndian.PutUint16(reply[typeOffset:typeOffset+2], msgType)
+	return reply
+}
+
+// replyAll answers each packet with the replies of the given payloads, of message type 3.
+func replyAll(payloads ...string) func(packet []byte) [][]byte {
+	return func(packet []byte) [][]byte {
+		replies := [][]byte{}
+		for _, payload := range payloads {
+			replies = append(replies, reply(packet, 3, payload))
+		}
+
+		return replies
+	}
+}
+
+// request returns a request of the given number of replies.
+func request(dest *net.IP, port string, replies int) *client.Request {
+	packet := make([]byte, headerSize)
+	binary.LittleEndian.PutUint16(packet[0:2], headerSize)
+	binary.LittleEndian.PutUint32(packet[sourceOffset:sourceOffset+4], 42)
+	binary.LittleEndian.PutUint16(packet[typeOffset:typeOffset+2], 2)
+
+	return &client.Request{
+		Dest:     dest,
+		Port:     port,
+		Packet:   packet,
+		Expect:   []uint16{3},
+		Replies:  replies,
+		Deadline: time.Second,
+	}
+}
+
+// payloads returns the payloads of the packets of a response.
+func payloads(response *client.Response) []string {
+	payloads := []string{}
+	for _, packet := range response.Packets {
+		payloads = append(payloads, string(packet[headerSize:]))
+	}
+
+	return payloads
+}
+
+func TestSequences(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	conn, dest, port, received := device(t, replyAll("a"))
+	defer conn.Close()
+
+	for expected := 1; expected <= 3; expected++ {
+		if _, err := u.Do(request(dest, port, 1)); err != nil {
+			t.Fatal(err)
+		}
+
+		if packet := <-received; packet[sequenceOffset] != uint8(expected) {
+			t.Errorf("expected sequence %d, got %d", expected, packet[sequenceOffset])
+		}
+	}
+
+	// The request is not modified.
+	r := request(dest, port, 1)
+	r.Packet[sequenceOffset] = 0x10
+	if _, err := u.Do(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Packet[sequenceOffset] != 0x10 || (<-received)[sequenceOffset] != 4 {
+		t.Errorf("expected the sent packet only to be stamped")
+	}
+}
+
+func TestDroppedReplies(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	// Only the last reply answers the request: the others have another sequence,
+	// another source, another target, or are acknowledgements or unexpected types.
+	conn, dest, port, _ := device(t, func(packet []byte) [][]byte {
+		sequence := reply(packet, 3, "sequence")
+		sequence[sequenceOffset]++
+		source := reply(packet, 3, "source")
+		source[sourceOffset]++
+		target := reply(packet, 3, "target")
+		target[targetOffset]++
+
+		return [][]byte{sequence, source, target, reply(packet, acknowledgement, ""), reply(packet, 4, "type"), reply(packet, 3, "ok")}
+	})
+	defer conn.Close()
+
+	r := request(dest, port, 1)
+	copy(r.Packet[targetOffset:targetOffset+8], []byte{0xd0, 0x73, 0xd5, 1, 2, 3})
+	response, err := u.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := payloads(response); len(got) != 1 || got[0] != "ok" {
+		t.Fatalf("expected the replies [ok], got %q", got)
+	}
+}
+
+func TestDuplicateReplies(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	// The first reply is sent twice, so it must not be counted as the second one.
+	conn, dest, port, _ := device(t, replyAll("a", "a", "b"))
+	defer conn.Close()
+
+	response, err := u.Do(request(dest, port, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := payloads(response); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected the replies [a b], got %q", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	conn, dest, port, _ := device(t, replyAll("a"))
+	defer conn.Close()
+
+	r := request(dest, port, 2)
+	r.Deadline = 100 * time.Millisecond
+	response, err := u.Do(r)
+	if !errors.IsTimeout(err) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+
+	// The received replies are returned with the error.
+	if got := payloads(response); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("expected the replies [a], got %q", got)
+	}
+}
+
+func TestShortPacket(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	if _, err := u.Do(&client.Request{Dest: &ip, Port: "56700", Packet: []byte{1, 2, 3}}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+}
+
+// retry is a backoff retrying quickly.
+var retry = &client.Backoff{Initial: 50 * time.Millisecond, Max: 100 * time.Millisecond, Factor: 2, Deadline: time.Second}
+
+func TestRetriedDuplicateReplies(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	// The first attempt only gets the first reply, and the retry gets every reply.
+	attempts := 0
+	conn, dest, port, received := device(t, func(packet []byte) [][]byte {
+		attempts++
+		if attempts == 1 {
+			return replyAll("a")(packet)
+		}
+
+		return replyAll("a", "b")(packet)
+	})
+	defer conn.Close()
+
+	r := request(dest, port, 2)
+	r.Retry = retry
+	response, err := u.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := payloads(response); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected the replies [a b], got %q", got)
+	}
+
+	if response.Attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", response.Attempts)
+	}
+
+	// The packet is sent again with the same sequence number.
+	if first, second := <-received, <-received; first[sequenceOffset] != second[sequenceOffset] {
+		t.Errorf("expected the same sequence, got %d and %d", first[sequenceOffset], second[sequenceOffset])
+	}
+}
+
+func TestRTT(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	conn, dest, port, _ := device(t, replyAll("a"))
+	defer conn.Close()
+
+	r := request(dest, port, 1)
+	r.Retry = retry
+	response, err := u.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Attempts != 1 || response.RTT <= 0 {
+		t.Fatalf("expected the round trip time of the first attempt, got %v after %d attempts", response.RTT, response.Attempts)
+	}
+}
+
+func TestRetriedRTT(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	// The first attempt is lost, so the reply may answer any attempt.
+	attempts := 0
+	conn, dest, port, _ := device(t, func(packet []byte) [][]byte {
+		attempts++
+		if attempts == 1 {
+			return nil
+		}
+
+		return replyAll("a")(packet)
+	})
+	defer conn.Close()
+
+	r := request(dest, port, 1)
+	r.Retry = retry
+	response, err := u.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", response.Attempts)
+	}
+
+	if response.RTT != 0 {
+		t.Fatalf("expected no round trip time, got %v", response.RTT)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	conn, dest, port, _ := device(t, replyAll())
+	defer conn.Close()
+
+	// The attempts wait 50ms, 100ms, 100ms, then the rest of the deadline.
+	r := request(dest, port, 1)
+	r.Retry = &client.Backoff{Initial: 50 * time.Millisecond, Max: 100 * time.Millisecond, Factor: 2, Deadline: 300 * time.Millisecond}
+	start := time.Now()
+	response, err := u.Do(r)
+	if !errors.IsTimeout(err) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected the request to last the deadline, got %v", elapsed)
+	}
+
+	if response.Attempts != 4 {
+		t.Errorf("expected 4 attempts, got %d", response.Attempts)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+	u := &UDP{}
+	defer u.Close()
+
+	received := 0
+	conn, dest, port, _ := device(t, func(packet []byte) [][]byte {
+		received++
+		return nil
+	})
+	defer conn.Close()
+
+	// The request stops waiting, and is not retried, once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+
+	r := request(dest, port, 1)
+	r.Retry = retry
+	start := time.Now()
+	response, err := u.DoContext(ctx, r)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("expected the error of the context, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the request to stop with the context, got %v", elapsed)
+	}
+
+	if response.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", response.Attempts)
+	}
+
+	// A done context does not send the packet.
+	response, err = u.DoContext(ctx, request(dest, port, 1))
+	if errors.Cause(err) != context.DeadlineExceeded || response.Attempts != 0 {
+		t.Errorf("expected no attempt and the error of the context, got %d attempts and %v", response.Attempts, err)
+	}
+}
+
+func TestBroadcastContext(t *testing.T) {
+	conn, dest, port, _ := device(t, replyAll("a"))
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The replies received before the end of the context are returned.
+	start := time.Now()
+	replies, err := BroadcastContext(ctx, dest, port, request(dest, port, 1).Packet, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the broadcast to stop with the context, got %v", elapsed)
+	}
+
+	if len(replies) != 1 || string(replies[0].Packet[headerSize:]) != "a" {
+		t.Errorf("expected the reply a, got %d replies", len(replies))
+	}
+}
@@ -67,12 +67,14 @@ func main() {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM)
 	signal.Notify(quit, syscall.SIGINT)
 
 	// Wait for a SIGTERM or SIGINT
 	<-quit
+	// Aborts the operations in progress on the devices, so the server does not wait for them.
+	api.Close()
 	if err := srv.Shutdown(context.Background()); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,355 @@
+package lifx
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/fberrez/horus/tools"
+)
+
+type (
+	// Payload is the payload of a message.
+	// Each message type has its own payload, registered in the payloads registry.
+	Payload interface {
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+	}
+
+	// InvalidHeaderError is returned when the header of a packet cannot be decoded.
+	InvalidHeaderError struct {
+		// Reason explains why the header is not valid.
+		Reason string
+	}
+
+	// ShortPayloadError is returned when a payload is shorter than expected.
+	ShortPayloadError struct {
+		// Type is the message type of the payload.
+		Type MessageType
+
+		// Expected is the expected size of the payload in bytes.
+		Expected int
+
+		// Size is the actual size of the payload in bytes.
+		Size int
+	}
+
+	// UnexpectedTypeError is returned when a message does not have the expected type.
+	UnexpectedTypeError struct {
+		// Expected is the expected message type.
+		Expected MessageType
+
+		// Got is the message type of the decoded message.
+		Got MessageType
+	}
+
+	// UnknownTypeError is returned when a message type is not registered.
+	UnknownTypeError struct {
+		// Type is the unknown message type.
+		Type MessageType
+	}
+
+	// encoder writes little endian values in an array of bytes.
+	encoder struct {
+		buffer []byte
+	}
+
+	// decoder reads little endian values from an array of bytes.
+	// The size of the array must be verified before reading it.
+	decoder struct {
+		buffer []byte
+		offset int
+	}
+)
+
+const (
+	// labelSize is the size of a label in bytes.
+	labelSize = 32
+
+	// hsbkSize is the size of a HSBK in bytes.
+	hsbkSize = 8
+
+	// zonesPerMultiZone is the number of zones of a StateMultiZone (506) message.
+	zonesPerMultiZone = 8
+
+	// zonesPerExtended is the number of zones of the extended multizone messages.
+	zonesPerExtended = 82
+
+	// tilesPerChain is the number of tiles of a StateDeviceChain (702) message.
+	tilesPerChain = 16
+
+	// tileDeviceSize is the size of a tile of a StateDeviceChain (702) message in bytes.
+	tileDeviceSize = 55
+
+	// zonesPerTileMessage is the number of zones of the State64 (711) and Set64 (715) messages.
+	zonesPerTileMessage = 64
+
+	// effectParameters is the number of parameters of the firmware effects messages.
+	effectParameters = 8
+
+	// paletteSize is the number of colors of the palette of the tile effects messages.
+	paletteSize = 16
+
+	// tileEffectSize is the size of the settings of the tile effects messages in bytes,
+	// without their leading reserved bytes.
+	tileEffectSize = 26 + effectParameters*4 + paletteSize*hsbkSize
+)
+
+// payloads is the registry of payloads. It returns a new payload for each message type.
+var payloads = map[MessageType]func() Payload{
+	GetService:        newEmptyPayload,
+	StateService:      func() Payload { return &StateServicePayload{} },
+	GetHostInfo:       newEmptyPayload,
+	StateHostInfo:     func() Payload { return &SignalPayload{} },
+	GetHostFirmware:   newEmptyPayload,
+	StateHostFirmware: func() Payload { return &FirmwarePayload{} },
+	GetWifiInfo:       newEmptyPayload,
+	StateWifiInfo:     func() Payload { return &SignalPayload{} },
+	GetWifiFirmware:   newEmptyPayload,
+	StateWifiFirmware: func() Payload { return &FirmwarePayload{} },
+	GetPowerDevice:    newEmptyPayload,
+	SetPowerDevice:    func() Payload { return &PowerPayload{} },
+	StatePower:        func() Payload { return &PowerPayload{} },
+	GetLabel:          newEmptyPayload,
+	SetLabel:          func() Payload { return &LabelPayload{} },
+	StateLabel:        func() Payload { return &LabelPayload{} },
+	GetVersion:        newEmptyPayload,
+	StateVersion:      func() Payload { return &StateVersionPayload{} },
+	GetInfo:           newEmptyPayload,
+	StateInfo:         func() Payload { return &StateInfoPayload{} },
+	Acknowledgement:   newEmptyPayload,
+	GetLocation:       newEmptyPayload,
+	SetLocation:       func() Payload { return &LocationPayload{} },
+	StateLocation:     func() Payload { return &LocationPayload{} },
+	GetGroup:          newEmptyPayload,
+	SetGroup:          func() Payload { return &GroupPayload{} },
+	StateGroup:        func() Payload { return &GroupPayload{} },
+	EchoRequest:       func() Payload { return &EchoPayload{} },
+	EchoResponse:      func() Payload { return &EchoPayload{} },
+	Get:               newEmptyPayload,
+	SetColor:          func() Payload { return &SetColorPayload{} },
+	SetWaveform:       func() Payload { return &SetWaveformPayload{} },
+	StateLight:        func() Payload { return &StateLightPayload{} },
+	GetPowerLight:     newEmptyPayload,
+	SetPowerLight:     func() Payload { return &SetPowerLightPayload{} },
+	StatePowerLight:   func() Payload { return &PowerPayload{} },
+
+	// Waveform effects applied on a subset of the color channels
+	SetWaveformOptional: func() Payload { return &SetWaveformOptionalPayload{} },
+
+	// Multizone messages
+	SetColorZones:           func() Payload { return &SetColorZonesPayload{} },
+	GetColorZones:           func() Payload { return &GetColorZonesPayload{} },
+	StateZone:               func() Payload { return &StateZonePayload{} },
+	StateMultiZone:          func() Payload { return &StateMultiZonePayload{} },
+	SetExtendedColorZones:   func() Payload { return &SetExtendedColorZonesPayload{} },
+	GetExtendedColorZones:   newEmptyPayload,
+	StateExtendedColorZones: func() Payload { return &StateExtendedColorZonesPayload{} },
+
+	// Matrix messages
+	GetDeviceChain:   newEmptyPayload,
+	StateDeviceChain: func() Payload { return &StateDeviceChainPayload{} },
+	Get64:            func() Payload { return &Get64Payload{} },
+	State64:          func() Payload { return &State64Payload{} },
+	Set64:            func() Payload { return &Set64Payload{} },
+
+	// Firmware effects messages
+	GetMultiZoneEffect:   newEmptyPayload,
+	SetMultiZoneEffect:   func() Payload { return &MultiZoneEffectPayload{} },
+	StateMultiZoneEffect: func() Payload { return &MultiZoneEffectPayload{} },
+	GetTileEffect:        func() Payload { return &GetTileEffectPayload{} },
+	SetTileEffect:        func() Payload { return &SetTileEffectPayload{} },
+	StateTileEffect:      func() Payload { return &StateTileEffectPayload{} },
+
+	// Infrared messages
+	GetInfrared:   newEmptyPayload,
+	StateInfrared: func() Payload { return &InfraredPayload{} },
+	SetInfrared:   func() Payload { return &InfraredPayload{} },
+
+	// HEV messages
+	GetHevCycle:                newEmptyPayload,
+	SetHevCycle:                func() Payload { return &SetHevCyclePayload{} },
+	StateHevCycle:              func() Payload { return &StateHevCyclePayload{} },
+	GetHevCycleConfiguration:   newEmptyPayload,
+	SetHevCycleConfiguration:   func() Payload { return &HevCycleConfigurationPayload{} },
+	StateHevCycleConfiguration: func() Payload { return &HevCycleConfigurationPayload{} },
+	GetLastHevCycleResult:      newEmptyPayload,
+	StateLastHevCycleResult:    func() Payload { return &StateLastHevCycleResultPayload{} },
+}
+
+// NewPayload returns a new empty payload corresponding to the message type.
+func NewPayload(msgType MessageType) (Payload, error) {
+	newPayload, ok := payloads[msgType]
+	if !ok {
+		return nil, &UnknownTypeError{Type: msgType}
+	}
+
+	return newPayload(), nil
+}
+
+// Error implements error.
+func (e *InvalidHeaderError) Error() string {
+	return fmt.Sprintf("invalid header: %s", e.Reason)
+}
+
+// Error implements error.
+func (e *ShortPayloadError) Error() string {
+	return fmt.Sprintf("payload of message type %d is too short: expected %d bytes, got %d", e.Type, e.Expected, e.Size)
+}
+
+// Error implements error.
+func (e *UnexpectedTypeError) Error() string {
+	return fmt.Sprintf("unexpected message type: expected %d, got %d", e.Expected, e.Got)
+}
+
+// Error implements error.
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("unknown message type %d", e.Type)
+}
+
+// verifySize returns a ShortPayloadError if the payload is shorter than the expected size.
+// The message type of the error is set by the message decoding the payload.
+func verifySize(data []byte, expected int) error {
+	if len(data) < expected {
+		return &ShortPayloadError{Expected: expected, Size: len(data)}
+	}
+
+	return nil
+}
+
+// newEncoder returns an encoder with the given capacity.
+func newEncoder(capacity int) *encoder {
+	return &encoder{buffer: make([]byte, 0, capacity)}
+}
+
+func (e *encoder) uint8(value uint8) *encoder {
+	e.buffer = append(e.buffer, value)
+	return e
+}
+
+func (e *encoder) bool(value bool) *encoder {
+	if value {
+		return e.uint8(1)
+	}
+	return e.uint8(0)
+}
+
+func (e *encoder) uint16(value uint16) *encoder {
+	bytes := make([]byte, 2)
+	binary.LittleEndian.PutUint16(bytes, value)
+	e.buffer = append(e.buffer, bytes...)
+	return e
+}
+
+func (e *encoder) int16(value int16) *encoder {
+	return e.uint16(uint16(value))
+}
+
+func (e *encoder) uint32(value uint32) *encoder {
+	bytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bytes, value)
+	e.buffer = append(e.buffer, bytes...)
+	return e
+}
+
+func (e *encoder) float32(value float32) *encoder {
+	return e.uint32(math.Float32bits(value))
+}
+
+func (e *encoder) uint64(value uint64) *encoder {
+	bytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bytes, value)
+	e.buffer = append(e.buffer, bytes...)
+	return e
+}
+
+// bytes writes the given bytes.
+func (e *encoder) bytes(value []byte) *encoder {
+	e.buffer = append(e.buffer, value...)
+	return e
+}
+
+// string writes the string in a fixed size array of bytes, padded with 0.
+func (e *encoder) string(value string, size int) *encoder {
+	bytes := make([]byte, size)
+	copy(bytes, value)
+	return e.bytes(bytes)
+}
+
+// reserved writes size bytes equal to 0.
+func (e *encoder) reserved(size int) *encoder {
+	return e.bytes(make([]byte, size))
+}
+
+func (e *encoder) hsbk(value HSBK) *encoder {
+	return e.uint16(value.Hue).uint16(value.Saturation).uint16(value.Brightness).uint16(value.Kelvin)
+}
+
+// newDecoder returns a decoder reading the given bytes.
+func newDecoder(buffer []byte) *decoder {
+	return &decoder{buffer: buffer}
+}
+
+func (d *decoder) uint8() uint8 {
+	value := d.buffer[d.offset]
+	d.offset++
+	return value
+}
+
+func (d *decoder) bool() bool {
+	return d.uint8() != 0
+}
+
+func (d *decoder) uint16() uint16 {
+	value := binary.LittleEndian.Uint16(d.buffer[d.offset : d.offset+2])
+	d.offset += 2
+	return value
+}
+
+func (d *decoder) int16() int16 {
+	return int16(d.uint16())
+}
+
+func (d *decoder) uint32() uint32 {
+	value := binary.LittleEndian.Uint32(d.buffer[d.offset : d.offset+4])
+	d.offset += 4
+	return value
+}
+
+func (d *decoder) float32() float32 {
+	return math.Float32frombits(d.uint32())
+}
+
+func (d *decoder) uint64() uint64 {
+	value := binary.LittleEndian.Uint64(d.buffer[d.offset : d.offset+8])
+	d.offset += 8
+	return value
+}
+
+// bytes reads size bytes into the given array.
+func (d *decoder) bytes(value []byte) {
+	copy(value, d.buffer[d.offset:d.offset+len(value)])
+	d.offset += len(value)
+}
+
+// string reads a string from a fixed size array of bytes.
+func (d *decoder) string(size int) string {
+	value := tools.DecodeToString(d.buffer[d.offset : d.offset+size])
+	d.offset += size
+	return value
+}
+
+// reserved skips size bytes.
+func (d *decoder) reserved(size int) {
+	d.offset += size
+}
+
+func (d *decoder) hsbk() HSBK {
+	return HSBK{
+		Hue:        d.uint16(),
+		Saturation: d.uint16(),
+		Brightness: d.uint16(),
+		Kelvin:     d.uint16(),
+	}
+}
@@ -0,0 +1,57 @@
+package lifx
+
+import (
+	"image/color"
+)
+
+// HSBKFromColor converts a color to a HSBK with the given kelvin.
+// The transparent parts of the color are darkened.
+func HSBKFromColor(c color.Color, kelvin uint16) HSBK {
+	// The components are premultiplied by the alpha and range from 0 to 65535.
+	r, g, b, _ := c.RGBA()
+	high := r
+	if g > high {
+		high = g
+	}
+	if b > high {
+		high = b
+	}
+
+	low := r
+	if g < low {
+		low = g
+	}
+	if b < low {
+		low = b
+	}
+
+	hsbk := HSBK{
+		Brightness: uint16(high),
+		Kelvin:     kelvin,
+	}
+
+	// A gray does not have any hue nor saturation.
+	delta := float64(high - low)
+	if high == 0 || delta == 0 {
+		return hsbk
+	}
+
+	hsbk.Saturation = uint16(delta / float64(high) * 65535)
+
+	// The hue is computed in sixths of the color wheel.
+	var hue float64
+	switch high {
+	case r:
+		hue = (float64(g) - float64(b)) / delta
+		if hue < 0 {
+			hue += 6
+		}
+	case g:
+		hue = (float64(b)-float64(r))/delta + 2
+	default:
+		hue = (float64(r)-float64(g))/delta + 4
+	}
+	hsbk.Hue = uint16(hue / 6 * 65535)
+
+	return hsbk
+}
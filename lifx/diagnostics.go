@@ -0,0 +1,197 @@
+package lifx
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// Diagnostics contains the informations used to diagnose a misbehaving device.
+	Diagnostics struct {
+		// HostFirmware is the firmware of the device.
+		HostFirmware *Firmware `json:"hostFirmware"`
+
+		// WifiFirmware is the firmware of the wifi module of the device.
+		WifiFirmware *Firmware `json:"wifiFirmware"`
+
+		// Host contains the counters of the device.
+		Host *Signal `json:"host"`
+
+		// Wifi contains the signal and the counters of the wifi module of the device.
+		Wifi *Signal `json:"wifi"`
+
+		// UpTime is the time since the device was powered on.
+		UpTime time.Duration `json:"upTime"`
+
+		// DownTime is the duration of the last power off period of the device, with a 5 seconds accuracy.
+		DownTime time.Duration `json:"downTime"`
+
+		// ClockSkew is the difference between the clock of the device and the clock of the server.
+		// It is positive if the clock of the device is ahead.
+		ClockSkew time.Duration `json:"clockSkew"`
+	}
+
+	// Firmware contains the version of a firmware.
+	Firmware struct {
+		// Build is the build time of the firmware.
+		Build time.Time `json:"build"`
+
+		// Version is the version of the firmware, as major.minor.
+		Version string `json:"version"`
+	}
+
+	// Signal contains the radio signal and the counters of a device.
+	Signal struct {
+		// Signal is the received signal strength in milliwatts.
+		Signal float32 `json:"signal"`
+
+		// RSSI is the received signal strength in dBm.
+		RSSI int `json:"rssi"`
+
+		// Quality is the quality of the signal.
+		Quality SignalQuality `json:"quality"`
+
+		// Tx is the number of bytes transmitted since the device was powered on.
+		Tx uint32 `json:"tx"`
+
+		// Rx is the number of bytes received since the device was powered on.
+		Rx uint32 `json:"rx"`
+	}
+
+	// SignalQuality is the quality of a radio signal.
+	SignalQuality string
+)
+
+const (
+	// SignalNone is the quality of a missing signal.
+	SignalNone SignalQuality = "none"
+	// SignalVeryBad is the quality of a signal which makes the device often unreachable.
+	SignalVeryBad SignalQuality = "very_bad"
+	// SignalBad is the quality of a signal which may lose some messages.
+	SignalBad SignalQuality = "bad"
+	// SignalAlright is the quality of a usable signal.
+	SignalAlright SignalQuality = "alright"
+	// SignalGood is the quality of a good signal.
+	SignalGood SignalQuality = "good"
+)
+
+// newFirmware returns the firmware described by the payload of a StateHostFirmware (15)
+// or a StateWifiFirmware (19) message.
+func newFirmware(p *FirmwarePayload) *Firmware {
+	return &Firmware{
+		Build:   time.Unix(0, int64(p.Build)).UTC(),
+		Version: fmt.Sprintf("%d.%d", p.VersionMajor, p.VersionMinor),
+	}
+}
+
+// newSignal returns the signal described by the payload of a StateHostInfo (13)
+// or a StateWifiInfo (17) message.
+func newSignal(p *SignalPayload) *Signal {
+	rssi := 0
+	if p.Signal > 0 {
+		rssi = int(math.Floor(10*math.Log10(float64(p.Signal)) + 0.5))
+	}
+
+	return &Signal{
+		Signal:  p.Signal,
+		RSSI:    rssi,
+		Quality: signalQuality(rssi),
+		Tx:      p.Tx,
+		Rx:      p.Rx,
+	}
+}
+
+// signalQuality rates a signal strength in dBm.
+// The old firmwares report a signal to noise ratio instead, which is positive.
+func signalQuality(rssi int) SignalQuality {
+	if rssi < 0 {
+		switch {
+		case rssi <= -80:
+			return SignalVeryBad
+		case rssi <= -70:
+			return SignalBad
+		case rssi <= -60:
+			return SignalAlright
+		default:
+			return SignalGood
+		}
+	}
+
+	switch {
+	case rssi >= 4 && rssi <= 6:
+		return SignalVeryBad
+	case rssi >= 7 && rssi <= 11:
+		return SignalBad
+	case rssi >= 12 && rssi <= 16:
+		return SignalAlright
+	case rssi > 16 && rssi != 200:
+		return SignalGood
+	default:
+		return SignalNone
+	}
+}
+
+// ReadDiagnostics reads the firmwares, the signals, the counters and the clock of the device.
+func (l *Lifx) ReadDiagnostics() (*Diagnostics, error) {
+	return l.ReadDiagnosticsContext(context.Background())
+}
+
+// ReadDiagnosticsContext is like ReadDiagnostics but it stops waiting for the replies when the context is done.
+func (l *Lifx) ReadDiagnosticsContext(ctx context.Context) (*Diagnostics, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	diagnostics := &Diagnostics{}
+
+	// Sends a GetHostFirmware (14) Message
+	payload, err := l.request(ctx, GetMessageWithoutPayload(GetHostFirmware), StateHostFirmware)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the host firmware")
+	}
+	diagnostics.HostFirmware = newFirmware(payload.(*FirmwarePayload))
+
+	// Sends a GetWifiFirmware (18) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetWifiFirmware), StateWifiFirmware)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the wifi firmware")
+	}
+	diagnostics.WifiFirmware = newFirmware(payload.(*FirmwarePayload))
+
+	// Sends a GetHostInfo (12) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetHostInfo), StateHostInfo)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the host info")
+	}
+	diagnostics.Host = newSignal(payload.(*SignalPayload))
+
+	// Sends a GetWifiInfo (16) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetWifiInfo), StateWifiInfo)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the wifi info")
+	}
+	diagnostics.Wifi = newSignal(payload.(*SignalPayload))
+
+	// Sends a GetInfo (34) Message.
+	// The time of the device is compared to the time of the server halfway through the round trip.
+	sent := time.Now()
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetInfo), StateInfo)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the info")
+	}
+	halfway := sent.Add(time.Since(sent) / 2)
+
+	info := payload.(*StateInfoPayload).Info()
+	diagnostics.UpTime = time.Duration(info.UpTime)
+	diagnostics.DownTime = time.Duration(info.DownTime)
+	diagnostics.ClockSkew = time.Unix(0, int64(info.Time)).Sub(halfway)
+
+	l.mu.Lock()
+	l.Info = info
+	l.mu.Unlock()
+
+	return diagnostics, nil
+}
@@ -0,0 +1,113 @@
+package lifx
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/client"
+)
+
+func TestSignalQuality(t *testing.T) {
+	tests := []struct {
+		rssi    int
+		quality SignalQuality
+	}{
+		{-90, SignalVeryBad},
+		{-80, SignalVeryBad},
+		{-75, SignalBad},
+		{-70, SignalBad},
+		{-65, SignalAlright},
+		{-60, SignalAlright},
+		{-45, SignalGood},
+		{0, SignalNone},
+		{3, SignalNone},
+		{5, SignalVeryBad},
+		{10, SignalBad},
+		{14, SignalAlright},
+		{20, SignalGood},
+		{200, SignalNone},
+	}
+
+	for _, test := range tests {
+		if quality := signalQuality(test.rssi); quality != test.quality {
+			t.Errorf("rssi %d: expected %s, got %s", test.rssi, test.quality, quality)
+		}
+	}
+}
+
+func TestNewSignal(t *testing.T) {
+	tests := []struct {
+		signal float32
+		rssi   int
+	}{
+		{1e-6, -60},
+		{3.16e-5, -45},
+		{1e-8, -80},
+		{0, 0},
+	}
+
+	for _, test := range tests {
+		signal := newSignal(&SignalPayload{Signal: test.signal, Tx: 1, Rx: 2})
+		if signal.RSSI != test.rssi || signal.Quality != signalQuality(test.rssi) || signal.Tx != 1 || signal.Rx != 2 {
+			t.Errorf("signal %g: expected a rssi of %d, got %+v", test.signal, test.rssi, signal)
+		}
+	}
+}
+
+func TestReadDiagnostics(t *testing.T) {
+	// The clock of the device is an hour ahead.
+	conn, port, _ := rawDevice(t, func(packet []byte) []byte {
+		var reply *Message
+		switch MessageType(binary.LittleEndian.Uint16(packet[32:34])) {
+		case GetHostFirmware:
+			reply = NewMessageWithPayload(StateHostFirmware, &FirmwarePayload{Build: 1548977726000000000, VersionMinor: 70, VersionMajor: 3})
+		case GetWifiFirmware:
+			reply = NewMessageWithPayload(StateWifiFirmware, &FirmwarePayload{Build: 1456093684000000000, VersionMinor: 1, VersionMajor: 2})
+		case GetHostInfo:
+			reply = NewMessageWithPayload(StateHostInfo, &SignalPayload{Tx: 10, Rx: 20})
+		case GetWifiInfo:
+			reply = NewMessageWithPayload(StateWifiInfo, &SignalPayload{Signal: 1e-6, Tx: 30, Rx: 40})
+		case GetInfo:
+			now := uint64(time.Now().Add(time.Hour).UnixNano())
+			reply = NewMessageWithPayload(StateInfo, &StateInfoPayload{Time: now, UpTime: uint64(time.Minute), DownTime: uint64(5 * time.Second)})
+		default:
+			return nil
+		}
+
+		return reply.EncodeToBytes()
+	})
+	defer conn.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	l := &Lifx{Address: &ip, Port: port, Protocol: client.UDP}
+	diagnostics, err := l.ReadDiagnostics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if firmware := diagnostics.HostFirmware; firmware.Version != "3.70" || !firmware.Build.Equal(time.Date(2019, 1, 31, 23, 35, 26, 0, time.UTC)) {
+		t.Errorf("unexpected host firmware %+v", firmware)
+	}
+
+	if firmware := diagnostics.WifiFirmware; firmware.Version != "2.1" {
+		t.Errorf("unexpected wifi firmware %+v", firmware)
+	}
+
+	if diagnostics.Host.Tx != 10 || diagnostics.Host.Rx != 20 || diagnostics.Wifi.RSSI != -60 || diagnostics.Wifi.Quality != SignalAlright {
+		t.Errorf("unexpected signals %+v and %+v", diagnostics.Host, diagnostics.Wifi)
+	}
+
+	if diagnostics.UpTime != time.Minute || diagnostics.DownTime != 5*time.Second {
+		t.Errorf("unexpected uptime %v and downtime %v", diagnostics.UpTime, diagnostics.DownTime)
+	}
+
+	if skew := diagnostics.ClockSkew - time.Hour; skew < -time.Second || skew > time.Second {
+		t.Errorf("expected a clock skew of 1h, got %v", diagnostics.ClockSkew)
+	}
+
+	if l.Info == nil || l.Info.UpTime != uint64(time.Minute) {
+		t.Errorf("expected the info to be stored, got %+v", l.Info)
+	}
+}
@@ -0,0 +1,146 @@
+package lifx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/fberrez/horus/client"
+	"github.com/fberrez/horus/client/udp"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPort is the default port of LIFX devices.
+	DefaultPort = "56700"
+
+	// DefaultDiscoveryWindow is the default duration during which
+	// StateService replies are collected.
+	DefaultDiscoveryWindow = time.Second * 2
+
+	// serviceUDP is the service identifier of the UDP service.
+	serviceUDP uint8 = 1
+)
+
+// Discover broadcasts a GetService (2) message on the given domain,
+// written with the CIDR form (ex: 192.168.1.0/24), and collects every StateService (3)
+// reply received during the given window.
+// It returns a Lifx for each device exposing the UDP service.
+func Discover(domain string, window time.Duration) ([]*Lifx, error) {
+	return DiscoverContext(context.Background(), domain, window)
+}
+
+// DiscoverContext is like Discover but it stops collecting replies when the context is done.
+func DiscoverContext(ctx context.Context, domain string, window time.Duration) ([]*Lifx, error) {
+	message := GetMessageWithoutPayload(GetService)
+	return discover(ctx, domain, message, window)
+}
+
+// Locate broadcasts a GetService (2) message on the given domain
+// targeting only the device with the given serial.
+// It returns the device, with its current address and port.
+func Locate(domain string, serial Serial, window time.Duration) (*Lifx, error) {
+	return LocateContext(context.Background(), domain, serial, window)
+}
+
+// LocateContext is like Locate but it stops collecting replies when the context is done.
+func LocateContext(ctx context.Context, domain string, serial Serial, window time.Duration) (*Lifx, error) {
+	if serial.IsZero() {
+		return nil, errors.NotValidf("empty serial")
+	}
+
+	message := GetMessageWithoutPayload(GetService)
+	message.Header.SetTarget(serial.Target()).SetFrame(NTAFrame)
+	devices, err := discover(ctx, domain, message, window)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, device := range devices {
+		if device.Serial == serial {
+			return device, nil
+		}
+	}
+
+	return nil, errors.NotFoundf("device with serial %s", serial)
+}
+
+// discover broadcasts the GetService (2) message on the given domain
+// and returns a Lifx for each device exposing the UDP service.
+func discover(ctx context.Context, domain string, message *Message, window time.Duration) ([]*Lifx, error) {
+	logger := log.WithField("from", "lifx.discover")
+
+	broadcast, err := BroadcastAddress(domain)
+	if err != nil {
+		return nil, errors.Annotate(err, "discovering devices")
+	}
+
+	replies, err := udp.BroadcastContext(ctx, broadcast, DefaultPort, message.EncodeToBytes(), window)
+	if err != nil {
+		return nil, errors.Annotate(err, "discovering devices")
+	}
+
+	// found contains the already discovered devices, indexed by their address.
+	// A device can reply several times to the same broadcast.
+	found := map[string]bool{}
+	devices := []*Lifx{}
+	for _, reply := range replies {
+		message, payload, err := DecodeToPayload(reply.Packet, StateService)
+		if err != nil {
+			logger.WithField("address", reply.Addr.String()).Debugf("ignoring reply: %v", err)
+			continue
+		}
+		service := payload.(*StateServicePayload)
+		serial := SerialFromTarget(message.Header.Target())
+
+		if service.Service != serviceUDP {
+			continue
+		}
+
+		address := reply.Addr.IP
+		port := strconv.FormatUint(uint64(service.Port), 10)
+		key := fmt.Sprintf("%s:%s", address.String(), port)
+		if found[key] {
+			continue
+		}
+		found[key] = true
+
+		logger.WithFields(log.Fields{
+			"address": key,
+			"serial":  serial.String(),
+		}).Debug("device discovered")
+		devices = append(devices, &Lifx{
+			Serial:   serial,
+			Address:  &address,
+			Port:     port,
+			Protocol: client.UDP,
+		})
+	}
+
+	return devices, nil
+}
+
+// BroadcastAddress returns the broadcast address of a domain
+// written with the CIDR form (ex: 192.168.1.0/24).
+func BroadcastAddress(domain string) (*net.IP, error) {
+	_, network, err := net.ParseCIDR(domain)
+	if err != nil {
+		return nil, errors.NewNotValid(err, fmt.Sprintf("domain `%s`", domain))
+	}
+
+	ip := network.IP.To4()
+	if ip == nil {
+		return nil, errors.NotSupportedf("domain `%s` which is not an IPV4 network", domain)
+	}
+
+	// Sets every host bit of the network to 1.
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^network.Mask[i]
+	}
+
+	return &broadcast, nil
+}
@@ -0,0 +1,92 @@
+package lifx
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// servicePacket returns a StateService (3) message sent by the device of the given target.
+func servicePacket(target [8]byte, service uint8, port uint32) []byte {
+	message := NewMessageWithPayload(StateService, &StateServicePayload{Service: service, Port: port})
+	message.Header.SetTarget(target)
+	return message.EncodeToBytes()
+}
+
+func TestBroadcastAddress(t *testing.T) {
+	tests := []struct {
+		domain    string
+		broadcast string
+	}{
+		{"192.168.1.0/24", "192.168.1.255"},
+		{"192.168.1.42/24", "192.168.1.255"},
+		{"10.0.0.0/8", "10.255.255.255"},
+		{"172.16.4.17/30", "172.16.4.19"},
+		{"127.0.0.1/32", "127.0.0.1"},
+	}
+
+	for _, test := range tests {
+		broadcast, err := BroadcastAddress(test.domain)
+		if err != nil {
+			t.Errorf("domain `%s`: unexpected error: %v", test.domain, err)
+			continue
+		}
+
+		if broadcast.String() != test.broadcast {
+			t.Errorf("domain `%s`: expected %s, got %s", test.domain, test.broadcast, broadcast)
+		}
+	}
+
+	if _, err := BroadcastAddress("192.168.1.0"); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if _, err := BroadcastAddress("fe80::/64"); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	// The devices listen on the default port.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 56700})
+	if err != nil {
+		t.Skipf("cannot listen on the default port: %v", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buffer := make([]byte, 2048)
+		_, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		// A device may reply several times, and expose other services.
+		target := [8]byte{0xd0, 0x73, 0xd5, 0x01, 0x02, 0x03}
+		conn.WriteToUDP(servicePacket(target, serviceUDP, 56700), from)
+		conn.WriteToUDP(servicePacket(target, serviceUDP, 56700), from)
+		conn.WriteToUDP(servicePacket(target, 5, 56701), from)
+		conn.WriteToUDP([]byte{1, 2, 3}, from)
+	}()
+
+	devices, err := Discover("127.0.0.1/32", 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %d", len(devices))
+	}
+
+	if devices[0].Address.String() != "127.0.0.1" || devices[0].Port != "56700" {
+		t.Errorf("unexpected device %s:%s", devices[0].Address, devices[0].Port)
+	}
+}
+
+func TestLocateWithoutSerial(t *testing.T) {
+	if _, err := Locate("127.0.0.1/32", Serial{}, 10*time.Millisecond); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package lifx
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// FirmwareEffectType is the type of an effect run by the firmware of a device.
+	FirmwareEffectType string
+
+	// SkyType is the type of a sky effect.
+	SkyType string
+
+	// FirmwareEffect contains the settings of an effect run by the firmware of a multizone
+	// or a matrix device. The effect keeps running without any message from the client.
+	FirmwareEffect struct {
+		// Type is the type of the effect.
+		Type FirmwareEffectType `json:"type"`
+
+		// Speed is the duration of a cycle of the effect in milliseconds.
+		Speed uint32 `json:"speed"`
+
+		// Duration is how long the effect runs. The effect never stops if it is 0.
+		Duration time.Duration `json:"duration"`
+
+		// Reverse determines if a move effect moves the colors toward the first zone.
+		Reverse bool `json:"reverse,omitempty"`
+
+		// Palette contains the colors of a morph effect, 16 at most.
+		// If it is empty, a rainbow is used.
+		Palette []HSBK `json:"palette,omitempty"`
+
+		// Sky is the type of a sky effect.
+		Sky SkyType `json:"sky,omitempty"`
+
+		// CloudSaturationMin is the minimum saturation of the clouds of a sky effect.
+		CloudSaturationMin uint8 `json:"cloudSaturationMin,omitempty"`
+
+		// CloudSaturationMax is the maximum saturation of the clouds of a sky effect.
+		CloudSaturationMax uint8 `json:"cloudSaturationMax,omitempty"`
+	}
+)
+
+const (
+	// EffectOff stops the running effect.
+	EffectOff FirmwareEffectType = "off"
+	// EffectMove moves the colors of the zones of a multizone device along the strip.
+	EffectMove FirmwareEffectType = "move"
+	// EffectMorph morphs the colors of a palette over the tiles of a matrix device.
+	EffectMorph FirmwareEffectType = "morph"
+	// EffectFlame displays flames on the tiles of a matrix device.
+	EffectFlame FirmwareEffectType = "flame"
+	// EffectSky displays a sunrise, a sunset or clouds on the tiles of a matrix device.
+	EffectSky FirmwareEffectType = "sky"
+
+	// SkySunrise is a sky effect going from the night to the day.
+	SkySunrise SkyType = "sunrise"
+	// SkySunset is a sky effect going from the day to the night.
+	SkySunset SkyType = "sunset"
+	// SkyClouds is a sky effect with clouds moving over a blue sky.
+	SkyClouds SkyType = "clouds"
+)
+
+var (
+	// multiZoneEffects contains the types of the effects of the multizone messages.
+	multiZoneEffects = map[FirmwareEffectType]uint8{
+		EffectOff:  0,
+		EffectMove: 1,
+	}
+
+	// tileEffects contains the types of the effects of the tile messages.
+	tileEffects = map[FirmwareEffectType]uint8{
+		EffectOff:   0,
+		EffectMorph: 2,
+		EffectFlame: 3,
+		EffectSky:   5,
+	}
+
+	// skyTypes contains the types of the sky effects of the tile messages.
+	skyTypes = map[SkyType]uint32{
+		SkySunrise: 0,
+		SkySunset:  1,
+		SkyClouds:  2,
+	}
+
+	// rainbow is the palette of the morph effects which do not define any palette.
+	rainbow = []HSBK{
+		{Hue: 0, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+		{Hue: 7282, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+		{Hue: 10923, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+		{Hue: 21845, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+		{Hue: 32768, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+		{Hue: 43690, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+		{Hue: 50062, Saturation: 65535, Brightness: 65535, Kelvin: 3500},
+	}
+)
+
+// multiZonePayload returns the payload of a SetMultiZoneEffect (508) message running the effect.
+func (e *FirmwareEffect) multiZonePayload() *MultiZoneEffectPayload {
+	payload := &MultiZoneEffectPayload{
+		InstanceID: rand.Uint32(),
+		Type:       multiZoneEffects[e.Type],
+		Speed:      e.Speed,
+		Duration:   uint64(e.Duration),
+	}
+
+	// The second parameter is the direction of the move effect.
+	if e.Reverse {
+		payload.Parameters[1] = 1
+	}
+
+	return payload
+}
+
+// tilePayload returns the payload of a SetTileEffect (719) message running the effect.
+func (e *FirmwareEffect) tilePayload() *SetTileEffectPayload {
+	payload := &SetTileEffectPayload{TileEffectPayload{
+		InstanceID: rand.Uint32(),
+		Type:       tileEffects[e.Type],
+		Speed:      e.Speed,
+		Duration:   uint64(e.Duration),
+	}}
+
+	switch e.Type {
+	case EffectMorph:
+		palette := e.Palette
+		if len(palette) == 0 {
+			palette = rainbow
+		}
+		payload.PaletteCount = uint8(copy(payload.Palette[:], palette))
+	case EffectSky:
+		payload.Parameters[0] = skyTypes[e.Sky]
+		payload.Parameters[1] = uint32(e.CloudSaturationMin)
+		payload.Parameters[2] = uint32(e.CloudSaturationMax)
+	}
+
+	return payload
+}
+
+// firmwareEffect returns the effect described by the payload of a StateMultiZoneEffect (509) message.
+func (p *MultiZoneEffectPayload) firmwareEffect() *FirmwareEffect {
+	effect := &FirmwareEffect{
+		Type:     EffectOff,
+		Speed:    p.Speed,
+		Duration: time.Duration(p.Duration),
+		Reverse:  p.Parameters[1] == 1,
+	}
+
+	for effectType, value := range multiZoneEffects {
+		if value == p.Type {
+			effect.Type = effectType
+		}
+	}
+
+	return effect
+}
+
+// firmwareEffect returns the effect described by the payload of a StateTileEffect (720) message.
+func (p *StateTileEffectPayload) firmwareEffect() *FirmwareEffect {
+	effect := &FirmwareEffect{
+		Type:     EffectOff,
+		Speed:    p.Speed,
+		Duration: time.Duration(p.Duration),
+	}
+
+	for effectType, value := range tileEffects {
+		if value == p.Type {
+			effect.Type = effectType
+		}
+	}
+
+	switch effect.Type {
+	case EffectMorph:
+		count := int(p.PaletteCount)
+		if count > paletteSize {
+			count = paletteSize
+		}
+		effect.Palette = append([]HSBK{}, p.Palette[:count]...)
+	case EffectSky:
+		for skyType, value := range skyTypes {
+			if value == p.Parameters[0] {
+				effect.Sky = skyType
+			}
+		}
+		effect.CloudSaturationMin = uint8(p.Parameters[1])
+		effect.CloudSaturationMax = uint8(p.Parameters[2])
+	}
+
+	return effect
+}
+
+// SetFirmwareEffect runs an effect with the firmware of the device.
+// The move effect requires a multizone device, and the morph, flame and sky effects require a matrix device.
+// The off effect stops the effect running on any of them.
+// It returns the statistics of the delivered messages.
+func (l *Lifx) SetFirmwareEffect(effect *FirmwareEffect) (*Delivery, error) {
+	return l.SetFirmwareEffectContext(context.Background(), effect)
+}
+
+// SetFirmwareEffectContext is like SetFirmwareEffect but it stops sending messages when the context is done.
+func (l *Lifx) SetFirmwareEffectContext(ctx context.Context, effect *FirmwareEffect) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if effect.Type == EffectSky {
+		if _, ok := skyTypes[effect.Sky]; !ok {
+			return nil, errors.NotValidf("sky type `%s`", effect.Sky)
+		}
+	}
+
+	if len(effect.Palette) > paletteSize {
+		return nil, errors.NotValidf("palette of %d colors", len(effect.Palette))
+	}
+
+	// Sends a SetTileEffect or a SetMultiZoneEffect message to the device
+	capabilities := l.capabilities()
+	var message *Message
+	if _, ok := tileEffects[effect.Type]; ok && capabilities.HasMatrix {
+		message = SetTileEffectMessage(effect)
+	} else if _, ok := multiZoneEffects[effect.Type]; ok && capabilities.HasMultiZone {
+		message = SetMultiZoneEffectMessage(effect)
+	} else {
+		return nil, errors.NotSupportedf("%s effect on device %s", effect.Type, l.UUID)
+	}
+
+	delivery, err := l.deliver(ctx, message)
+	if err != nil {
+		return delivery, errors.Annotate(err, "setting firmware effect")
+	}
+
+	l.mu.Lock()
+	l.FirmwareEffect = effect
+	l.mu.Unlock()
+
+	return delivery, nil
+}
+
+// ReadFirmwareEffect reads the effect run by the firmware of a multizone or a matrix device.
+func (l *Lifx) ReadFirmwareEffect() (*FirmwareEffect, error) {
+	return l.ReadFirmwareEffectContext(context.Background())
+}
+
+// ReadFirmwareEffectContext is like ReadFirmwareEffect but it stops waiting for the reply when the context is done.
+func (l *Lifx) ReadFirmwareEffectContext(ctx context.Context) (*FirmwareEffect, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	var effect *FirmwareEffect
+	capabilities := l.capabilities()
+	switch {
+	case capabilities.HasMatrix:
+		// Sends a GetTileEffect (718) Message
+		payload, err := l.request(ctx, GetTileEffectMessage(), StateTileEffect)
+		if err != nil {
+			return nil, errors.Annotate(err, "reading firmware effect")
+		}
+		effect = payload.(*StateTileEffectPayload).firmwareEffect()
+	case capabilities.HasMultiZone:
+		// Sends a GetMultiZoneEffect (507) Message
+		payload, err := l.request(ctx, GetMessageWithoutPayload(GetMultiZoneEffect), StateMultiZoneEffect)
+		if err != nil {
+			return nil, errors.Annotate(err, "reading firmware effect")
+		}
+		effect = payload.(*MultiZoneEffectPayload).firmwareEffect()
+	default:
+		return nil, errors.NotSupportedf("firmware effects on device %s", l.UUID)
+	}
+
+	l.mu.Lock()
+	l.FirmwareEffect = effect
+	l.mu.Unlock()
+
+	return effect, nil
+}
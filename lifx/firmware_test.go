@@ -0,0 +1,137 @@
+package lifx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func TestFirmwareEffectPayloads(t *testing.T) {
+	palette := []HSBK{sampleColor(0), sampleColor(1), sampleColor(2)}
+	tests := []struct {
+		effect   *FirmwareEffect
+		tile     bool
+		code     uint8
+		expected *FirmwareEffect
+	}{
+		{
+			effect: &FirmwareEffect{Type: EffectMove, Speed: 2000, Duration: time.Minute, Reverse: true},
+			code:   1,
+		},
+		{
+			effect: &FirmwareEffect{Type: EffectOff},
+			code:   0,
+		},
+		{
+			effect: &FirmwareEffect{Type: EffectMorph, Speed: 3000, Palette: palette},
+			tile:   true,
+			code:   2,
+		},
+		{
+			effect:   &FirmwareEffect{Type: EffectMorph, Speed: 3000},
+			tile:     true,
+			code:     2,
+			expected: &FirmwareEffect{Type: EffectMorph, Speed: 3000, Palette: rainbow},
+		},
+		{
+			effect: &FirmwareEffect{Type: EffectFlame, Speed: 4000, Duration: time.Hour},
+			tile:   true,
+			code:   3,
+		},
+		{
+			effect: &FirmwareEffect{Type: EffectSky, Speed: 50000, Sky: SkyClouds, CloudSaturationMin: 50, CloudSaturationMax: 180},
+			tile:   true,
+			code:   5,
+		},
+	}
+
+	for _, test := range tests {
+		if test.expected == nil {
+			test.expected = test.effect
+		}
+
+		// The effect read from the device is the effect which was set.
+		var code uint8
+		var effect *FirmwareEffect
+		if test.tile {
+			payload := test.effect.tilePayload()
+			code = payload.Type
+			effect = (&StateTileEffectPayload{TileEffectPayload: payload.TileEffectPayload}).firmwareEffect()
+		} else {
+			payload := test.effect.multiZonePayload()
+			code = payload.Type
+			effect = payload.firmwareEffect()
+		}
+
+		if code != test.code {
+			t.Errorf("effect %s: expected the type %d, got %d", test.effect.Type, test.code, code)
+		}
+
+		if !reflect.DeepEqual(effect, test.expected) {
+			t.Errorf("effect %s: expected %+v, got %+v", test.effect.Type, test.expected, effect)
+		}
+	}
+
+	// The unknown effects are read as stopped.
+	if effect := (&MultiZoneEffectPayload{Type: 42}).firmwareEffect(); effect.Type != EffectOff {
+		t.Errorf("expected the effect %s, got %s", EffectOff, effect.Type)
+	}
+
+	if effect := (&StateTileEffectPayload{TileEffectPayload{Type: 42}}).firmwareEffect(); effect.Type != EffectOff {
+		t.Errorf("expected the effect %s, got %s", EffectOff, effect.Type)
+	}
+}
+
+func TestSetFirmwareEffect(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	// A multizone device only runs the move effect.
+	l.Product = &Product{Capabilities: &Capabilities{HasMultiZone: true}}
+	effect := &FirmwareEffect{Type: EffectMove, Speed: 1000}
+	if _, err := l.SetFirmwareEffect(effect); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receivedMessages(t, received)
+	if len(messages) != 1 || messages[0].Header.Type() != SetMultiZoneEffect {
+		t.Fatalf("expected a SetMultiZoneEffect message, got %d messages", len(messages))
+	}
+
+	if l.FirmwareEffect != effect {
+		t.Errorf("expected the effect to be stored, got %+v", l.FirmwareEffect)
+	}
+
+	if _, err := l.SetFirmwareEffect(&FirmwareEffect{Type: EffectFlame}); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	// A matrix device runs the other effects.
+	l.Product = &Product{Capabilities: &Capabilities{HasMatrix: true}}
+	if _, err := l.SetFirmwareEffect(&FirmwareEffect{Type: EffectFlame}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages = receivedMessages(t, received)
+	if len(messages) != 1 || messages[0].Header.Type() != SetTileEffect {
+		t.Fatalf("expected a SetTileEffect message, got %d messages", len(messages))
+	}
+
+	if _, err := l.SetFirmwareEffect(&FirmwareEffect{Type: EffectMove}); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	if _, err := l.SetFirmwareEffect(&FirmwareEffect{Type: EffectSky, Sky: "rain"}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if _, err := l.SetFirmwareEffect(&FirmwareEffect{Type: EffectMorph, Palette: make([]HSBK, paletteSize+1)}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if len(received) != 0 {
+		t.Errorf("expected no message for the invalid effects, got %d", len(received))
+	}
+}
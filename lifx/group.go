@@ -0,0 +1,72 @@
+package lifx
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+)
+
+// SetGroup moves the device to the given group.
+// The devices sharing the ID of the group are in the same group, and the label
+// of the group is the label of the device whose group has been updated last,
+// so the update time of the group must be the current time.
+// It returns the statistics of the delivery.
+func (l *Lifx) SetGroup(group *Group) (*Delivery, error) {
+	return l.SetGroupContext(context.Background(), group)
+}
+
+// SetGroupContext is like SetGroup but it stops sending the message when the context is done.
+func (l *Lifx) SetGroupContext(ctx context.Context, group *Group) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if len(group.Label) > labelSize {
+		return nil, errors.NotValidf("group label `%s` longer than %d bytes", group.Label, labelSize)
+	}
+
+	// Sends a SetGroup message to the device
+	delivery, err := l.deliver(ctx, SetGroupMessage(group))
+	if err != nil {
+		return delivery, errors.Annotate(err, "setting group")
+	}
+
+	// Updates device. The group is copied, so it is never modified by the caller.
+	updated := *group
+	l.mu.Lock()
+	l.Group = &updated
+	l.mu.Unlock()
+
+	return delivery, nil
+}
+
+// SetLocation moves the device to the given location.
+// Like the groups, the label of a location is the label of the device whose
+// location has been updated last, so the update time of the location must be the current time.
+// It returns the statistics of the delivery.
+func (l *Lifx) SetLocation(location *Location) (*Delivery, error) {
+	return l.SetLocationContext(context.Background(), location)
+}
+
+// SetLocationContext is like SetLocation but it stops sending the message when the context is done.
+func (l *Lifx) SetLocationContext(ctx context.Context, location *Location) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if len(location.Label) > labelSize {
+		return nil, errors.NotValidf("location label `%s` longer than %d bytes", location.Label, labelSize)
+	}
+
+	// Sends a SetLocation message to the device
+	delivery, err := l.deliver(ctx, SetLocationMessage(location))
+	if err != nil {
+		return delivery, errors.Annotate(err, "setting location")
+	}
+
+	// Updates device. The location is copied, so it is never modified by the caller.
+	updated := *location
+	l.mu.Lock()
+	l.Location = &updated
+	l.mu.Unlock()
+
+	return delivery, nil
+}
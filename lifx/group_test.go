@@ -0,0 +1,70 @@
+package lifx
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func TestSetGroup(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	if _, err := l.SetGroup(&Group{Label: strings.Repeat("a", labelSize+1)}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if len(received) != 0 {
+		t.Fatalf("expected no message, got %d", len(received))
+	}
+
+	group := &Group{ID: ID{1, 2, 3}, Label: "Kitchen", UpdatedAt: time.Unix(1553350342, 0)}
+	if _, err := l.SetGroup(group); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receivedMessages(t, received)
+	if len(messages) != 1 || messages[0].Header.Type() != SetGroup {
+		t.Fatalf("expected a SetGroup message, got %d messages", len(messages))
+	}
+
+	payload, err := messages[0].Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := payload.(*GroupPayload); ID(p.ID) != group.ID || p.Label != "Kitchen" || p.UpdatedAt != uint64(group.UpdatedAt.UnixNano()) {
+		t.Errorf("unexpected payload %+v", p)
+	}
+
+	// The stored group is a copy.
+	group.Label = "Bedroom"
+	if l.Group == nil || l.Group.ID != group.ID || l.Group.Label != "Kitchen" {
+		t.Errorf("expected the group to be stored, got %+v", l.Group)
+	}
+}
+
+func TestSetLocation(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	if _, err := l.SetLocation(&Location{Label: strings.Repeat("a", labelSize+1)}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	location := &Location{ID: ID{4, 5, 6}, Label: "Home", UpdatedAt: time.Unix(1553350342, 0)}
+	if _, err := l.SetLocation(location); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receivedMessages(t, received)
+	if len(messages) != 1 || messages[0].Header.Type() != SetLocation {
+		t.Fatalf("expected a SetLocation message, got %d messages", len(messages))
+	}
+
+	if l.Location == nil || *l.Location != *location || l.Location == location {
+		t.Errorf("expected a copy of the location to be stored, got %+v", l.Location)
+	}
+}
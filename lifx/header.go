@@ -18,6 +18,7 @@ type (
 		messageType [2]byte
 	}
 
+	// MessageType is the type of a message, defined in its header.
 	MessageType uint16
 )
 
@@ -29,6 +30,26 @@ var (
 	size            uint = 34
 )
 
+const (
+	// HeaderSize is the size of a header in bytes, including the size of the message.
+	HeaderSize = 36
+
+	// Protocol is the protocol number of the LIFX LAN protocol.
+	Protocol uint16 = 1024
+
+	// addressableBit is the bit of the frame set when the message contains a target.
+	addressableBit uint16 = 1 << 12
+	// taggedBit is the bit of the frame set when the message is sent to all devices.
+	taggedBit uint16 = 1 << 13
+	// protocolMask is the mask of the protocol number in the frame.
+	protocolMask uint16 = 0X0FFF
+
+	// resRequiredBit is the bit set when a response message is required.
+	resRequiredBit byte = 1 << 0
+	// ackRequiredBit is the bit set when an acknowledgement message is required.
+	ackRequiredBit byte = 1 << 1
+)
+
 const (
 	GetService        MessageType = 2
 	StateService      MessageType = 3
@@ -62,8 +83,52 @@ const (
 	Get               MessageType = 101
 	SetColor          MessageType = 102
 	SetWaveform       MessageType = 103
+	StateLight        MessageType = 107
 	GetPowerLight     MessageType = 116
 	SetPowerLight     MessageType = 117
+	StatePowerLight   MessageType = 118
+
+	// Waveform effects applied on a subset of the color channels
+	SetWaveformOptional MessageType = 119
+
+	// Multizone messages
+	SetColorZones           MessageType = 501
+	GetColorZones           MessageType = 502
+	StateZone               MessageType = 503
+	StateMultiZone          MessageType = 506
+	SetExtendedColorZones   MessageType = 510
+	GetExtendedColorZones   MessageType = 511
+	StateExtendedColorZones MessageType = 512
+
+	// Matrix messages
+	GetDeviceChain   MessageType = 701
+	StateDeviceChain MessageType = 702
+	Get64            MessageType = 707
+	State64          MessageType = 711
+	Set64            MessageType = 715
+
+	// Firmware effects messages
+	GetMultiZoneEffect   MessageType = 507
+	SetMultiZoneEffect   MessageType = 508
+	StateMultiZoneEffect MessageType = 509
+	GetTileEffect        MessageType = 718
+	SetTileEffect        MessageType = 719
+	StateTileEffect      MessageType = 720
+
+	// Infrared messages
+	GetInfrared   MessageType = 120
+	StateInfrared MessageType = 121
+	SetInfrared   MessageType = 122
+
+	// HEV messages
+	GetHevCycle                MessageType = 142
+	SetHevCycle                MessageType = 143
+	StateHevCycle              MessageType = 144
+	GetHevCycleConfiguration   MessageType = 145
+	SetHevCycleConfiguration   MessageType = 146
+	StateHevCycleConfiguration MessageType = 147
+	GetLastHevCycleResult      MessageType = 148
+	StateLastHevCycleResult    MessageType = 149
 )
 
 // NewHeader build a header with given informations.
@@ -117,6 +182,7 @@ func (h *Header) IsResRequired(required bool) *Header {
 // Note that a sequence is only useful if a acknowledge or a response message is required.
 // Otherwise, it must be equal to []byte{0X00}.
 // The sequence which is in argument must be written in a little endian format.
+// The UDP client overwrites it with the next sequence number of the device, so the messages sent through a device do not set it.
 func (h *Header) SetSequence(sequence byte) *Header {
 	h.sequence = sequence
 	return h
@@ -160,36 +226,97 @@ func (h *Header) EncodeToBytes() []byte {
 	return buffer[0:34]
 }
 
-// ackResBytes converts the ack and res bool present in the header to a byte.
+// encodeAckResToByte converts the ack and res bool present in the header to a byte.
 func (h *Header) encodeAckResToByte() byte {
-	if !h.ackRequired && !h.resRequired {
-		return 0X00
-	}
-	if !h.ackRequired && h.resRequired {
-		return 0X01
+	var result byte
+	if h.ackRequired {
+		result |= ackRequiredBit
 	}
-	if h.ackRequired && !h.resRequired {
-		return 0X10
+	if h.resRequired {
+		result |= resRequiredBit
 	}
 
-	return 0X11
+	return result
 }
 
 // decodeAckRes decode a byte to its corresponding value in two bools.
 // The first bool is the acknowledgement-required setting
 // The second bool is the response-required setting
-func decodeAckRes(byte byte) (bool, bool) {
-	if byte == 0X00 {
-		return false, false
-	}
-	if byte == 0X01 {
-		return false, true
+func decodeAckRes(b byte) (bool, bool) {
+	return b&ackRequiredBit != 0, b&resRequiredBit != 0
+}
+
+// DecodeToHeader decodes the header of a packet, given in arguments.
+// The packet must start with the size of the message.
+// It verifies the length of the packet and the protocol number.
+func DecodeToHeader(bytes []byte) (*Header, error) {
+	if len(bytes) < HeaderSize {
+		return nil, &InvalidHeaderError{Reason: fmt.Sprintf("packet of %d bytes is shorter than a header", len(bytes))}
 	}
-	if byte == 0X10 {
-		return true, false
+
+	h := &Header{}
+	copy(h.frame[:], bytes[2:4])
+	copy(h.source[:], bytes[4:8])
+	copy(h.target[:], bytes[8:16])
+	h.ackRequired, h.resRequired = decodeAckRes(bytes[22])
+	h.sequence = bytes[23]
+	copy(h.messageType[:], bytes[32:34])
+
+	if h.Protocol() != Protocol {
+		return nil, &InvalidHeaderError{Reason: fmt.Sprintf("protocol %d is not supported", h.Protocol())}
 	}
 
-	return true, true
+	return h, nil
+}
+
+// Protocol returns the protocol number of the header.
+func (h *Header) Protocol() uint16 {
+	return binary.LittleEndian.Uint16(h.frame[:]) & protocolMask
+}
+
+// Origin returns the origin of the header. It is always equal to 0.
+func (h *Header) Origin() uint8 {
+	return uint8(binary.LittleEndian.Uint16(h.frame[:]) >> 14)
+}
+
+// IsTagged returns true if the message is sent to all devices.
+func (h *Header) IsTagged() bool {
+	return binary.LittleEndian.Uint16(h.frame[:])&taggedBit != 0
+}
+
+// IsAddressable returns true if the message contains a target.
+func (h *Header) IsAddressable() bool {
+	return binary.LittleEndian.Uint16(h.frame[:])&addressableBit != 0
+}
+
+// Source returns the source identifier of the header.
+func (h *Header) Source() uint32 {
+	return binary.LittleEndian.Uint32(h.source[:])
+}
+
+// Target returns the target of the header.
+func (h *Header) Target() [8]byte {
+	return h.target
+}
+
+// AckRequired returns true if an acknowledgement message is required.
+func (h *Header) AckRequired() bool {
+	return h.ackRequired
+}
+
+// ResRequired returns true if a response message is required.
+func (h *Header) ResRequired() bool {
+	return h.resRequired
+}
+
+// Sequence returns the sequence number of the header.
+func (h *Header) Sequence() byte {
+	return h.sequence
+}
+
+// Type returns the message type of the header.
+func (h *Header) Type() MessageType {
+	return MessageType(binary.LittleEndian.Uint16(h.messageType[:]))
 }
 
 // getSize returns the size of the header in bytes.
@@ -0,0 +1,241 @@
+package lifx
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// Latency contains the statistics of the echo probes sent to a device.
+	// The lost probes are not taken into account by the round trip times.
+	Latency struct {
+		// Sent is the number of probes sent to the device.
+		Sent int `json:"sent"`
+
+		// Lost is the number of probes which the device did not echo in time.
+		Lost int `json:"lost"`
+
+		// Loss is the ratio of lost probes, from 0 to 1.
+		Loss float64 `json:"loss"`
+
+		// Min is the shortest round trip time.
+		Min time.Duration `json:"min"`
+
+		// Avg is the average round trip time.
+		Avg time.Duration `json:"avg"`
+
+		// Max is the longest round trip time.
+		Max time.Duration `json:"max"`
+
+		// Jitter is the average difference between the round trip times of two consecutive probes.
+		Jitter time.Duration `json:"jitter"`
+	}
+
+	// probe is the result of an echo probe.
+	probe struct {
+		// rtt is the round trip time of the probe.
+		rtt time.Duration
+
+		// lost determines if the probe has not been echoed in time.
+		lost bool
+	}
+)
+
+const (
+	// minRetryDelay is the time to wait before contacting again a device which failed once.
+	minRetryDelay = time.Second * 5
+
+	// maxRetryDelay is the maximum time to wait before contacting again an unreachable device.
+	maxRetryDelay = time.Minute * 5
+
+	// echoTimeout is the time to wait for the echo of a probe before considering it lost.
+	echoTimeout = time.Millisecond * 500
+
+	// latencyWindow is the number of last probes taken into account by the latency of a device.
+	latencyWindow = 50
+)
+
+// Available returns true if the device can be contacted.
+// A device which stopped answering is not available until its retry delay is over.
+// This delay doubles after each failure, up to 5 minutes.
+func (l *Lifx) Available() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.failures == 0 || !time.Now().Before(l.retryAt)
+}
+
+// RetryAt returns the time from which an unreachable device can be contacted again.
+func (l *Lifx) RetryAt() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.retryAt
+}
+
+// report updates the health of the device with the result of a communication.
+// A communication aborted by its context does not change the health of the device.
+func (l *Lifx) report(ctx context.Context, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err == nil {
+		l.Connected = true
+		l.LastSeen = time.Now()
+		l.LastError = ""
+		l.failures = 0
+		l.retryAt = time.Time{}
+		return
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	l.Connected = false
+	l.LastError = err.Error()
+	l.failures++
+
+	delay := minRetryDelay
+	for i := 1; i < l.failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	l.retryAt = time.Now().Add(delay)
+}
+
+// newLatency returns the statistics of the given probes.
+func newLatency(probes []probe) *Latency {
+	latency := &Latency{Sent: len(probes)}
+
+	var total, deviations, previous time.Duration
+	received := 0
+	for _, p := range probes {
+		if p.lost {
+			latency.Lost++
+			continue
+		}
+
+		if received == 0 || p.rtt < latency.Min {
+			latency.Min = p.rtt
+		}
+		if p.rtt > latency.Max {
+			latency.Max = p.rtt
+		}
+		if received > 0 {
+			deviation := p.rtt - previous
+			if deviation < 0 {
+				deviation = -deviation
+			}
+			deviations += deviation
+		}
+
+		total += p.rtt
+		previous = p.rtt
+		received++
+	}
+
+	if latency.Sent > 0 {
+		latency.Loss = float64(latency.Lost) / float64(latency.Sent)
+	}
+	if received > 0 {
+		latency.Avg = total / time.Duration(received)
+	}
+	if received > 1 {
+		latency.Jitter = deviations / time.Duration(received-1)
+	}
+
+	return latency
+}
+
+// Ping sends the given number of echo probes to the device, one after the other.
+// Each probe is sent once and carries random bytes, which must be echoed by the device.
+// The probes are recorded in the latency of the device, which covers the last 50 probes.
+// It returns the statistics of the sent probes, and a timeout error if every probe is lost.
+func (l *Lifx) Ping(count int) (*Latency, error) {
+	return l.PingContext(context.Background(), count)
+}
+
+// PingContext is like Ping but it stops sending probes when the context is done.
+func (l *Lifx) PingContext(ctx context.Context, count int) (*Latency, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if count <= 0 {
+		return nil, errors.NotValidf("count of %d probes", count)
+	}
+
+	probes := make([]probe, 0, count)
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		rtt, err := l.echo(ctx)
+		// A probe aborted by the context is not lost.
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+
+		probes = append(probes, probe{rtt: rtt, lost: err != nil})
+	}
+
+	latency := newLatency(probes)
+
+	err := ctx.Err()
+	if err == nil && latency.Lost == latency.Sent {
+		err = errors.Timeoutf("echo from device %s", l.UUID)
+	}
+	l.report(ctx, err)
+
+	// Updates device. The window is copied, so the snapshots of the device are not modified.
+	l.mu.Lock()
+	window := append(append([]probe{}, l.probes...), probes...)
+	if len(window) > latencyWindow {
+		window = window[len(window)-latencyWindow:]
+	}
+	l.probes = window
+	l.Latency = newLatency(window)
+	l.mu.Unlock()
+
+	return latency, err
+}
+
+// echo sends an EchoRequest (58) message with a random payload, without retrying it,
+// and verifies the payload echoed by the device.
+// It returns the round trip time of the message.
+// The caller must hold the commands lock.
+func (l *Lifx) echo(ctx context.Context) (time.Duration, error) {
+	sent := &EchoPayload{}
+	rand.Read(sent.Payload[:])
+
+	c, request, err := l.prepare(EchoRequestMessage(sent))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Expect = []uint16{uint16(EchoResponse)}
+	request.Deadline = echoTimeout
+	response, err := c.DoContext(ctx, request)
+	if err != nil {
+		return 0, err
+	}
+
+	reply, err := DecodeToMessage(response.Packets[0])
+	if err != nil {
+		return 0, errors.Annotatef(err, "decoding echo of device %s", l.UUID)
+	}
+
+	payload, err := reply.Payload()
+	if err != nil {
+		return 0, errors.Annotatef(err, "decoding echo of device %s", l.UUID)
+	}
+
+	if echoed := payload.(*EchoPayload); !bytes.Equal(echoed.Payload[:], sent.Payload[:]) {
+		return 0, errors.NotValidf("echo of device %s", l.UUID)
+	}
+
+	return response.RTT, nil
+}
@@ -0,0 +1,144 @@
+package lifx
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/client"
+	"github.com/juju/errors"
+)
+
+func TestReportBackoff(t *testing.T) {
+	l := &Lifx{}
+	ctx := context.Background()
+	failure := errors.Timeoutf("reply")
+
+	// The retry delay doubles after each failure, up to 5 minutes.
+	delays := []time.Duration{
+		5 * time.Second,
+		10 * time.Second,
+		20 * time.Second,
+		40 * time.Second,
+		80 * time.Second,
+		160 * time.Second,
+		maxRetryDelay,
+		maxRetryDelay,
+	}
+	for i, expected := range delays {
+		before := time.Now()
+		l.report(ctx, failure)
+		if l.failures != i+1 {
+			t.Fatalf("failure %d: expected %d failures, got %d", i+1, i+1, l.failures)
+		}
+
+		if delay := l.RetryAt().Sub(before); delay < expected || delay > expected+time.Second {
+			t.Errorf("failure %d: expected a retry delay of %v, got %v", i+1, expected, delay)
+		}
+
+		if l.Available() || l.Connected || l.LastError != failure.Error() {
+			t.Errorf("failure %d: expected an unavailable device, got %+v", i+1, l)
+		}
+	}
+
+	// A device is available again once its retry delay is over.
+	l.retryAt = time.Now().Add(-time.Second)
+	if !l.Available() {
+		t.Errorf("expected the device to be available after its retry delay")
+	}
+
+	// A success resets the health of the device.
+	l.report(ctx, nil)
+	if !l.Available() || !l.Connected || l.failures != 0 || !l.RetryAt().IsZero() || len(l.LastError) != 0 {
+		t.Errorf("expected a healthy device, got %+v", l)
+	}
+
+	if time.Since(l.LastSeen) > time.Second {
+		t.Errorf("expected the device to be seen, got %v", l.LastSeen)
+	}
+}
+
+func TestReportContextError(t *testing.T) {
+	l := &Lifx{Connected: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A context error does not count as a failure.
+	l.report(ctx, errors.Annotate(ctx.Err(), "waiting for reply"))
+	if l.failures != 0 || !l.Connected || !l.Available() || len(l.LastError) != 0 {
+		t.Errorf("expected the health to be unchanged, got %+v", l)
+	}
+}
+
+func TestNewLatency(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		probes  []probe
+		latency Latency
+	}{
+		{
+			name: "no probe",
+		},
+		{
+			name:    "every probe lost",
+			probes:  []probe{{lost: true}, {lost: true}},
+			latency: Latency{Sent: 2, Lost: 2, Loss: 1},
+		},
+		{
+			name:    "single probe",
+			probes:  []probe{{rtt: 10 * ms}},
+			latency: Latency{Sent: 1, Min: 10 * ms, Avg: 10 * ms, Max: 10 * ms},
+		},
+		{
+			name:    "jitter between the consecutive received probes",
+			probes:  []probe{{rtt: 10 * ms}, {rtt: 30 * ms}, {lost: true}, {rtt: 20 * ms}},
+			latency: Latency{Sent: 4, Lost: 1, Loss: 0.25, Min: 10 * ms, Avg: 20 * ms, Max: 30 * ms, Jitter: 15 * ms},
+		},
+	}
+
+	for _, test := range tests {
+		if latency := newLatency(test.probes); *latency != test.latency {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.latency, *latency)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	// The device does not echo the second probe.
+	requests := 0
+	conn, port, _ := rawDevice(t, func(packet []byte) []byte {
+		_, payload, err := DecodeToPayload(packet, EchoRequest)
+		if err != nil {
+			return nil
+		}
+
+		requests++
+		if requests == 2 {
+			return nil
+		}
+
+		return NewMessageWithPayload(EchoResponse, payload).EncodeToBytes()
+	})
+	defer conn.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	l := &Lifx{Address: &ip, Port: port, Protocol: client.UDP}
+	if _, err := l.Ping(0); !errors.IsNotValid(err) {
+		t.Errorf("expected an invalid count, got %v", err)
+	}
+
+	latency, err := l.Ping(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if latency.Sent != 3 || latency.Lost != 1 || latency.Min <= 0 || latency.Max >= echoTimeout {
+		t.Errorf("unexpected latency %+v", latency)
+	}
+
+	if l.Latency == nil || *l.Latency != *latency || !l.Available() {
+		t.Errorf("expected the latency to be stored, got %+v", l.Latency)
+	}
+}
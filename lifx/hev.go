@@ -0,0 +1,195 @@
+package lifx
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// Hev contains the state of the HEV (High Energy Visible) light of a device,
+	// which runs cycles disinfecting the surfaces around the device.
+	Hev struct {
+		// Duration is the duration of the current cycle.
+		Duration time.Duration `json:"duration"`
+
+		// Remaining is the remaining duration of the current cycle.
+		// It is 0 if no cycle is running.
+		Remaining time.Duration `json:"remaining"`
+
+		// LastPower determines if the light was on before the current cycle started.
+		LastPower bool `json:"lastPower"`
+
+		// DefaultDuration is the duration of the cycles started without any duration.
+		DefaultDuration time.Duration `json:"defaultDuration"`
+
+		// Indication determines if the light briefly flashes when a cycle ends.
+		Indication bool `json:"indication"`
+
+		// LastResult is the result of the last cycle.
+		LastResult HevResult `json:"lastResult"`
+	}
+
+	// HevResult is the result of a HEV cycle.
+	HevResult string
+)
+
+const (
+	// HevSuccess is the result of a cycle which ran until its end.
+	HevSuccess HevResult = "success"
+	// HevBusy is the result of a cycle which is still running.
+	HevBusy HevResult = "busy"
+	// HevInterruptedByReset is the result of a cycle interrupted by a reset of the device.
+	HevInterruptedByReset HevResult = "interrupted_by_reset"
+	// HevInterruptedByHomekit is the result of a cycle interrupted by HomeKit.
+	HevInterruptedByHomekit HevResult = "interrupted_by_homekit"
+	// HevInterruptedByLAN is the result of a cycle interrupted by a LAN message.
+	HevInterruptedByLAN HevResult = "interrupted_by_lan"
+	// HevInterruptedByCloud is the result of a cycle interrupted by the LIFX cloud.
+	HevInterruptedByCloud HevResult = "interrupted_by_cloud"
+	// HevNone is the result of a device which never ran any cycle.
+	HevNone HevResult = "none"
+)
+
+// hevResults contains the results of the StateLastHevCycleResult (149) messages.
+var hevResults = map[uint8]HevResult{
+	0:   HevSuccess,
+	1:   HevBusy,
+	2:   HevInterruptedByReset,
+	3:   HevInterruptedByHomekit,
+	4:   HevInterruptedByLAN,
+	5:   HevInterruptedByCloud,
+	255: HevNone,
+}
+
+// readHev reads the current cycle, the configuration and the last cycle result of a HEV device.
+// The caller must hold the commands lock.
+func (l *Lifx) readHev(ctx context.Context) (*Hev, error) {
+	// Sends a GetHevCycle (142) Message
+	payload, err := l.request(ctx, GetMessageWithoutPayload(GetHevCycle), StateHevCycle)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the HEV cycle")
+	}
+
+	cycle := payload.(*StateHevCyclePayload)
+	hev := &Hev{
+		Duration:  time.Duration(cycle.Duration) * time.Second,
+		Remaining: time.Duration(cycle.Remaining) * time.Second,
+		LastPower: cycle.LastPower,
+	}
+
+	// Sends a GetHevCycleConfiguration (145) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetHevCycleConfiguration), StateHevCycleConfiguration)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the HEV cycle configuration")
+	}
+
+	configuration := payload.(*HevCycleConfigurationPayload)
+	hev.DefaultDuration = time.Duration(configuration.Duration) * time.Second
+	hev.Indication = configuration.Indication
+
+	// Sends a GetLastHevCycleResult (148) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetLastHevCycleResult), StateLastHevCycleResult)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the last HEV cycle result")
+	}
+
+	result, ok := hevResults[payload.(*StateLastHevCycleResultPayload).Result]
+	if !ok {
+		result = HevNone
+	}
+	hev.LastResult = result
+
+	return hev, nil
+}
+
+// SetHevCycle starts or stops a HEV cycle of the device.
+// If the duration is 0, the cycle lasts the default duration of the device.
+// It returns the statistics of the delivery.
+func (l *Lifx) SetHevCycle(enable bool, duration time.Duration) (*Delivery, error) {
+	return l.SetHevCycleContext(context.Background(), enable, duration)
+}
+
+// SetHevCycleContext is like SetHevCycle but it stops sending the message when the context is done.
+func (l *Lifx) SetHevCycleContext(ctx context.Context, enable bool, duration time.Duration) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if !l.capabilities().HasHev {
+		return nil, errors.NotSupportedf("HEV cycles on device %s", l.UUID)
+	}
+
+	if duration < 0 {
+		return nil, errors.NotValidf("HEV cycle duration %s", duration)
+	}
+
+	// Sends a SetHevCycle message to the device
+	delivery, err := l.deliver(ctx, SetHevCycleMessage(enable, uint32(duration/time.Second)))
+	if err != nil {
+		return delivery, errors.Annotate(err, "setting HEV cycle")
+	}
+
+	// Updates device. The known state is copied, so the snapshots of the device are not modified.
+	l.mu.Lock()
+	hev := Hev{}
+	if l.Hev != nil {
+		hev = *l.Hev
+	}
+
+	if enable {
+		if duration == 0 {
+			duration = hev.DefaultDuration
+		}
+		hev.Duration = duration
+		hev.Remaining = duration
+		hev.LastResult = HevBusy
+	} else if hev.Remaining > 0 {
+		hev.Remaining = 0
+		hev.LastResult = HevInterruptedByLAN
+	}
+	l.Hev = &hev
+	l.mu.Unlock()
+
+	return delivery, nil
+}
+
+// SetHevCycleConfiguration sets the default duration of the HEV cycles of the device,
+// and determines if the light briefly flashes when a cycle ends.
+// It returns the statistics of the delivery.
+func (l *Lifx) SetHevCycleConfiguration(indication bool, duration time.Duration) (*Delivery, error) {
+	return l.SetHevCycleConfigurationContext(context.Background(), indication, duration)
+}
+
+// SetHevCycleConfigurationContext is like SetHevCycleConfiguration but it stops sending the message when the context is done.
+func (l *Lifx) SetHevCycleConfigurationContext(ctx context.Context, indication bool, duration time.Duration) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if !l.capabilities().HasHev {
+		return nil, errors.NotSupportedf("HEV cycles on device %s", l.UUID)
+	}
+
+	if duration < time.Second {
+		return nil, errors.NotValidf("default HEV cycle duration %s", duration)
+	}
+
+	// Sends a SetHevCycleConfiguration message to the device
+	delivery, err := l.deliver(ctx, SetHevCycleConfigurationMessage(indication, uint32(duration/time.Second)))
+	if err != nil {
+		return delivery, errors.Annotate(err, "setting HEV cycle configuration")
+	}
+
+	// Updates device. The known state is copied, so the snapshots of the device are not modified.
+	l.mu.Lock()
+	hev := Hev{}
+	if l.Hev != nil {
+		hev = *l.Hev
+	}
+	hev.DefaultDuration = duration
+	hev.Indication = indication
+	l.Hev = &hev
+	l.mu.Unlock()
+
+	return delivery, nil
+}
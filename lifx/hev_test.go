@@ -0,0 +1,123 @@
+package lifx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/client"
+	"github.com/juju/errors"
+)
+
+func TestReadHev(t *testing.T) {
+	tests := []struct {
+		result   uint8
+		expected HevResult
+	}{
+		{0, HevSuccess},
+		{3, HevInterruptedByHomekit},
+		{255, HevNone},
+		{42, HevNone},
+	}
+
+	for _, test := range tests {
+		result := test.result
+		conn, port, _ := rawDevice(t, func(packet []byte) []byte {
+			var reply *Message
+			switch MessageType(binary.LittleEndian.Uint16(packet[32:34])) {
+			case GetHevCycle:
+				reply = NewMessageWithPayload(StateHevCycle, &StateHevCyclePayload{Duration: 7200, Remaining: 3600, LastPower: true})
+			case GetHevCycleConfiguration:
+				reply = NewMessageWithPayload(StateHevCycleConfiguration, &HevCycleConfigurationPayload{Indication: true, Duration: 5400})
+			case GetLastHevCycleResult:
+				reply = NewMessageWithPayload(StateLastHevCycleResult, &StateLastHevCycleResultPayload{Result: result})
+			default:
+				return nil
+			}
+
+			return reply.EncodeToBytes()
+		})
+
+		ip := net.IPv4(127, 0, 0, 1)
+		l := &Lifx{Address: &ip, Port: port, Protocol: client.UDP}
+		hev, err := l.readHev(context.Background())
+		conn.Close()
+		if err != nil {
+			t.Errorf("result %d: unexpected error: %v", test.result, err)
+			continue
+		}
+
+		expected := Hev{
+			Duration:        2 * time.Hour,
+			Remaining:       time.Hour,
+			LastPower:       true,
+			DefaultDuration: 90 * time.Minute,
+			Indication:      true,
+			LastResult:      test.expected,
+		}
+		if *hev != expected {
+			t.Errorf("result %d: expected %+v, got %+v", test.result, expected, *hev)
+		}
+	}
+}
+
+func TestSetHevCycle(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	if _, err := l.SetHevCycle(true, 0); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	if _, err := l.SetHevCycleConfiguration(true, time.Hour); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	l.Product = &Product{Capabilities: &Capabilities{HasHev: true}}
+	if _, err := l.SetHevCycle(true, -time.Second); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if _, err := l.SetHevCycleConfiguration(true, time.Millisecond); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if len(received) != 0 {
+		t.Fatalf("expected no message, got %d", len(received))
+	}
+
+	// A cycle started without any duration lasts the default duration.
+	if _, err := l.SetHevCycleConfiguration(true, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.SetHevCycle(true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receivedMessages(t, received)
+	if len(messages) != 2 || messages[0].Header.Type() != SetHevCycleConfiguration || messages[1].Header.Type() != SetHevCycle {
+		t.Fatalf("expected the SetHevCycleConfiguration and SetHevCycle messages, got %d messages", len(messages))
+	}
+
+	if payload, err := messages[1].Payload(); err != nil || *payload.(*SetHevCyclePayload) != (SetHevCyclePayload{Enable: true}) {
+		t.Errorf("expected a cycle of the default duration, got %+v (%v)", payload, err)
+	}
+
+	running := l.Hev
+	expected := Hev{Duration: 2 * time.Hour, Remaining: 2 * time.Hour, DefaultDuration: 2 * time.Hour, Indication: true, LastResult: HevBusy}
+	if *running != expected {
+		t.Errorf("expected %+v, got %+v", expected, *running)
+	}
+
+	// The stopped cycle is interrupted, and the previous state is not modified.
+	if _, err := l.SetHevCycle(false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Hev.Remaining != 0 || l.Hev.LastResult != HevInterruptedByLAN || *running != expected {
+		t.Errorf("expected the cycle to be interrupted, got %+v", l.Hev)
+	}
+}
@@ -0,0 +1,70 @@
+package lifx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// ID is the ID of a group or a location.
+// It is written as an hexadecimal string (ex: 0123456789abcdef0123456789abcdef).
+type ID [16]byte
+
+// NewID returns a random ID for a new group or location.
+func NewID() (ID, error) {
+	id := ID{}
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, errors.Annotate(err, "generating ID")
+	}
+
+	return id, nil
+}
+
+// ParseID parses an hexadecimal string and returns its ID equivalent.
+func ParseID(value string) (ID, error) {
+	id := ID{}
+	bytes, err := hex.DecodeString(strings.ToLower(value))
+	if err != nil || len(bytes) != len(id) {
+		return id, errors.NotValidf("ID `%s`", value)
+	}
+
+	copy(id[:], bytes)
+	return id, nil
+}
+
+// IsZero returns true if the ID has not been initialized.
+func (id ID) IsZero() bool {
+	return id == ID{}
+}
+
+// String returns the hexadecimal representation of the ID.
+func (id ID) String() string {
+	if id.IsZero() {
+		return ""
+	}
+
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *ID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = ID{}
+		return nil
+	}
+
+	parsed, err := ParseID(string(text))
+	if err != nil {
+		return err
+	}
+
+	*id = parsed
+	return nil
+}
@@ -0,0 +1,78 @@
+package lifx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestNewID(t *testing.T) {
+	first, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second || first.IsZero() {
+		t.Errorf("expected different random IDs, got %s and %s", first, second)
+	}
+}
+
+func TestParseID(t *testing.T) {
+	expected := ID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	for _, value := range []string{"0123456789abcdef0123456789abcdef", "0123456789ABCDEF0123456789ABCDEF"} {
+		id, err := ParseID(value)
+		if err != nil || id != expected {
+			t.Errorf("%s: unexpected ID %s, %v", value, id, err)
+		}
+	}
+
+	for _, value := range []string{"", "0123", "zz23456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef01"} {
+		if _, err := ParseID(value); !errors.IsNotValid(err) {
+			t.Errorf("%s: expected a not valid error, got %v", value, err)
+		}
+	}
+
+	if id := (ID{}); !id.IsZero() || id.String() != "" {
+		t.Errorf("expected an empty zero ID, got %s", id)
+	}
+}
+
+func TestIDText(t *testing.T) {
+	group := Group{ID: ID{0xab, 1}, Label: "Kitchen"}
+
+	encoded, err := json.Marshal(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := Group{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded.ID != group.ID {
+		t.Errorf("expected the ID to be decoded from %s, got %s, %v", encoded, decoded.ID, err)
+	}
+
+	encoded, err = yaml.Marshal(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded = Group{}
+	if err := yaml.Unmarshal(encoded, &decoded); err != nil || decoded.ID != group.ID {
+		t.Errorf("expected the ID to be decoded from %s, got %s, %v", encoded, decoded.ID, err)
+	}
+
+	// The zero IDs are written as empty strings, and the invalid IDs are rejected.
+	if err := json.Unmarshal([]byte(`{"id":""}`), &decoded); err != nil || !decoded.ID.IsZero() {
+		t.Errorf("expected a zero ID, got %s, %v", decoded.ID, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"id":"kitchen"}`), &decoded); err == nil {
+		t.Errorf("expected the invalid ID to be rejected")
+	}
+}
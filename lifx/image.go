@@ -0,0 +1,297 @@
+package lifx
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	// Registers the decoders of the handled image formats.
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/juju/errors"
+)
+
+// Animation is a sequence of images, displayed one after the other.
+type Animation struct {
+	// Frames contains the images of the animation.
+	Frames []image.Image
+
+	// Delays contains how long each frame is displayed.
+	Delays []time.Duration
+}
+
+const (
+	// imageKelvin is the kelvin of the colors of the drawn images.
+	imageKelvin = 3500
+
+	// defaultFrameDelay is the delay of the frames of a GIF which do not define any delay.
+	// Like the web browsers, the delays of 0 and 10 milliseconds are replaced.
+	defaultFrameDelay = 100 * time.Millisecond
+)
+
+// DecodeAnimation decodes a PNG, a JPEG or a GIF image.
+// The frames of an animated GIF are composed, so each frame is a complete image.
+// The other images are returned as an animation of a single frame.
+func DecodeAnimation(r io.Reader) (*Animation, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading image")
+	}
+
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.NewNotValid(err, "cannot decode image")
+		}
+
+		return &Animation{Frames: []image.Image{img}, Delays: []time.Duration{0}}, nil
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.NewNotValid(err, "cannot decode GIF image")
+	}
+
+	if len(g.Image) == 0 {
+		return nil, errors.NotValidf("GIF image without any frame")
+	}
+
+	// The frames of a GIF only contain the pixels which changed,
+	// so they are drawn on a canvas.
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+
+	canvas := image.NewRGBA(bounds)
+	animation := &Animation{}
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = copyRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		animation.Frames = append(animation.Frames, copyRGBA(canvas))
+
+		delay := defaultFrameDelay
+		if i < len(g.Delay) && g.Delay[i] > 1 {
+			delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+		animation.Delays = append(animation.Delays, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return animation, nil
+}
+
+// copyRGBA returns a copy of an image.
+func copyRGBA(img *image.RGBA) *image.RGBA {
+	copied := image.NewRGBA(img.Bounds())
+	copy(copied.Pix, img.Pix)
+	return copied
+}
+
+// sample returns the average color of the part of the image
+// covered by the cell (x, y) of a grid of the given size laid over the image.
+func sample(img image.Image, x, y, width, height int) HSBK {
+	bounds := img.Bounds()
+	x0 := bounds.Min.X + x*bounds.Dx()/width
+	x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+
+	y0 := bounds.Min.Y + y*bounds.Dy()/height
+	y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+
+	var r, g, b, a, count uint64
+	for py := y0; py < y1; py++ {
+		for px := x0; px < x1; px++ {
+			pr, pg, pb, pa := img.At(px, py).RGBA()
+			r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+			count++
+		}
+	}
+
+	average := color.RGBA64{
+		R: uint16(r / count),
+		G: uint16(g / count),
+		B: uint16(b / count),
+		A: uint16(a / count),
+	}
+
+	return HSBKFromColor(average, imageKelvin)
+}
+
+// renderZones returns the colors of the zones of a strip displaying the image.
+// The zones are laid from the left to the right of the image.
+func renderZones(count int, img image.Image) []HSBK {
+	colors := make([]HSBK, count)
+	for i := range colors {
+		colors[i] = sample(img, i, 0, count, 1)
+	}
+
+	return colors
+}
+
+// renderChain returns the colors of the zones of each tile of a chain displaying the image.
+// The image is laid over the tiles, as positioned by the user,
+// and the orientation of each tile is taken into account.
+func renderChain(chain []*Tile, img image.Image) [][]HSBK {
+	// The tiles are positioned by their center, in tile sizes, and the vertical axis goes up.
+	lefts := make([]int, len(chain))
+	tops := make([]int, len(chain))
+	minLeft, maxRight := math.MaxInt32, math.MinInt32
+	maxTop, minBottom := math.MinInt32, math.MaxInt32
+	for i, tile := range chain {
+		lefts[i] = int(math.Floor(float64(tile.UserX-0.5)*float64(tile.Width) + 0.5))
+		tops[i] = int(math.Floor(float64(tile.UserY+0.5)*float64(tile.Height) + 0.5))
+
+		if lefts[i] < minLeft {
+			minLeft = lefts[i]
+		}
+		if right := lefts[i] + tile.Width; right > maxRight {
+			maxRight = right
+		}
+		if tops[i] > maxTop {
+			maxTop = tops[i]
+		}
+		if bottom := tops[i] - tile.Height; bottom < minBottom {
+			minBottom = bottom
+		}
+	}
+
+	width, height := maxRight-minLeft, maxTop-minBottom
+	colors := make([][]HSBK, len(chain))
+	for i, tile := range chain {
+		colors[i] = make([]HSBK, tile.Width*tile.Height)
+		for row := 0; row < tile.Height; row++ {
+			for col := 0; col < tile.Width; col++ {
+				x, y := tile.position(col, row)
+				colors[i][row*tile.Width+col] = sample(img, lefts[i]-minLeft+x, maxTop-tops[i]+y, width, height)
+			}
+		}
+	}
+
+	return colors
+}
+
+// position returns the position in the tile, as seen by the user, of a zone of the tile.
+// The zones of the tile are given from the top left corner of the tile, when it is right side up.
+func (t *Tile) position(col, row int) (int, int) {
+	switch t.Orientation {
+	case RotatedLeft:
+		return row, t.Width - 1 - col
+	case RotatedRight:
+		return t.Height - 1 - row, col
+	case UpsideDown:
+		return t.Width - 1 - col, t.Height - 1 - row
+	default:
+		return col, row
+	}
+}
+
+// Draw displays an image on a matrix or a multizone device.
+// The image is scaled over the tiles of a matrix device, or along the zones of a multizone device.
+// It returns the statistics of the delivered messages.
+func (l *Lifx) Draw(img image.Image, duration uint32) (*Delivery, error) {
+	return l.DrawContext(context.Background(), img, duration)
+}
+
+// DrawContext is like Draw but it stops sending messages when the context is done.
+func (l *Lifx) DrawContext(ctx context.Context, img image.Image, duration uint32) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	// The context may be done while waiting for the previous commands,
+	// like when an animation is replaced.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	capabilities := l.capabilities()
+	l.mu.RLock()
+	chain := l.Chain
+	zones := len(l.Zones)
+	l.mu.RUnlock()
+
+	switch {
+	case capabilities.HasMatrix:
+		if len(chain) == 0 {
+			return nil, errors.NotFoundf("tiles of device %s", l.UUID)
+		}
+
+		delivery := &Delivery{}
+		for i, colors := range renderChain(chain, img) {
+			d, err := l.setTile(ctx, chain[i].Index, colors, duration)
+			delivery.add(d)
+			if err != nil {
+				return delivery, errors.Annotate(err, "drawing image")
+			}
+		}
+
+		return delivery, nil
+	case capabilities.HasMultiZone:
+		if zones == 0 {
+			return nil, errors.NotFoundf("zones of device %s", l.UUID)
+		}
+
+		delivery, err := l.setZones(ctx, 0, renderZones(zones, img), duration, Apply)
+		if err != nil {
+			return delivery, errors.Annotate(err, "drawing image")
+		}
+
+		return delivery, nil
+	default:
+		return nil, errors.NotSupportedf("images on device %s", l.UUID)
+	}
+}
+
+// PlayContext plays an animation on the device, in a loop, until the context is done.
+// The first frame must already be displayed: each frame is drawn when the delay of the previous one is elapsed.
+// It returns the error which stopped the animation, or the error of the context.
+func (l *Lifx) PlayContext(ctx context.Context, animation *Animation) error {
+	if len(animation.Frames) < 2 {
+		return nil
+	}
+
+	timer := time.NewTimer(animation.Delays[0])
+	defer timer.Stop()
+	for i := 1; ; i = (i + 1) % len(animation.Frames) {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// The delay of the frame starts before it is drawn, so the frame rate does not depend on the network.
+		timer.Reset(animation.Delays[i])
+
+		if _, err := l.DrawContext(ctx, animation.Frames[i], 0); err != nil {
+			return err
+		}
+	}
+}
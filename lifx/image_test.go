@@ -0,0 +1,242 @@
+package lifx
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+func TestHSBKFromColor(t *testing.T) {
+	tests := []struct {
+		color color.Color
+		hsbk  HSBK
+	}{
+		{red, HSBK{Hue: 0, Saturation: 65535, Brightness: 65535, Kelvin: 3500}},
+		{green, HSBK{Hue: 21845, Saturation: 65535, Brightness: 65535, Kelvin: 3500}},
+		{blue, HSBK{Hue: 43690, Saturation: 65535, Brightness: 65535, Kelvin: 3500}},
+		{color.RGBA{255, 255, 0, 255}, HSBK{Hue: 10922, Saturation: 65535, Brightness: 65535, Kelvin: 3500}},
+		{color.RGBA{255, 0, 255, 255}, HSBK{Hue: 54612, Saturation: 65535, Brightness: 65535, Kelvin: 3500}},
+		{white, HSBK{Brightness: 65535, Kelvin: 3500}},
+		{color.Black, HSBK{Kelvin: 3500}},
+		{color.RGBA{128, 0, 0, 128}, HSBK{Saturation: 65535, Brightness: 32896, Kelvin: 3500}},
+	}
+
+	for _, test := range tests {
+		if hsbk := HSBKFromColor(test.color, 3500); hsbk != test.hsbk {
+			t.Errorf("color %v: expected %+v, got %+v", test.color, test.hsbk, hsbk)
+		}
+	}
+}
+
+func TestDecodeAnimation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	buffer := &bytes.Buffer{}
+	if err := png.Encode(buffer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	animation, err := DecodeAnimation(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(animation.Frames) != 1 || len(animation.Delays) != 1 || animation.Delays[0] != 0 {
+		t.Fatalf("expected a single frame, got %d frames", len(animation.Frames))
+	}
+
+	if _, err := DecodeAnimation(bytes.NewBufferString("nope")); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+}
+
+func TestDecodeAnimatedGIF(t *testing.T) {
+	colors := color.Palette{red, blue, white}
+
+	// The second frame only contains the pixel which changed, and the third one is drawn over the first one.
+	first := image.NewPaletted(image.Rect(0, 0, 2, 1), colors)
+	first.Set(0, 0, red)
+	first.Set(1, 0, red)
+	second := image.NewPaletted(image.Rect(1, 0, 2, 1), colors)
+	second.Set(1, 0, blue)
+	third := image.NewPaletted(image.Rect(0, 0, 1, 1), colors)
+	third.Set(0, 0, white)
+
+	g := &gif.GIF{
+		Image:    []*image.Paletted{first, second, third},
+		Delay:    []int{0, 20, 1},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{ColorModel: colors, Width: 2, Height: 1},
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := gif.EncodeAll(buffer, g); err != nil {
+		t.Fatal(err)
+	}
+
+	animation, err := DecodeAnimation(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]color.RGBA{{red, red}, {red, blue}, {white, red}}
+	if len(animation.Frames) != len(expected) {
+		t.Fatalf("expected %d frames, got %d", len(expected), len(animation.Frames))
+	}
+
+	for i, frame := range animation.Frames {
+		for x, c := range expected[i] {
+			if got := color.RGBAModel.Convert(frame.At(x, 0)); got != c {
+				t.Errorf("frame %d, pixel %d: expected %v, got %v", i, x, c, got)
+			}
+		}
+	}
+
+	// The delays of 0 and 10 milliseconds are replaced.
+	delays := []time.Duration{defaultFrameDelay, 200 * time.Millisecond, defaultFrameDelay}
+	for i, delay := range animation.Delays {
+		if delay != delays[i] {
+			t.Errorf("frame %d: expected a delay of %v, got %v", i, delays[i], delay)
+		}
+	}
+}
+
+func TestRenderZones(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 10, 14, 11))
+	for x, c := range []color.RGBA{red, green, blue, white} {
+		img.Set(10+x, 10, c)
+	}
+
+	zones := renderZones(4, img)
+	for x, c := range []color.RGBA{red, green, blue, white} {
+		if expected := HSBKFromColor(c, imageKelvin); zones[x] != expected {
+			t.Errorf("zone %d: expected %+v, got %+v", x, expected, zones[x])
+		}
+	}
+
+	// A zone covering several pixels has their average color.
+	zones = renderZones(2, img)
+	if expected := HSBKFromColor(color.RGBA64{32767, 32767, 0, 65535}, imageKelvin); zones[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, zones[0])
+	}
+
+	// A pixel may cover several zones.
+	for i, zone := range renderZones(8, img) {
+		if expected := HSBKFromColor(img.At(10+i/2, 10), imageKelvin); zone != expected {
+			t.Errorf("zone %d: expected %+v, got %+v", i, expected, zone)
+		}
+	}
+}
+
+func TestTilePosition(t *testing.T) {
+	tests := []struct {
+		orientation Orientation
+		x, y        int
+	}{
+		{RightSideUp, 0, 1},
+		{FaceUp, 0, 1},
+		{RotatedLeft, 1, 1},
+		{RotatedRight, 0, 0},
+		{UpsideDown, 1, 0},
+	}
+
+	// The zone of the first column of the second row.
+	for _, test := range tests {
+		tile := &Tile{Width: 2, Height: 2, Orientation: test.orientation}
+		if x, y := tile.position(0, 1); x != test.x || y != test.y {
+			t.Errorf("orientation %s: expected (%d, %d), got (%d, %d)", test.orientation, test.x, test.y, x, y)
+		}
+	}
+}
+
+func TestRenderChain(t *testing.T) {
+	// The image is laid over two tiles side by side: the left one is red, and the right one is blue.
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, red)
+			if x >= 2 {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+
+	chain := []*Tile{
+		{Index: 0, UserX: 1, Width: 2, Height: 2, Orientation: RightSideUp},
+		{Index: 1, UserX: 0, Width: 2, Height: 2, Orientation: RightSideUp},
+	}
+
+	colors := renderChain(chain, img)
+	for i, c := range []color.RGBA{blue, red} {
+		for zone, hsbk := range colors[i] {
+			if expected := HSBKFromColor(c, imageKelvin); hsbk != expected {
+				t.Errorf("tile %d, zone %d: expected %+v, got %+v", i, zone, expected, hsbk)
+			}
+		}
+	}
+
+	// The zones of a rotated tile are read where they are seen by the user.
+	quarters := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	quarters.Set(0, 0, red)
+	quarters.Set(1, 0, green)
+	quarters.Set(0, 1, blue)
+	quarters.Set(1, 1, white)
+
+	tile := &Tile{Width: 2, Height: 2, Orientation: RotatedLeft}
+	colors = renderChain([]*Tile{tile}, quarters)
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 2; col++ {
+			x, y := tile.position(col, row)
+			if expected := HSBKFromColor(quarters.At(x, y), imageKelvin); colors[0][row*2+col] != expected {
+				t.Errorf("zone (%d, %d): expected %+v, got %+v", col, row, expected, colors[0][row*2+col])
+			}
+		}
+	}
+}
+
+func TestDrawContext(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	// The device must have zones.
+	if _, err := (&Lifx{}).Draw(img, 0); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Lifx{}).DrawContext(ctx, img, 0); err != context.Canceled {
+		t.Errorf("expected the error of the context, got %v", err)
+	}
+}
+
+func TestPlayContext(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	// A single frame is already displayed.
+	if err := (&Lifx{}).PlayContext(context.Background(), &Animation{Frames: []image.Image{img}, Delays: []time.Duration{0}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	animation := &Animation{Frames: []image.Image{img, img}, Delays: []time.Duration{time.Hour, time.Hour}}
+	if err := (&Lifx{}).PlayContext(ctx, animation); err != context.DeadlineExceeded {
+		t.Errorf("expected the error of the context, got %v", err)
+	}
+}
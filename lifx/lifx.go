@@ -1,14 +1,15 @@
 package lifx
 
 import (
-	"encoding/binary"
+	"context"
 	"io/ioutil"
 	"net"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/fberrez/horus/client"
 	"github.com/fberrez/horus/client/udp"
-	"github.com/fberrez/horus/tools"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
@@ -16,36 +17,74 @@ import (
 
 type (
 	// Lifx contains all informations of a LIFX Device.
+	// Only its identifiers and its network settings are saved in the config file.
+	// It is safe for concurrent use: the commands sent to a device are performed one after
+	// the other, and its fields must be read from a Snapshot.
 	Lifx struct {
 		// UUID is the UUID of the device.
 		UUID string `yaml:"uuid" json:"uuid"`
 
+		// Serial is the serial number (MAC address) of the device.
+		// It is a stable identifier, even if the address of the device changes.
+		Serial Serial `yaml:"serial" json:"serial"`
+
 		// Label is the label of the device;
 		Label string `yaml:"label" json:"label"`
 
 		// Connected is the connection status of the device.
-		Connected bool `yaml:"connected" json:"connected"`
+		Connected bool `yaml:"-" json:"connected"`
+
+		// LastSeen is the last time the device replied to a message.
+		LastSeen time.Time `yaml:"-" json:"lastSeen"`
+
+		// LastError is the last error which occured while communicating with the device.
+		LastError string `yaml:"-" json:"lastError,omitempty"`
+
+		// failures is the number of consecutive failed communications with the device.
+		failures int
+
+		// retryAt is the time from which an unreachable device can be contacted again.
+		retryAt time.Time
+
+		// Latency contains the statistics of the last echo probes sent to the device.
+		Latency *Latency `yaml:"-" json:"latency,omitempty"`
+
+		// probes contains the last echo probes sent to the device.
+		probes []probe
 
 		// Power is the power status of the device.
-		Power Power `yaml:"power" json:"power"`
+		Power Power `yaml:"-" json:"power"`
 
 		// HSBK is the HSBK value of the device.
-		HSBK *HSBK `yaml:"hsbk" json:"hsbk"`
+		HSBK *HSBK `yaml:"-" json:"hsbk"`
+
+		// Zones contains the colors of the zones of a multizone device.
+		Zones []HSBK `yaml:"-" json:"zones,omitempty"`
+
+		// Chain contains the tiles of a matrix device.
+		Chain []*Tile `yaml:"-" json:"chain,omitempty"`
 
-		// Infrared is the infrared value of the device.
-		Infrared float32 `yaml:"infrared" json:"infrared"`
+		// Hev contains the state of the HEV light of a HEV device.
+		Hev *Hev `yaml:"-" json:"hev,omitempty"`
+
+		// FirmwareEffect is the last known effect run by the firmware of a multizone or a matrix device.
+		FirmwareEffect *FirmwareEffect `yaml:"-" json:"firmwareEffect,omitempty"`
+
+		// Infrared is the brightness of the infrared channel of an infrared device.
+		// Range from 0 to 65535.
+		Infrared uint16 `yaml:"-" json:"infrared"`
 
 		// Group contains informations about the group of the device
-		Group *Group `yaml:"group" json:"group"`
+		Group *Group `yaml:"-" json:"group"`
 
 		// Product contains informations about the product
-		Product *Product `yaml:"product" json:"product"`
+		Product *Product `yaml:"-" json:"product"`
 
 		// Info contains informations about the time stats of the device.
-		Info *Info `yaml:"info" json:"info"`
+		Info *Info `yaml:"-" json:"info"`
 
 		// Location contains informations about the location of the device.
-		Location *Location `yaml:"location" json:"location"`
+		Location *Location `yaml:"-" json:"location"`
 
 		// Address is IPV4 address of the device.
 		Address *net.IP `yaml:"address" json:"address"`
@@ -58,6 +97,15 @@ type (
 
 		// client is the network client used to send packets to the device.
 		client client.Client
+
+		// mu guards the fields of the device, which are read by the API
+		// while the device is updated.
+		mu sync.RWMutex
+
+		// commands serializes the commands sent to the device,
+		// so a command is never interleaved with another one.
+		// It is always acquired before mu.
+		commands sync.Mutex
 	}
 
 	// Capabilities contains the capabilities informations of a product.
@@ -70,6 +118,16 @@ type (
 
 		// HasMultiZone determines if the product has the 'multizone' capability.
 		HasMultiZone bool `yaml:"hasMultiZone" json:"hasMultiZone"`
+
+		// HasExtendedMultiZone determines if the product handles the extended multizone messages,
+		// which set or get up to 82 zones at once. It requires the firmware 2.77 or later.
+		HasExtendedMultiZone bool `yaml:"hasExtendedMultiZone" json:"hasExtendedMultiZone"`
+
+		// HasMatrix determines if the product is made of a chain of tiles, each one being a matrix of zones.
+		HasMatrix bool `yaml:"hasMatrix" json:"hasMatrix"`
+
+		// HasHev determines if the product has a HEV (High Energy Visible) light, used to disinfect surfaces.
+		HasHev bool `yaml:"hasHev" json:"hasHev"`
 	}
 
 	// Product contains all informations about the product.
@@ -93,31 +151,39 @@ type (
 	// Group contains all informations about the group of a product.
 	Group struct {
 		// ID is the ID of the group.
-		ID [16]byte `yaml:"id" json:"id"`
+		ID ID `yaml:"id" json:"id"`
 
 		// Label is the name of the group.
 		Label string `yaml:"label" json:"label"`
+
+		// UpdatedAt is the last update time of the group.
+		// The label of the most recently updated group is the label of the group.
+		UpdatedAt time.Time `yaml:"updatedAt" json:"updatedAt"`
 	}
 
 	// Location contains all informations about the location of a product.
 	Location struct {
 		// ID is the ID of the location
-		ID [16]byte `yaml:"id" json:"id"`
+		ID ID `yaml:"id" json:"id"`
 
 		// Label is the name of the location
 		Label string `yaml:"name" json:"name"`
+
+		// UpdatedAt is the last update time of the location.
+		// The label of the most recently updated location is the label of the location.
+		UpdatedAt time.Time `yaml:"updatedAt" json:"updatedAt"`
 	}
 
 	// Info contains all informations about the time stats of a product.
 	Info struct {
-		// Time is the current time in UNIX format
-		Time uint16 `yaml:"time" json:"time"`
+		// Time is the current time in nanoseconds since epoch.
+		Time uint64 `yaml:"time" json:"time"`
 
-		// UpTime is the uptime of the product.
-		UpTime uint16 `yaml:"upTime" json:"upTime"`
+		// UpTime is the uptime of the product in nanoseconds.
+		UpTime uint64 `yaml:"upTime" json:"upTime"`
 
-		// DownTime is the downtime of the product.
-		DownTime uint16 `yaml:"downTime" json:"downTime"`
+		// DownTime is the downtime of the product in nanoseconds.
+		DownTime uint64 `yaml:"downTime" json:"downTime"`
 	}
 
 	// State contains all returned informations by a Get (101) message.
@@ -130,6 +196,10 @@ type (
 
 		// Label is the name of the light
 		Label string
+
+		// Infrared is the brightness of the infrared channel of an infrared light.
+		// If it is nil, the infrared channel is not changed.
+		Infrared *uint16
 	}
 
 	// HSBK is used to represent the color and color temperature of a light.
@@ -154,6 +224,16 @@ type (
 		Kelvin uint16 `yaml:"kelvin" json:"kelvin"`
 	}
 
+	// Delivery contains the statistics of messages delivered with an acknowledgement.
+	Delivery struct {
+		// Attempts is the number of sent packets.
+		Attempts int `json:"attempts"`
+
+		// RTT is the longest round trip time of the acknowledged packets, in nanoseconds.
+		// The packets acknowledged after being sent again are not measured.
+		RTT time.Duration `json:"rtt"`
+	}
+
 	// Power is personalized type.
 	// It contains only two possible value: "on" and "off".
 	Power string
@@ -176,6 +256,14 @@ var (
 		Kelvin:     65535,
 	}
 
+	// DefaultBackoff defines how the messages delivered with an acknowledgement are retried.
+	DefaultBackoff = &client.Backoff{
+		Initial:  time.Millisecond * 200,
+		Max:      time.Second,
+		Factor:   2,
+		Deadline: time.Second * 3,
+	}
+
 	productsList map[uint32]*Product
 )
 
@@ -185,374 +273,735 @@ const (
 	// PowerOff is the power level of a turned-off light
 	PowerOff Power = "off"
 
+	// missingRequests is the number of times the missing replies of a message
+	// expecting several replies are requested again.
+	missingRequests = 3
+
 	productsFile          = "PRODUCTS_FILE"
 	defaultConfigFilePath = "./lifx/products.yaml"
 )
 
 // Send sends a message to a lifx device using the defined protocol.
 func (l *Lifx) Send(message *Message) ([]byte, error) {
+	return l.SendContext(context.Background(), message)
+}
+
+// SendContext is like Send but it stops waiting for the reply when the context is done.
+func (l *Lifx) SendContext(ctx context.Context, message *Message) ([]byte, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	c, request, err := l.prepare(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendContext(ctx, request.Dest, request.Port, request.Packet)
+}
+
+// Request sends a message to the device and decodes its reply,
+// which must be of the expected message type.
+// The message is sent again, following DefaultBackoff, until it is answered.
+// It returns the decoded payload of the reply.
+func (l *Lifx) Request(message *Message, expected MessageType) (Payload, error) {
+	return l.RequestContext(context.Background(), message, expected)
+}
+
+// RequestContext is like Request but it stops waiting for the reply when the context is done.
+func (l *Lifx) RequestContext(ctx context.Context, message *Message, expected MessageType) (Payload, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.request(ctx, message, expected)
+}
+
+// request is the implementation of RequestContext.
+// The caller must hold the commands lock.
+func (l *Lifx) request(ctx context.Context, message *Message, expected MessageType) (Payload, error) {
+	payloads, err := l.requestAll(ctx, message, 1, expected)
+	if err != nil {
+		return nil, err
+	}
+
+	return payloads[0], nil
+}
+
+// requestAll sends a message to the device and decodes the given number of replies,
+// which must be of one of the expected message types.
+// The caller must hold the commands lock.
+func (l *Lifx) requestAll(ctx context.Context, message *Message, replies int, expected ...MessageType) ([]Payload, error) {
+	c, request, err := l.prepare(message)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msgType := range expected {
+		request.Expect = append(request.Expect, uint16(msgType))
+	}
+	request.Replies = replies
+	request.Retry = DefaultBackoff
+	response, err := c.DoContext(ctx, request)
+	l.report(ctx, err)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make([]Payload, len(response.Packets))
+	for i, packet := range response.Packets {
+		reply, err := DecodeToMessage(packet)
+		if err != nil {
+			return nil, errors.Annotatef(err, "decoding reply of device %s", l.UUID)
+		}
+
+		payloads[i], err = reply.Payload()
+		if err != nil {
+			return nil, errors.Annotatef(err, "decoding reply of device %s", l.UUID)
+		}
+
+		// The serial of the device is learnt from the target of its StateService (3) reply,
+		// like the discovered devices. Until it is known, the messages are sent to all the devices
+		// listening on the address, so the target of the other replies is not trusted.
+		if reply.Header.Type() == StateService {
+			l.mu.Lock()
+			if l.Serial.IsZero() {
+				l.Serial = SerialFromTarget(reply.Header.Target())
+			}
+			l.mu.Unlock()
+		}
+	}
+
+	return payloads, nil
+}
+
+// missingRange returns the range of the replies which have not been received yet,
+// from the first missing one to the last missing one, excluded.
+// The range is empty if every reply has been received.
+func missingRange(received []bool) (int, int) {
+	start, end := len(received), 0
+	for i, ok := range received {
+		if !ok {
+			if i < start {
+				start = i
+			}
+			end = i + 1
+		}
+	}
+
+	if end == 0 {
+		return 0, 0
+	}
+
+	return start, end
+}
+
+// Deliver sends a message to the device and waits for its Acknowledgement (45).
+// The message is sent again, following DefaultBackoff, until it is acknowledged.
+// It returns the statistics of the delivery.
+func (l *Lifx) Deliver(message *Message) (*Delivery, error) {
+	return l.DeliverContext(context.Background(), message)
+}
+
+// DeliverContext is like Deliver but it stops sending the message when the context is done.
+func (l *Lifx) DeliverContext(ctx context.Context, message *Message) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.deliver(ctx, message)
+}
+
+// deliver is the implementation of DeliverContext.
+// The caller must hold the commands lock.
+func (l *Lifx) deliver(ctx context.Context, message *Message) (*Delivery, error) {
+	message.Header.IsAckRequired(true).IsResRequired(false)
+	c, request, err := l.prepare(message)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Expect = []uint16{uint16(Acknowledgement)}
+	request.Retry = DefaultBackoff
+	response, err := c.DoContext(ctx, request)
+
+	delivery := &Delivery{}
+	if response != nil {
+		delivery.Attempts = response.Attempts
+		delivery.RTT = response.RTT
+	}
+
+	l.report(ctx, err)
+	return delivery, err
+}
+
+// add adds the statistics of another delivery.
+func (d *Delivery) add(other *Delivery) {
+	if other == nil {
+		return
+	}
+
+	d.Attempts += other.Attempts
+	if other.RTT > d.RTT {
+		d.RTT = other.RTT
+	}
+}
+
+// prepare verifies the network settings of the device, initializes its client
+// and addresses the message to the device.
+// It returns the client of the device and a request containing the encoded message.
+func (l *Lifx) prepare(message *Message) (client.Client, *client.Request, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	// Defines the client, defined by its protocol.
+	// Every device shares the same client.
 	if l.client == nil {
 		switch l.Protocol {
 		case client.UDP:
-			l.client = &udp.UDP{}
+			l.client = udp.Shared()
 		default:
-			return nil, errors.NotFoundf("protocol %s not found", l.Protocol)
+			return nil, nil, errors.NotFoundf("protocol %s not found", l.Protocol)
 		}
 	}
 
-	if len(l.Address.String()) == 0 {
-		return nil, errors.NewNotValid(nil, "address of a lifx has not been initialized")
+	if l.Address == nil || len(l.Address.String()) == 0 {
+		return nil, nil, errors.NewNotValid(nil, "address of a lifx has not been initialized")
 	}
 
 	if len(l.Port) == 0 {
-		return nil, errors.NewNotValid(nil, "port of a lifx has not been initialized")
+		return nil, nil, errors.NewNotValid(nil, "port of a lifx has not been initialized")
 	}
 
 	if len(message.EncodeToBytes()) == 0 {
-		return nil, errors.NewNotValid(nil, "message has not been initialized")
+		return nil, nil, errors.NewNotValid(nil, "message has not been initialized")
+	}
+
+	// If the serial of the device is known, the message is addressed to it.
+	// Else, the message is sent to all devices listening on the address.
+	if !l.Serial.IsZero() {
+		message.Header.SetTarget(l.Serial.Target()).SetFrame(NTAFrame)
+	} else {
+		message.Header.SetTarget(DefaultTarget).SetFrame(TAFrame)
 	}
 
-	return l.client.Send(l.Address, l.Port, message.EncodeToBytes())
+	return l.client, &client.Request{
+		Dest:   l.Address,
+		Port:   l.Port,
+		Packet: message.EncodeToBytes(),
+	}, nil
+}
+
+// identify learns the serial of the device from its reply to a GetService (2) message,
+// if the serial is unknown.
+// The caller must hold the commands lock.
+func (l *Lifx) identify(ctx context.Context) error {
+	l.mu.RLock()
+	known := !l.Serial.IsZero()
+	l.mu.RUnlock()
+
+	if known {
+		return nil
+	}
+
+	_, err := l.request(ctx, GetMessageWithoutPayload(GetService), StateService)
+	return err
+}
+
+// Relocate looks for the device on the given domain by its serial.
+// If the device has a new address or port, it updates them.
+// It returns true if the device has been relocated.
+func (l *Lifx) Relocate(domain string, window time.Duration) (bool, error) {
+	return l.RelocateContext(context.Background(), domain, window)
+}
+
+// RelocateContext is like Relocate but it stops looking for the device when the context is done.
+func (l *Lifx) RelocateContext(ctx context.Context, domain string, window time.Duration) (bool, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	l.mu.RLock()
+	serial := l.Serial
+	l.mu.RUnlock()
+
+	located, err := LocateContext(ctx, domain, serial, window)
+	if err != nil {
+		return false, errors.Annotate(err, "relocating device")
+	}
+
+	return l.move(located.Address, located.Port), nil
+}
+
+// move sets the address and the port of the device.
+// It returns true if they have changed.
+func (l *Lifx) move(address *net.IP, port string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Address != nil && l.Address.Equal(*address) && l.Port == port {
+		return false
+	}
+
+	l.Address = address
+	l.Port = port
+	return true
 }
 
 // Update update a Lifx device by sending multiple messages to that device.
 // It parses all informations returned by this device and
 // adds it in the lifx device struct.
 func (l *Lifx) Update() error {
-	// If an error occured, we cannot be sure that the targeted device is connected.
-	// Therefore, its connected status is set to false.
-	l.Connected = false
-	// Sends a Get (101) Message
-	bytes, err := l.Send(GetMessageWithoutPayload(Get))
-	if err != nil {
-		return errors.Annotate(err, "an error occured while sending a Get (101) Message on updating")
+	return l.UpdateContext(context.Background())
+}
+
+// UpdateContext is like Update but it stops updating the device when the context is done.
+func (l *Lifx) UpdateContext(ctx context.Context) error {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	// Sends a GetService (2) Message if the serial of the device is unknown
+	if err := l.identify(ctx); err != nil {
+		return errors.Annotate(err, "an error occured while sending a GetService (2) Message on updating")
 	}
 
-	// Decodes the state
-	state, err := DecodeToState(bytes)
+	// Sends a Get (101) Message
+	payload, err := l.request(ctx, GetMessageWithoutPayload(Get), StateLight)
 	if err != nil {
-		return err
+		return errors.Annotate(err, "an error occured while sending a Get (101) Message on updating")
 	}
 
 	// Defines the updated state value
-	l.HSBK = state.HSBK
-	l.Label = state.Label
-	l.Power = state.Power
+	state := payload.(*StateLightPayload).State()
 
-	// Sends a Get (53) Message
-	bytes, err = l.Send(GetMessageWithoutPayload(GetGroup))
+	// Sends a GetGroup (51) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetGroup), StateGroup)
 	if err != nil {
-		return errors.Annotate(err, "an error occured while sending a GetGroup (53) Message on updating")
-	}
-
-	// Decodes the state
-	group, err := DecodeToGroup(bytes)
-	if err != nil {
-		return err
+		return errors.Annotate(err, "an error occured while sending a GetGroup (51) Message on updating")
 	}
 
 	// Defines the updated group value
-	l.Group = group
+	group := payload.(*GroupPayload).Group()
 
 	// Sends a GetInfo (34) Message
-	bytes, err = l.Send(GetMessageWithoutPayload(GetInfo))
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetInfo), StateInfo)
 	if err != nil {
 		return errors.Annotate(err, "an error occured while sending a GetInfo (34) Message on updating")
 	}
 
-	// Decodes the state
-	info, err := DecodeToInfo(bytes)
-	if err != nil {
-		return err
-	}
-
 	// Defines the updated info value
-	l.Info = info
+	info := payload.(*StateInfoPayload).Info()
 
 	// Sends a GetLocation (48) Message
-	bytes, err = l.Send(GetMessageWithoutPayload(GetLocation))
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetLocation), StateLocation)
 	if err != nil {
 		return errors.Annotate(err, "an error occured while sending a GetLocation (48) Message on updating")
 	}
 
-	// Decodes the state
-	location, err := DecodeToLocation(bytes)
-	if err != nil {
-		return err
-	}
-
 	// Defines the updated location value
-	l.Location = location
+	location := payload.(*LocationPayload).Location()
 
-	// Sends a GetLocation (48) Message
-	bytes, err = l.Send(GetMessageWithoutPayload(GetVersion))
+	// Sends a GetVersion (32) Message
+	payload, err = l.request(ctx, GetMessageWithoutPayload(GetVersion), StateVersion)
 	if err != nil {
 		return errors.Annotate(err, "an error occured while sending a GetVersion (32) Message on updating")
 	}
 
-	// Decodes the state
-	product, err := DecodeToProduct(bytes)
-	if err != nil {
-		return err
+	// Defines the updated product value
+	product := productsList[payload.(*StateVersionPayload).Product]
+
+	// Reads the infrared channel of an infrared device
+	infrared := uint16(0)
+	if product != nil && product.Capabilities != nil && product.Capabilities.HasIR {
+		// Sends a GetInfrared (120) Message
+		payload, err = l.request(ctx, GetMessageWithoutPayload(GetInfrared), StateInfrared)
+		if err != nil {
+			return errors.Annotate(err, "an error occured while sending a GetInfrared (120) Message on updating")
+		}
+
+		infrared = payload.(*InfraredPayload).Brightness
 	}
 
-	// Defines the updated location value
+	// Reads the HEV cycles of a HEV device
+	var hev *Hev
+	if product != nil && product.Capabilities != nil && product.Capabilities.HasHev {
+		hev, err = l.readHev(ctx)
+		if err != nil {
+			return errors.Annotate(err, "an error occured while reading the HEV cycles on updating")
+		}
+	}
+
+	// Reads the tiles of a matrix device
+	var chain []*Tile
+	if product != nil && product.Capabilities != nil && product.Capabilities.HasMatrix {
+		chain, err = l.readChain(ctx)
+		if err != nil {
+			return errors.Annotate(err, "an error occured while reading the tiles on updating")
+		}
+	}
+
+	// Reads the zones of a multizone device
+	var zones []HSBK
+	if product != nil && product.Capabilities != nil && product.Capabilities.HasMultiZone {
+		zones, err = l.readZones(ctx, product.Capabilities.HasExtendedMultiZone)
+		if err != nil {
+			return errors.Annotate(err, "an error occured while reading the zones on updating")
+		}
+	}
+
+	// The state is replaced at once, so the readers never see a partially updated device.
+	l.mu.Lock()
+	l.HSBK = state.HSBK
+	l.Label = state.Label
+	l.Power = state.Power
+	l.Group = group
+	l.Info = info
+	l.Location = location
 	l.Product = product
+	l.Infrared = infrared
+	l.Hev = hev
+	l.Zones = zones
+	l.Chain = chain
+	l.mu.Unlock()
 
-	l.Connected = true
 	return nil
 }
 
 // SetState send a new state to the lifx device.
-func (l *Lifx) SetState(state *State, duration uint32) error {
+// It returns the statistics of the delivered messages.
+func (l *Lifx) SetState(state *State, duration uint32) (*Delivery, error) {
+	return l.SetStateContext(context.Background(), state, duration)
+}
+
+// SetStateContext is like SetState but it stops sending messages when the context is done.
+func (l *Lifx) SetStateContext(ctx context.Context, state *State, duration uint32) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	// The infrared is verified before sending any message, so the state is not partially set.
+	if state.Infrared != nil && !l.capabilities().HasIR {
+		return nil, errors.NotSupportedf("infrared on device %s", l.UUID)
+	}
+
+	delivery := &Delivery{}
 	// If the label is not nil, it sends a setlabel message to the device.
 	if len(state.Label) > 0 {
-		err := l.SetLabel(state.Label)
+		d, err := l.setLabel(ctx, state.Label)
+		delivery.add(d)
 		if err != nil {
-			return errors.Annotate(err, "setting state")
+			return delivery, errors.Annotate(err, "setting state")
 		}
 	}
 
 	// If the power is not nil, it sends a setpowerdevice message to the device.
 	if len(state.Power) > 0 {
-		err := l.SetPower(state.Power)
+		d, err := l.setPower(ctx, state.Power, duration)
+		delivery.add(d)
 		if err != nil {
-			return errors.Annotate(err, "setting state")
+			return delivery, errors.Annotate(err, "setting state")
 		}
 	}
 
 	// If the hsbk is not nil, it sends a setcolor message to the device.
 	if state.HSBK != nil {
-		err := l.SetHSBK(state.HSBK, duration)
+		d, err := l.setHSBK(ctx, state.HSBK, duration)
+		delivery.add(d)
 		if err != nil {
-			return errors.Annotate(err, "setting state")
+			return delivery, errors.Annotate(err, "setting state")
 		}
 	}
 
-	return nil
+	// If the infrared is not nil, it sends a setinfrared message to the device.
+	if state.Infrared != nil {
+		d, err := l.setInfrared(ctx, *state.Infrared)
+		delivery.add(d)
+		if err != nil {
+			return delivery, errors.Annotate(err, "setting state")
+		}
+	}
+
+	return delivery, nil
 }
 
 // SetLabel sends a SetLabel message to the device.
-func (l *Lifx) SetLabel(label string) error {
+func (l *Lifx) SetLabel(label string) (*Delivery, error) {
+	return l.SetLabelContext(context.Background(), label)
+}
+
+// SetLabelContext is like SetLabel but it stops sending the message when the context is done.
+func (l *Lifx) SetLabelContext(ctx context.Context, label string) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.setLabel(ctx, label)
+}
+
+// setLabel is the implementation of SetLabelContext.
+// The caller must hold the commands lock.
+func (l *Lifx) setLabel(ctx context.Context, label string) (*Delivery, error) {
 	// Sends a SetLabel message to the device
-	_, err := l.Send(SetLabelMessage(label))
+	delivery, err := l.deliver(ctx, SetLabelMessage(label))
 	if err != nil {
-		return errors.Annotate(err, "setting new label")
+		return delivery, errors.Annotate(err, "setting new label")
 	}
 
 	// Updates device
+	l.mu.Lock()
 	l.Label = label
+	l.mu.Unlock()
 
-	return nil
+	return delivery, nil
 }
 
-// SetPower send a SetPowerDevice message to the device.
-func (l *Lifx) SetPower(power Power) error {
-	// Sends a SetPower message to the device
-	_, err := l.Send(SetPowerDeviceMessage(power))
+// SetPower sends a SetPowerLight message to the device.
+// The light fades to the power status during the given duration in milliseconds,
+// and it keeps its color for the next power on.
+func (l *Lifx) SetPower(power Power, duration uint32) (*Delivery, error) {
+	return l.SetPowerContext(context.Background(), power, duration)
+}
+
+// SetPowerContext is like SetPower but it stops sending the message when the context is done.
+func (l *Lifx) SetPowerContext(ctx context.Context, power Power, duration uint32) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.setPower(ctx, power, duration)
+}
+
+// setPower is the implementation of SetPowerContext.
+// The caller must hold the commands lock.
+func (l *Lifx) setPower(ctx context.Context, power Power, duration uint32) (*Delivery, error) {
+	// Sends a SetPowerLight message to the device
+	delivery, err := l.deliver(ctx, SetPowerLightMessage(power, duration))
 	if err != nil {
-		return errors.Annotate(err, "setting power")
+		return delivery, errors.Annotate(err, "setting power")
 	}
 
 	// Updates device
+	l.mu.Lock()
 	l.Power = power
+	l.mu.Unlock()
 
-	return nil
+	return delivery, nil
+}
+
+// readPower reads the power status of the light with a GetPowerLight (116) message.
+// The caller must hold the commands lock.
+func (l *Lifx) readPower(ctx context.Context) (Power, error) {
+	payload, err := l.request(ctx, GetMessageWithoutPayload(GetPowerLight), StatePowerLight)
+	if err != nil {
+		return "", errors.Annotate(err, "reading power")
+	}
+
+	power := powerFromLevel(payload.(*PowerPayload).Level)
+	l.mu.Lock()
+	l.Power = power
+	l.mu.Unlock()
+
+	return power, nil
 }
 
 // SetHSBK sends a SetColor message with the given hsbk and duration.
 // If it is successfull, it updates the device with the new state.
-func (l *Lifx) SetHSBK(hsbk *HSBK, duration uint32) error {
+func (l *Lifx) SetHSBK(hsbk *HSBK, duration uint32) (*Delivery, error) {
+	return l.SetHSBKContext(context.Background(), hsbk, duration)
+}
+
+// SetHSBKContext is like SetHSBK but it stops sending the message when the context is done.
+func (l *Lifx) SetHSBKContext(ctx context.Context, hsbk *HSBK, duration uint32) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.setHSBK(ctx, hsbk, duration)
+}
+
+// setHSBK is the implementation of SetHSBKContext.
+// The caller must hold the commands lock.
+func (l *Lifx) setHSBK(ctx context.Context, hsbk *HSBK, duration uint32) (*Delivery, error) {
 	// Sends a SetColor message to the device
-	bytes, err := l.Send(SetColorMessage(hsbk, duration))
+	delivery, err := l.deliver(ctx, SetColorMessage(hsbk, duration))
 	if err != nil {
-		return errors.Annotate(err, "setting hsbk")
+		return delivery, errors.Annotate(err, "setting hsbk")
 	}
 
-	// Decodes state
-	state, err := DecodeToState(bytes)
+	// Updates device with the acknowledged value
+	color := *hsbk
+	l.mu.Lock()
+	l.HSBK = &color
+	l.mu.Unlock()
+
+	return delivery, nil
+}
+
+// SetInfrared sends a SetInfrared message with the given brightness of the infrared channel.
+// It returns an error if the device is not an infrared device.
+func (l *Lifx) SetInfrared(brightness uint16) (*Delivery, error) {
+	return l.SetInfraredContext(context.Background(), brightness)
+}
+
+// SetInfraredContext is like SetInfrared but it stops sending the message when the context is done.
+func (l *Lifx) SetInfraredContext(ctx context.Context, brightness uint16) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.setInfrared(ctx, brightness)
+}
+
+// setInfrared is the implementation of SetInfraredContext.
+// The caller must hold the commands lock.
+func (l *Lifx) setInfrared(ctx context.Context, brightness uint16) (*Delivery, error) {
+	if !l.capabilities().HasIR {
+		return nil, errors.NotSupportedf("infrared on device %s", l.UUID)
+	}
+
+	// Sends a SetInfrared message to the device
+	delivery, err := l.deliver(ctx, SetInfraredMessage(brightness))
 	if err != nil {
-		return errors.Annotate(err, "setting hsbk")
+		return delivery, errors.Annotate(err, "setting infrared")
 	}
 
-	// Updates device with returned values
-	l.HSBK = state.HSBK
-	l.Label = state.Label
-	l.Power = state.Power
+	// Updates device
+	l.mu.Lock()
+	l.Infrared = brightness
+	l.mu.Unlock()
 
-	return nil
+	return delivery, nil
 }
 
-// Toggle toggles a light HSBK. It is based on the power level of the device.
-// If the power is "on" and the brightness > 0, the HSBK is set to off.
-// Else, the HSBK is set to on.
-// Finally the packet is sent to the targeted device.
-func (l *Lifx) Toggle(brightness uint16, duration uint32) error {
-	var bytes []byte
-	var err error
+// Toggle toggles the power of a light, fading during the given duration in milliseconds.
+// The power status is read from the device before it is toggled.
+// The light keeps its color, unless it is turned on while its brightness is 0:
+// its brightness is then set to the given one.
+func (l *Lifx) Toggle(brightness uint16, duration uint32) (*Delivery, error) {
+	return l.ToggleContext(context.Background(), brightness, duration)
+}
+
+// ToggleContext is like Toggle but it stops sending the message when the context is done.
+func (l *Lifx) ToggleContext(ctx context.Context, brightness uint16, duration uint32) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	// The power is read while holding the commands lock,
+	// so it cannot be changed by another command before the light is toggled.
+	power, err := l.readPower(ctx)
+	if err != nil {
+		return nil, errors.Annotate(err, "toggling a device")
+	}
+
+	l.mu.RLock()
+	current := l.HSBK
+	l.mu.RUnlock()
+
 	// If the power is on and brightness level greater than 0,
 	// it turns off the light.
-	if l.Power == PowerOn && l.HSBK.Brightness > 0 {
-		bytes, err = l.Send(SetColorMessage(Off, duration))
+	if power == PowerOn && current != nil && current.Brightness > 0 {
+		delivery, err := l.setPower(ctx, PowerOff, duration)
 		if err != nil {
-			return errors.Annotate(err, "turning off a device")
+			return delivery, errors.Annotate(err, "turning off a device")
 		}
-	} else {
-		// Defines a `on` HSBK with the given brightness.
+
+		return delivery, nil
+	}
+
+	// A light without any brightness would stay dark once turned on,
+	// so it gets the given brightness before, keeping its color if it is known.
+	delivery := &Delivery{}
+	if current == nil || current.Brightness == 0 {
 		on := &HSBK{
 			Hue:        On.Hue,
 			Saturation: On.Saturation,
 			Brightness: brightness,
 			Kelvin:     On.Kelvin,
 		}
+		if current != nil {
+			on.Hue, on.Saturation, on.Kelvin = current.Hue, current.Saturation, current.Kelvin
+		}
 
-		bytes, err = l.Send(SetColorMessage(on, duration))
+		// The light fades in with the power, so the color is set at once if it is off.
+		colorDuration := duration
+		if power == PowerOff {
+			colorDuration = 0
+		}
+
+		d, err := l.setHSBK(ctx, on, colorDuration)
+		delivery.add(d)
 		if err != nil {
-			return errors.Annotate(err, "turning on a device")
+			return delivery, errors.Annotate(err, "turning on a device")
 		}
 	}
 
-	// Decodes the response to a state
-	state, err := DecodeToState(bytes)
-	if err != nil {
-		return err
+	if power == PowerOn {
+		return delivery, nil
 	}
 
-	// Updates state values
-	l.HSBK = state.HSBK
-	l.Label = state.Label
-	l.Power = state.Power
-
-	return nil
-}
-
-// DecodeToState decodes an array of bytes, given in arguments,
-// and returns a State struct.
-func DecodeToState(bytes []byte) (*State, error) {
-	size := len(bytes)
-	// TODO: add size verification
-	// Decodes a part of the array to its HSBK value.
-	hsbk, err := DecodeToHSBK(bytes[size-52 : size-44])
+	d, err := l.setPower(ctx, PowerOn, duration)
+	delivery.add(d)
 	if err != nil {
-		return nil, errors.Annotate(err, "decoding state")
-	}
-
-	// Decodes the label, contained in the array of bytes.
-	label := tools.DecodeToString(bytes[size-40 : size-8])
-
-	// Determines the power level.
-	power := PowerOff
-	if binary.BigEndian.Uint16(bytes[size-42:size-40]) == 65535 {
-		power = PowerOn
-	}
-
-	// Returns the State value.
-	return &State{
-		HSBK:  hsbk,
-		Label: label,
-		Power: power,
-	}, nil
-}
-
-// DecodeToHSBK decodes an array of bytes, given in arguments,
-// and returns its HSBK value.
-func DecodeToHSBK(bytes []byte) (*HSBK, error) {
-	// The array of bytes must have a length equals to 8.
-	// A HSBK struct contains 4 uint16 variables (1 uint16 = 2 bytes)
-	if len(bytes) != 8 {
-		return nil, errors.NewNotValid(nil, "decoding a HSBK requires 8 bytes.")
+		return delivery, errors.Annotate(err, "turning on a device")
 	}
 
-	// Defines the different fields.
-	hue := binary.LittleEndian.Uint16(bytes[0:2])
-	saturation := binary.LittleEndian.Uint16(bytes[2:4])
-	brightness := binary.LittleEndian.Uint16(bytes[4:6])
-	kelvin := binary.LittleEndian.Uint16(bytes[6:8])
-
-	// Returns the HSBK value of the array of bytes
-	return &HSBK{
-		Hue:        hue,
-		Saturation: saturation,
-		Brightness: brightness,
-		Kelvin:     kelvin,
-	}, nil
+	return delivery, nil
 }
 
-// DecodeToGroup decodes an array of bytes, given in arguments,
-// and returns its Group equivalent.
-func DecodeToGroup(bytes []byte) (*Group, error) {
-	size := len(bytes)
-
-	// TODO: add size verification
-	// Defines the id of the group
-	buffer := make([]byte, 0, 16)
-	buffer = append(buffer, bytes[size-56:size-40]...)
-	id := [16]byte{}
-	copy(id[:], buffer)
+// capabilities returns the capabilities of the device.
+// They are unknown, and empty, until the device has been updated.
+func (l *Lifx) capabilities() Capabilities {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-	// Defines the label of the group
-	label := tools.DecodeToString(bytes[size-40 : size-8])
+	if l.Product == nil || l.Product.Capabilities == nil {
+		return Capabilities{}
+	}
 
-	// Returns the Group value of the array of bytes
-	return &Group{
-		ID:    id,
-		Label: label,
-	}, nil
+	return *l.Product.Capabilities
 }
 
-// DecodeToInfo decodes an array of bytes, given in arguments,
-// and returns its Info equivalent.
-func DecodeToInfo(bytes []byte) (*Info, error) {
-	size := len(bytes)
-	// TODO: add size verification
-
-	// Defines the different fields
-	time := binary.LittleEndian.Uint16(bytes[size-24 : size-16])
-	upTime := binary.LittleEndian.Uint16(bytes[size-16 : size-8])
-	downTime := binary.LittleEndian.Uint16(bytes[size-8 : size])
-
-	// Returns the Info value of the array of bytes
-	return &Info{
-		Time:     time,
-		UpTime:   upTime,
-		DownTime: downTime,
-	}, nil
+// Snapshot returns a copy of the state of the device, which can be read
+// while the device is updated by other goroutines.
+// The state values (HSBK, Group, ...) are never modified in place, they are replaced,
+// so the copy shares them with the device.
+func (l *Lifx) Snapshot() *Lifx {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return &Lifx{
+		UUID:      l.UUID,
+		Serial:    l.Serial,
+		Label:     l.Label,
+		Connected: l.Connected,
+		LastSeen:  l.LastSeen,
+		LastError: l.LastError,
+		failures:  l.failures,
+		retryAt:   l.retryAt,
+		Latency:   l.Latency,
+		Power:     l.Power,
+		HSBK:      l.HSBK,
+		Zones:     l.Zones,
+		Chain:     l.Chain,
+		Infrared:  l.Infrared,
+		Group:     l.Group,
+		Product:   l.Product,
+		Info:      l.Info,
+		Location:  l.Location,
+		Address:   l.Address,
+		Port:      l.Port,
+		Protocol:  l.Protocol,
+
+		Hev:            l.Hev,
+		FirmwareEffect: l.FirmwareEffect,
+	}
 }
 
-// DecodeToLocation decodes an array of bytes, given in arguments,
-// and returns its Location equivalent.
-func DecodeToLocation(bytes []byte) (*Location, error) {
-	size := len(bytes)
-	// TODO: add size verification
-
-	// Defines the id of the location
-	buffer := make([]byte, 0, 16)
-	buffer = append(buffer, bytes[size-56:size-40]...)
-	location := [16]byte{}
-	copy(location[:], buffer)
-
-	// Defines the label of the location
-	label := tools.DecodeToString(bytes[size-40 : size-8])
-
-	// Returns the Location value of the array of bytes
-	return &Location{
-		ID:    location,
-		Label: label,
-	}, nil
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (h *HSBK) MarshalBinary() ([]byte, error) {
+	return newEncoder(hsbkSize).hsbk(*h).buffer, nil
 }
 
-// DecodeToProduct decodes an array of bytes, given in arguments,
-// and returns its Product equivalent.
-func DecodeToProduct(bytes []byte) (*Product, error) {
-	size := len(bytes)
-	// TODO: add size verification
-
-	// Defines the id of the product
-	id := binary.LittleEndian.Uint32(bytes[size-8 : size-4])
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// A HSBK struct contains 4 uint16 variables (1 uint16 = 2 bytes)
+func (h *HSBK) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, hsbkSize); err != nil {
+		return err
+	}
 
-	// Returns the Product value of the array of bytes
-	return productsList[id], nil
+	*h = newDecoder(data).hsbk()
+	return nil
 }
 
 // LoadProducts loads the products from a file pointed by PRODUCTS_FILE env variable
@@ -0,0 +1,244 @@
+package lifx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fberrez/horus/client"
+	"github.com/juju/errors"
+)
+
+func TestDeliver(t *testing.T) {
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+
+	// The first packet is lost.
+	attempts := 0
+	conn, port, received := rawDevice(t, func(packet []byte) []byte {
+		attempts++
+		if attempts == 1 {
+			return nil
+		}
+
+		reply := GetMessageWithoutPayload(Acknowledgement)
+		reply.Header.SetTarget(serial.Target())
+		return reply.EncodeToBytes()
+	})
+	defer conn.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	l := &Lifx{Serial: serial, Address: &ip, Port: port, Protocol: client.UDP}
+	delivery, err := l.Deliver(SetPowerDeviceMessage(PowerOn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", delivery.Attempts)
+	}
+
+	if time.Since(l.LastSeen) > time.Second {
+		t.Errorf("expected the device to be seen, got %v", l.LastSeen)
+	}
+
+	// An acknowledgement is required instead of a response.
+	packet := <-received
+	if packet[22]&ackRequiredBit == 0 || packet[22]&resRequiredBit != 0 {
+		t.Errorf("expected an acknowledgement to be required, got flags %08b", packet[22])
+	}
+}
+
+func TestDeliveryAdd(t *testing.T) {
+	delivery := &Delivery{Attempts: 1, RTT: 20 * time.Millisecond}
+	delivery.add(&Delivery{Attempts: 2, RTT: 10 * time.Millisecond})
+	delivery.add(nil)
+	delivery.add(&Delivery{Attempts: 1, RTT: 30 * time.Millisecond})
+
+	if delivery.Attempts != 4 || delivery.RTT != 30*time.Millisecond {
+		t.Errorf("expected 4 attempts and a round trip time of 30ms, got %+v", delivery)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	conn, port, received := rawDevice(t, func(packet []byte) []byte {
+		return nil
+	})
+	defer conn.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	l := &Lifx{Address: &ip, Port: port, Protocol: client.UDP}
+
+	// A done context stops the request before the end of its backoff.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := l.RequestContext(ctx, GetMessageWithoutPayload(Get), StateLight); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("expected the error of the context, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > DefaultBackoff.Initial*2 {
+		t.Errorf("expected the request to stop with the context, got %v", elapsed)
+	}
+
+	if len(received) != 1 {
+		t.Errorf("expected 1 attempt, got %d", len(received))
+	}
+}
+
+// ackingDevice returns a device acknowledging every message, and the channel of its received packets.
+func ackingDevice(t *testing.T) (*Lifx, *net.UDPConn, chan []byte) {
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+	conn, port, received := rawDevice(t, func(packet []byte) []byte {
+		reply := GetMessageWithoutPayload(Acknowledgement)
+		reply.Header.SetTarget(serial.Target())
+		return reply.EncodeToBytes()
+	})
+
+	ip := net.IPv4(127, 0, 0, 1)
+	return &Lifx{Serial: serial, Address: &ip, Port: port, Protocol: client.UDP}, conn, received
+}
+
+// receivedMessages decodes the packets received by a device.
+func receivedMessages(t *testing.T, received chan []byte) []*Message {
+	messages := []*Message{}
+	for len(received) > 0 {
+		message, err := DecodeToMessage(<-received)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+func TestSetInfrared(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	// The other devices do not have any infrared channel.
+	if _, err := l.SetInfrared(65535); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	brightness := uint16(32768)
+	if _, err := l.SetState(&State{Label: "Porch", Infrared: &brightness}, 0); !errors.IsNotSupported(err) {
+		t.Errorf("expected a not supported error, got %v", err)
+	}
+
+	if len(received) != 0 {
+		t.Fatalf("expected no message, got %d", len(received))
+	}
+
+	l.Product = &Product{Capabilities: &Capabilities{HasIR: true}}
+	if _, err := l.SetInfrared(brightness); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receivedMessages(t, received)
+	if len(messages) != 1 || messages[0].Header.Type() != SetInfrared {
+		t.Fatalf("expected a SetInfrared message, got %d messages", len(messages))
+	}
+
+	if payload, err := messages[0].Payload(); err != nil || payload.(*InfraredPayload).Brightness != brightness {
+		t.Errorf("expected the brightness %d, got %+v (%v)", brightness, payload, err)
+	}
+
+	if l.Infrared != brightness {
+		t.Errorf("expected the infrared %d to be stored, got %d", brightness, l.Infrared)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	color := &HSBK{Hue: 21845, Saturation: 65535, Brightness: 32768, Kelvin: 3500}
+	dark := &HSBK{Hue: 21845, Saturation: 65535, Brightness: 0, Kelvin: 3500}
+	tests := []struct {
+		name     string
+		power    Power
+		hsbk     *HSBK
+		messages []MessageType
+		payloads []Payload
+	}{
+		{
+			name:     "light on",
+			power:    PowerOn,
+			hsbk:     color,
+			messages: []MessageType{GetPowerLight, SetPowerLight},
+			payloads: []Payload{&EmptyPayload{}, &SetPowerLightPayload{Level: 0, Duration: 500}},
+		},
+		{
+			name:     "light off",
+			power:    PowerOff,
+			hsbk:     color,
+			messages: []MessageType{GetPowerLight, SetPowerLight},
+			payloads: []Payload{&EmptyPayload{}, &SetPowerLightPayload{Level: 65535, Duration: 500}},
+		},
+		{
+			name:     "dark light off",
+			power:    PowerOff,
+			hsbk:     dark,
+			messages: []MessageType{GetPowerLight, SetColor, SetPowerLight},
+			payloads: []Payload{
+				&EmptyPayload{},
+				&SetColorPayload{Color: HSBK{Hue: 21845, Saturation: 65535, Brightness: 1000, Kelvin: 3500}},
+				&SetPowerLightPayload{Level: 65535, Duration: 500},
+			},
+		},
+		{
+			name:     "dark light on",
+			power:    PowerOn,
+			hsbk:     dark,
+			messages: []MessageType{GetPowerLight, SetColor},
+			payloads: []Payload{
+				&EmptyPayload{},
+				&SetColorPayload{Color: HSBK{Hue: 21845, Saturation: 65535, Brightness: 1000, Kelvin: 3500}, Duration: 500},
+			},
+		},
+	}
+
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+	for _, test := range tests {
+		// The power is read from the light, whatever the known power.
+		level := test.power.Level()
+		conn, port, received := rawDevice(t, func(packet []byte) []byte {
+			reply := GetMessageWithoutPayload(Acknowledgement)
+			if MessageType(binary.LittleEndian.Uint16(packet[32:34])) == GetPowerLight {
+				reply = NewMessageWithPayload(StatePowerLight, &PowerPayload{Level: level})
+			}
+
+			reply.Header.SetTarget(serial.Target())
+			return reply.EncodeToBytes()
+		})
+
+		ip := net.IPv4(127, 0, 0, 1)
+		l := &Lifx{Serial: serial, Address: &ip, Port: port, Protocol: client.UDP, HSBK: test.hsbk}
+		_, err := l.Toggle(1000, 500)
+		conn.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		messages := receivedMessages(t, received)
+		if len(messages) != len(test.messages) {
+			t.Errorf("%s: expected %d messages, got %d", test.name, len(test.messages), len(messages))
+			continue
+		}
+
+		for i, message := range messages {
+			if message.Header.Type() != test.messages[i] {
+				t.Errorf("%s: message %d: expected type %d, got %d", test.name, i, test.messages[i], message.Header.Type())
+				continue
+			}
+
+			if payload, err := message.Payload(); err != nil || !reflect.DeepEqual(payload, test.payloads[i]) {
+				t.Errorf("%s: message %d: expected %+v, got %+v (%v)", test.name, i, test.payloads[i], payload, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package lifx
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// Tile is a tile of the chain of a matrix device.
+	// Its zones are a matrix of colors, read row by row.
+	Tile struct {
+		// Index is the index of the tile in the chain.
+		Index int `json:"index"`
+
+		// UserX is the horizontal position of the tile, set by the user.
+		UserX float32 `json:"userX"`
+
+		// UserY is the vertical position of the tile, set by the user.
+		UserY float32 `json:"userY"`
+
+		// Width is the number of zones of a row of the tile.
+		Width int `json:"width"`
+
+		// Height is the number of rows of the tile.
+		Height int `json:"height"`
+
+		// Orientation is the orientation of the tile, measured by its accelerometer.
+		Orientation Orientation `json:"orientation"`
+
+		// Colors contains the colors of the zones of the tile, row by row.
+		Colors []HSBK `json:"colors"`
+	}
+
+	// Orientation is the orientation of a tile.
+	Orientation string
+)
+
+const (
+	// RightSideUp is the orientation of a tile which has not been rotated.
+	RightSideUp Orientation = "RightSideUp"
+	// RotatedLeft is the orientation of a tile rotated to the left.
+	RotatedLeft Orientation = "RotatedLeft"
+	// RotatedRight is the orientation of a tile rotated to the right.
+	RotatedRight Orientation = "RotatedRight"
+	// FaceUp is the orientation of a tile lying on its back.
+	FaceUp Orientation = "FaceUp"
+	// FaceDown is the orientation of a tile lying on its face.
+	FaceDown Orientation = "FaceDown"
+	// UpsideDown is the orientation of a tile turned upside down.
+	UpsideDown Orientation = "UpsideDown"
+)
+
+// orientation returns the orientation of the tile, from the measures of its accelerometer.
+// The dominant axis of the gravity defines the orientation.
+func (t *TileDevice) orientation() Orientation {
+	x, y, z := int(t.AccelMeasX), int(t.AccelMeasY), int(t.AccelMeasZ)
+	// The measures are all -1 if the tile does not have any accelerometer.
+	if x == -1 && y == -1 && z == -1 {
+		return RightSideUp
+	}
+
+	absX, absY, absZ := abs(x), abs(y), abs(z)
+	switch {
+	case absX > absY && absX > absZ:
+		if x > 0 {
+			return RotatedRight
+		}
+		return RotatedLeft
+	case absZ > absX && absZ > absY:
+		if z > 0 {
+			return FaceDown
+		}
+		return FaceUp
+	default:
+		if y > 0 {
+			return UpsideDown
+		}
+		return RightSideUp
+	}
+}
+
+// abs returns the absolute value of an integer.
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// rows returns the number of rows of a tile which fit in a State64 or Set64 message.
+func (t *Tile) rows() int {
+	if t.Width == 0 {
+		return t.Height
+	}
+
+	rows := zonesPerTileMessage / t.Width
+	if rows == 0 {
+		return 1
+	}
+	return rows
+}
+
+// readChain reads the tiles of a matrix device and the colors of their zones.
+// The colors of every tile are requested at once, so the device sends a reply per tile.
+// The caller must hold the commands lock.
+func (l *Lifx) readChain(ctx context.Context) ([]*Tile, error) {
+	// Sends a GetDeviceChain (701) Message
+	payload, err := l.request(ctx, GetMessageWithoutPayload(GetDeviceChain), StateDeviceChain)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading the device chain")
+	}
+
+	state := payload.(*StateDeviceChainPayload)
+	chain := []*Tile{}
+	for i := 0; i < int(state.TilesCount) && i < tilesPerChain; i++ {
+		device := &state.Tiles[i]
+		chain = append(chain, &Tile{
+			Index:       int(state.StartIndex) + i,
+			UserX:       device.UserX,
+			UserY:       device.UserY,
+			Width:       int(device.Width),
+			Height:      int(device.Height),
+			Orientation: device.orientation(),
+			Colors:      make([]HSBK, int(device.Width)*int(device.Height)),
+		})
+	}
+
+	if len(chain) == 0 {
+		return chain, nil
+	}
+
+	// Sends Get64 (707) Messages, for the rows which fit in a message.
+	// The tiles of a chain have the same size.
+	first := chain[0]
+	for y := 0; y < first.Height; y += first.rows() {
+		// The replies are tracked by tile, so the tiles whose reply is missing are requested again.
+		received := make([]bool, len(chain))
+		start, end := 0, len(chain)
+		for requests := 0; start < end; requests++ {
+			if requests > missingRequests {
+				return nil, errors.Errorf("missing colors of tile %d from row %d in the replies of device %s", chain[start].Index, y, l.UUID)
+			}
+
+			message := Get64Message(uint8(chain[start].Index), uint8(end-start), 0, uint8(y), uint8(first.Width))
+			payloads, err := l.requestAll(ctx, message, end-start, State64)
+			if err != nil {
+				return nil, errors.Annotate(err, "reading the colors of the tiles")
+			}
+
+			for _, payload := range payloads {
+				state := payload.(*State64Payload)
+				index := int(state.TileIndex) - first.Index
+				if index < 0 || index >= len(chain) || int(state.Y) != y {
+					continue
+				}
+
+				tile := chain[index]
+				if offset := y * tile.Width; offset < len(tile.Colors) {
+					copy(tile.Colors[offset:], state.Colors[:])
+					received[index] = true
+				}
+			}
+
+			start, end = missingRange(received)
+		}
+	}
+
+	return chain, nil
+}
+
+// SetTile sets the colors of the zones of a tile of a matrix device.
+// The colors are given row by row, and there must be a color for every zone of the tile.
+// It returns the statistics of the delivered messages.
+func (l *Lifx) SetTile(index int, colors []HSBK, duration uint32) (*Delivery, error) {
+	return l.SetTileContext(context.Background(), index, colors, duration)
+}
+
+// SetTileContext is like SetTile but it stops sending messages when the context is done.
+func (l *Lifx) SetTileContext(ctx context.Context, index int, colors []HSBK, duration uint32) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.setTile(ctx, index, colors, duration)
+}
+
+// setTile is the implementation of SetTileContext.
+// The caller must hold the commands lock.
+func (l *Lifx) setTile(ctx context.Context, index int, colors []HSBK, duration uint32) (*Delivery, error) {
+	if !l.capabilities().HasMatrix {
+		return nil, errors.NotSupportedf("tiles on device %s", l.UUID)
+	}
+
+	// The tile must be known, so its size is known.
+	tile := l.tile(index)
+	if tile == nil {
+		return nil, errors.NotFoundf("tile %d of device %s", index, l.UUID)
+	}
+
+	if len(colors) != tile.Width*tile.Height {
+		return nil, errors.NotValidf("%d colors for the %dx%d zones of tile %d", len(colors), tile.Width, tile.Height, index)
+	}
+
+	// Sends Set64 (715) Messages, for the rows which fit in a message.
+	delivery := &Delivery{}
+	for y := 0; y < tile.Height; y += tile.rows() {
+		end := (y + tile.rows()) * tile.Width
+		if end > len(colors) {
+			end = len(colors)
+		}
+
+		message := Set64Message(uint8(index), 0, uint8(y), uint8(tile.Width), duration, colors[y*tile.Width:end])
+		d, err := l.deliver(ctx, message)
+		delivery.add(d)
+		if err != nil {
+			return delivery, errors.Annotate(err, "setting tile")
+		}
+	}
+
+	l.storeTile(index, colors)
+
+	return delivery, nil
+}
+
+// tile returns the known tile of the device with the given index, or nil.
+func (l *Lifx) tile(index int) *Tile {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, tile := range l.Chain {
+		if tile.Index == index {
+			return tile
+		}
+	}
+
+	return nil
+}
+
+// storeTile updates the known colors of a tile.
+// The chain is copied, so the snapshots of the device are not modified.
+func (l *Lifx) storeTile(index int, colors []HSBK) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	chain := make([]*Tile, len(l.Chain))
+	for i, tile := range l.Chain {
+		chain[i] = tile
+		if tile.Index == index {
+			updated := *tile
+			updated.Colors = append([]HSBK{}, colors...)
+			chain[i] = &updated
+		}
+	}
+	l.Chain = chain
+}
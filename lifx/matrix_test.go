@@ -0,0 +1,255 @@
+package lifx
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fberrez/horus/client"
+)
+
+// chainDevice is a fake matrix device of the given tiles, answering the GetDeviceChain (701) messages,
+// and each Get64 (707) message with the State64 (711) messages of the payloads returned by the function, from the number of the Get64 message.
+// The received Get64 payloads are sent to the returned channel.
+// It returns the device and its socket.
+func chainDevice(t *testing.T, width, height, tiles int, replies func(request int, get *Get64Payload) []*State64Payload) (*Lifx, *net.UDPConn, chan *Get64Payload) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := &StateDeviceChainPayload{TilesCount: uint8(tiles)}
+	for i := 0; i < tiles; i++ {
+		chain.Tiles[i] = TileDevice{AccelMeasX: -1, AccelMeasY: -1, AccelMeasZ: -1, Width: uint8(width), Height: uint8(height)}
+	}
+
+	received := make(chan *Get64Payload, 16)
+	go func() {
+		for request := 0; ; {
+			buffer := make([]byte, 2048)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			messages := []*Message{NewMessageWithPayload(StateDeviceChain, chain)}
+			if _, payload, err := DecodeToPayload(buffer[:n], Get64); err == nil {
+				get := payload.(*Get64Payload)
+				received <- get
+				messages = []*Message{}
+				for _, state := range replies(request, get) {
+					messages = append(messages, NewMessageWithPayload(State64, state))
+				}
+				request++
+			}
+
+			for _, message := range messages {
+				packet := message.EncodeToBytes()
+				copy(packet[4:8], buffer[4:8])
+				packet[23] = buffer[23]
+				conn.WriteToUDP(packet, from)
+			}
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	device := &Lifx{Address: &addr.IP, Port: strconv.Itoa(addr.Port), Protocol: client.UDP}
+	return device, conn, received
+}
+
+// tileColor returns the color of a zone of a tile of a fake matrix device.
+func tileColor(tile, zone int) HSBK {
+	return sampleColor(tile*256 + zone)
+}
+
+// state64Replies returns the State64 (711) payloads answering a Get64 (707) message, one per requested tile.
+func state64Replies(get *Get64Payload) []*State64Payload {
+	payloads := []*State64Payload{}
+	for tile := int(get.TileIndex); tile < int(get.TileIndex)+int(get.Length); tile++ {
+		p := &State64Payload{TileIndex: uint8(tile), Y: get.Y, Width: get.Width}
+		for i := range p.Colors {
+			p.Colors[i] = tileColor(tile, int(get.Y)*int(get.Width)+i)
+		}
+		payloads = append(payloads, p)
+	}
+
+	return payloads
+}
+
+func TestTileRows(t *testing.T) {
+	tests := []struct {
+		width, height int
+		rows          int
+	}{
+		{8, 8, 8},
+		{16, 8, 4},
+		{5, 5, 12},
+		{64, 2, 1},
+		{100, 2, 1},
+		{0, 3, 3},
+	}
+
+	for _, test := range tests {
+		tile := &Tile{Width: test.width, Height: test.height}
+		if rows := tile.rows(); rows != test.rows {
+			t.Errorf("tile %dx%d: expected %d rows, got %d", test.width, test.height, test.rows, rows)
+		}
+	}
+}
+
+func TestTileOrientation(t *testing.T) {
+	tests := []struct {
+		x, y, z     int16
+		orientation Orientation
+	}{
+		{-1, -1, -1, RightSideUp},
+		{0, -100, 0, RightSideUp},
+		{0, 100, 0, UpsideDown},
+		{100, 10, -10, RotatedRight},
+		{-100, 10, -10, RotatedLeft},
+		{10, 10, 100, FaceDown},
+		{10, 10, -100, FaceUp},
+		{50, 50, 50, UpsideDown},
+	}
+
+	for _, test := range tests {
+		tile := &TileDevice{AccelMeasX: test.x, AccelMeasY: test.y, AccelMeasZ: test.z}
+		if orientation := tile.orientation(); orientation != test.orientation {
+			t.Errorf("measures (%d, %d, %d): expected %s, got %s", test.x, test.y, test.z, test.orientation, orientation)
+		}
+	}
+}
+
+func TestReadChain(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		tiles         int
+		replies       func(request int, get *Get64Payload) []*State64Payload
+		requests      [][3]int
+		err           string
+	}{
+		{
+			name:     "every tile in the first replies",
+			width:    8,
+			height:   8,
+			tiles:    3,
+			replies:  func(request int, get *Get64Payload) []*State64Payload { return state64Replies(get) },
+			requests: [][3]int{{0, 3, 0}},
+		},
+		{
+			name:     "rows of the wide tiles in several messages",
+			width:    16,
+			height:   8,
+			tiles:    2,
+			replies:  func(request int, get *Get64Payload) []*State64Payload { return state64Replies(get) },
+			requests: [][3]int{{0, 2, 0}, {0, 2, 4}},
+		},
+		{
+			name:   "reply of another row",
+			width:  8,
+			height: 8,
+			tiles:  3,
+			replies: func(request int, get *Get64Payload) []*State64Payload {
+				payloads := state64Replies(get)
+				if request == 0 {
+					payloads[1].Y = 1
+				}
+				return payloads
+			},
+			requests: [][3]int{{0, 3, 0}, {1, 1, 0}},
+		},
+		{
+			name:   "reply of a tile out of the chain",
+			width:  8,
+			height: 8,
+			tiles:  3,
+			replies: func(request int, get *Get64Payload) []*State64Payload {
+				payloads := state64Replies(get)
+				if request == 0 {
+					payloads[2].TileIndex = 7
+				}
+				return payloads
+			},
+			requests: [][3]int{{0, 3, 0}, {2, 1, 0}},
+		},
+		{
+			name:   "missing first and last tiles",
+			width:  8,
+			height: 8,
+			tiles:  3,
+			replies: func(request int, get *Get64Payload) []*State64Payload {
+				payloads := state64Replies(get)
+				if request == 0 {
+					payloads[0].Y = 1
+					payloads[2].Y = 1
+				}
+				return payloads
+			},
+			requests: [][3]int{{0, 3, 0}, {0, 3, 0}},
+		},
+		{
+			name:   "tile never received",
+			width:  8,
+			height: 8,
+			tiles:  3,
+			replies: func(request int, get *Get64Payload) []*State64Payload {
+				payloads := state64Replies(get)
+				payloads[0].TileIndex = 7
+				return payloads
+			},
+			requests: [][3]int{{0, 3, 0}, {0, 1, 0}, {0, 1, 0}, {0, 1, 0}},
+			err:      "missing colors of tile 0 from row 0",
+		},
+	}
+
+	for _, test := range tests {
+		device, conn, received := chainDevice(t, test.width, test.height, test.tiles, test.replies)
+		chain, err := device.readChain(context.Background())
+		conn.Close()
+
+		requests := [][3]int{}
+		for len(received) > 0 {
+			get := <-received
+			requests = append(requests, [3]int{int(get.TileIndex), int(get.Length), int(get.Y)})
+		}
+
+		if !reflect.DeepEqual(requests, test.requests) {
+			t.Errorf("%s: expected the requests %v, got %v", test.name, test.requests, requests)
+		}
+
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected the error `%s`, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(chain) != test.tiles {
+			t.Errorf("%s: expected %d tiles, got %d", test.name, test.tiles, len(chain))
+			continue
+		}
+
+		for _, tile := range chain {
+			if tile.Width != test.width || tile.Height != test.height || tile.Orientation != RightSideUp || len(tile.Colors) != test.width*test.height {
+				t.Errorf("%s: unexpected tile %+v", test.name, tile)
+				continue
+			}
+
+			for i, color := range tile.Colors {
+				if color != tileColor(tile.Index, i) {
+					t.Errorf("%s: tile %d, zone %d: expected %v, got %v", test.name, tile.Index, i, tileColor(tile.Index, i), color)
+					break
+				}
+			}
+		}
+	}
+}
@@ -28,22 +28,192 @@ func NewMessage() *Message {
 	}
 }
 
+// NewMessageWithPayload returns a new Message with the given type and payload.
+// The payloads of this package have a fixed size and never fail to be encoded.
+func NewMessageWithPayload(msgType MessageType, payload Payload) *Message {
+	bytes, _ := payload.MarshalBinary()
+	message := NewMessage().SetPayload(bytes)
+	message.Header.SetMessageType(msgType).SetFrame(TAFrame)
+
+	return message
+}
+
 // SetColorMessage returns a SetColor (102) message
 // with parsed data, given in arguments.
 func SetColorMessage(hsbk *HSBK, duration uint32) *Message {
-	// Encodes HSBK in bytes
-	hsbkBytes := encodeHSBKToBytes(hsbk)
-	// Encodes duration in bytes
-	durationBytes := encodeDurationToBytes(duration)
+	message := NewMessageWithPayload(SetColor, &SetColorPayload{
+		Color:    *hsbk,
+		Duration: duration,
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetWaveformMessage returns a SetWaveform (103) message
+// performing the given effect.
+func SetWaveformMessage(effect *Effect) *Message {
+	message := NewMessageWithPayload(SetWaveform, &SetWaveformPayload{
+		Transient: !effect.Persist,
+		Color:     *effect.Color,
+		Period:    effect.Period,
+		Cycles:    effect.Cycles,
+		SkewRatio: effect.SkewRatio,
+		Waveform:  uint8(effect.Waveform),
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetWaveformOptionalMessage returns a SetWaveformOptional (119) message
+// performing the given effect on its color channels only.
+// If the effect does not define any channel, every channel is changed.
+func SetWaveformOptionalMessage(effect *Effect) *Message {
+	channels := effect.Channels
+	if channels == nil {
+		channels = &Channels{Hue: true, Saturation: true, Brightness: true, Kelvin: true}
+	}
+
+	message := NewMessageWithPayload(SetWaveformOptional, &SetWaveformOptionalPayload{
+		SetWaveformPayload: SetWaveformPayload{
+			Transient: !effect.Persist,
+			Color:     *effect.Color,
+			Period:    effect.Period,
+			Cycles:    effect.Cycles,
+			SkewRatio: effect.SkewRatio,
+			Waveform:  uint8(effect.Waveform),
+		},
+		SetHue:        channels.Hue,
+		SetSaturation: channels.Saturation,
+		SetBrightness: channels.Brightness,
+		SetKelvin:     channels.Kelvin,
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetColorZonesMessage returns a SetColorZones (501) message
+// setting the zones from start to end, included, to the given color.
+func SetColorZonesMessage(start, end uint8, hsbk *HSBK, duration uint32, apply ApplicationRequest) *Message {
+	message := NewMessageWithPayload(SetColorZones, &SetColorZonesPayload{
+		StartIndex: start,
+		EndIndex:   end,
+		Color:      *hsbk,
+		Duration:   duration,
+		Apply:      uint8(apply),
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// GetColorZonesMessage returns a GetColorZones (502) message
+// requesting the colors of the zones from start to end, included.
+func GetColorZonesMessage(start, end uint8) *Message {
+	message := NewMessageWithPayload(GetColorZones, &GetColorZonesPayload{
+		StartIndex: start,
+		EndIndex:   end,
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
 
-	// Encode payload
-	payload := append([]byte{}, 0X00)
-	payload = append(payload, hsbkBytes...)
-	payload = append(payload, durationBytes...)
-	message := NewMessage().SetPayload(payload)
+// SetExtendedColorZonesMessage returns a SetExtendedColorZones (510) message
+// setting the zones from the index to the given colors.
+// Only the first 82 colors are sent.
+func SetExtendedColorZonesMessage(index uint16, colors []HSBK, duration uint32, apply ApplicationRequest) *Message {
+	payload := &SetExtendedColorZonesPayload{
+		Duration: duration,
+		Apply:    uint8(apply),
+		Index:    index,
+	}
+	payload.ColorsCount = uint8(copy(payload.Colors[:], colors))
+	message := NewMessageWithPayload(SetExtendedColorZones, payload)
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// Get64Message returns a Get64 (707) message requesting the colors of a rectangle of zones
+// of the given number of tiles, from the tile index.
+func Get64Message(index, length, x, y, width uint8) *Message {
+	message := NewMessageWithPayload(Get64, &Get64Payload{
+		TileIndex: index,
+		Length:    length,
+		X:         x,
+		Y:         y,
+		Width:     width,
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// Set64Message returns a Set64 (715) message setting the colors of a rectangle of zones of a tile.
+// The colors are given row by row. Only the first 64 colors are sent.
+func Set64Message(index, x, y, width uint8, duration uint32, colors []HSBK) *Message {
+	payload := &Set64Payload{
+		TileIndex: index,
+		Length:    1,
+		X:         x,
+		Y:         y,
+		Width:     width,
+		Duration:  duration,
+	}
+	copy(payload.Colors[:], colors)
+	message := NewMessageWithPayload(Set64, payload)
 
 	// Defines Header
-	message.Header.SetMessageType(SetColor).IsResRequired(true).SetSequence(0X10).SetFrame(TAFrame)
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetMultiZoneEffectMessage returns a SetMultiZoneEffect (508) message
+// running the given effect with the firmware of a multizone device.
+func SetMultiZoneEffectMessage(effect *FirmwareEffect) *Message {
+	message := NewMessageWithPayload(SetMultiZoneEffect, effect.multiZonePayload())
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// GetTileEffectMessage returns a GetTileEffect (718) message.
+func GetTileEffectMessage() *Message {
+	message := NewMessageWithPayload(GetTileEffect, &GetTileEffectPayload{})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetTileEffectMessage returns a SetTileEffect (719) message
+// running the given effect with the firmware of a matrix device.
+func SetTileEffectMessage(effect *FirmwareEffect) *Message {
+	message := NewMessageWithPayload(SetTileEffect, effect.tilePayload())
+
+	// Defines Header
+	message.Header.IsResRequired(true)
 
 	return message
 }
@@ -51,43 +221,126 @@ func SetColorMessage(hsbk *HSBK, duration uint32) *Message {
 // GetMessageWithoutPayload returns a message with a given msgType.
 // Note: this message does nnot have any payload (its payload is an empty array of bytes).
 func GetMessageWithoutPayload(msgType MessageType) *Message {
-	message := NewMessage()
+	message := NewMessageWithPayload(msgType, &EmptyPayload{})
 	// Defines header
-	message.Header.SetMessageType(msgType).IsResRequired(true).SetFrame(TAFrame)
-	message.Header.SetSequence(0X10)
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// EchoRequestMessage returns an EchoRequest (58) message,
+// whose payload is echoed by the device in an EchoResponse (59) message.
+func EchoRequestMessage(payload *EchoPayload) *Message {
+	message := NewMessageWithPayload(EchoRequest, payload)
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetGroupMessage returns a SetGroup (52) message moving the device to the given group.
+func SetGroupMessage(group *Group) *Message {
+	message := NewMessageWithPayload(SetGroup, &GroupPayload{
+		ID:        [16]byte(group.ID),
+		Label:     group.Label,
+		UpdatedAt: uint64(group.UpdatedAt.UnixNano()),
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetLocationMessage returns a SetLocation (49) message moving the device to the given location.
+func SetLocationMessage(location *Location) *Message {
+	message := NewMessageWithPayload(SetLocation, &LocationPayload{
+		ID:        [16]byte(location.ID),
+		Label:     location.Label,
+		UpdatedAt: uint64(location.UpdatedAt.UnixNano()),
+	})
+
+	// Defines Header
+	message.Header.IsResRequired(true)
 
 	return message
 }
 
 // SetPowerDeviceMessage returns a SetPowerDevice (21) message with the given power status.
 func SetPowerDeviceMessage(power Power) *Message {
-	message := NewMessage()
+	message := NewMessageWithPayload(SetPowerDevice, &PowerPayload{
+		Level: power.Level(),
+	})
+
 	// Defines header
-	message.Header.SetMessageType(SetPowerDevice).IsResRequired(true).SetFrame(TAFrame)
-	message.Header.SetSequence(0X10)
-
-	// If power is "on", the payload sets the power level on 65535.
-	// Else, it sets the power level on 0.
-	if power == PowerOn {
-		message.SetPayload([]byte{0XFF, 0XFF})
-	} else {
-		message.SetPayload([]byte{0X00, 0X00})
-	}
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetInfraredMessage returns a SetInfrared (122) message with the given brightness of the infrared channel.
+func SetInfraredMessage(brightness uint16) *Message {
+	message := NewMessageWithPayload(SetInfrared, &InfraredPayload{
+		Brightness: brightness,
+	})
+
+	// Defines header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetHevCycleMessage returns a SetHevCycle (143) message starting or stopping a HEV cycle
+// of the given duration in seconds.
+func SetHevCycleMessage(enable bool, duration uint32) *Message {
+	message := NewMessageWithPayload(SetHevCycle, &SetHevCyclePayload{
+		Enable:   enable,
+		Duration: duration,
+	})
+
+	// Defines header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetHevCycleConfigurationMessage returns a SetHevCycleConfiguration (146) message
+// with the given indication and default duration of the HEV cycles in seconds.
+func SetHevCycleConfigurationMessage(indication bool, duration uint32) *Message {
+	message := NewMessageWithPayload(SetHevCycleConfiguration, &HevCycleConfigurationPayload{
+		Indication: indication,
+		Duration:   duration,
+	})
+
+	// Defines header
+	message.Header.IsResRequired(true)
+
+	return message
+}
+
+// SetPowerLightMessage returns a SetPowerLight (117) message with the given power status,
+// reached after the given duration in milliseconds.
+func SetPowerLightMessage(power Power, duration uint32) *Message {
+	message := NewMessageWithPayload(SetPowerLight, &SetPowerLightPayload{
+		Level:    power.Level(),
+		Duration: duration,
+	})
+
+	// Defines header
+	message.Header.IsResRequired(true)
 
 	return message
 }
 
 // SetLabelMessage returns a SetLabel (24) message with the given label.
 func SetLabelMessage(label string) *Message {
-	message := NewMessage()
+	message := NewMessageWithPayload(SetLabel, &LabelPayload{
+		Label: label,
+	})
 
 	// Defines header
-	message.Header.SetMessageType(SetLabel).IsResRequired(true).SetFrame(TAFrame)
-	message.Header.SetSequence(0X10)
-
-	payload := [32]byte{}
-	copy(payload[:], label)
-	message.SetPayload(payload[:])
+	message.Header.IsResRequired(true)
 
 	return message
 }
@@ -117,60 +370,73 @@ func (m *Message) updateSize() int {
 
 // DecodeToMessage decodes a array of bytes
 // and converts the result to a new Message.
-func DecodeToMessage(bytes []byte) *Message {
+// It verifies the size of the message and its header.
+func DecodeToMessage(bytes []byte) (*Message, error) {
 	log.WithFields(log.Fields{
 		"from":  "lifx.DecodeToMessage",
 		"bytes": fmt.Sprintf("% 02X", bytes),
 	}).Debug("Decoding a Message")
 
-	m := &Message{
-		Header: &Header{},
+	// Decodes header
+	header, err := DecodeToHeader(bytes)
+	if err != nil {
+		return nil, err
 	}
 
-	// Decodes size
+	// Verifies the size of the message
+	size := int(binary.LittleEndian.Uint16(bytes[0:2]))
+	if size < HeaderSize || size > len(bytes) {
+		return nil, &InvalidHeaderError{Reason: fmt.Sprintf("size %d does not match a packet of %d bytes", size, len(bytes))}
+	}
+
+	m := &Message{
+		Header:  header,
+		payload: append([]byte{}, bytes[HeaderSize:size]...),
+	}
 	copy(m.Size[:], bytes[0:2])
 
-	// Decodes header
-	copy(m.Header.frame[:], bytes[2:4])
-	copy(m.Header.source[:], bytes[4:8])
-	copy(m.Header.target[:], bytes[8:16])
-	m.Header.ackRequired, m.Header.resRequired = decodeAckRes(bytes[16])
-	m.Header.sequence = bytes[17]
-	copy(m.Header.messageType[:], bytes[18:19])
+	return m, nil
+}
 
-	// Decodes payload
-	copy(m.payload, bytes[19:])
+// DecodeToPayload decodes an array of bytes to a message of the expected type
+// and returns its payload.
+func DecodeToPayload(bytes []byte, expected MessageType) (*Message, Payload, error) {
+	m, err := DecodeToMessage(bytes)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return m
-}
+	if m.Header.Type() != expected {
+		return nil, nil, &UnexpectedTypeError{Expected: expected, Got: m.Header.Type()}
+	}
 
-// SetPayload sets the specified payload in the message.
-func (m *Message) SetPayload(payload []byte) *Message {
-	m.payload = payload
-	return m
+	payload, err := m.Payload()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, payload, nil
 }
 
-func encodeHSBKToBytes(hsbk *HSBK) []byte {
-	hue := make([]byte, 2)
-	saturation := make([]byte, 2)
-	brightness := make([]byte, 2)
-	kelvin := make([]byte, 2)
-	binary.LittleEndian.PutUint16(hue, hsbk.Hue)
-	binary.LittleEndian.PutUint16(saturation, hsbk.Saturation)
-	binary.LittleEndian.PutUint16(brightness, hsbk.Brightness)
-	binary.LittleEndian.PutUint16(kelvin, hsbk.Kelvin)
+// Payload decodes the payload of the message to the payload registered for its type.
+func (m *Message) Payload() (Payload, error) {
+	payload, err := NewPayload(m.Header.Type())
+	if err != nil {
+		return nil, err
+	}
 
-	result := append([]byte{}, hue...)
-	result = append(result, saturation...)
-	result = append(result, brightness...)
-	result = append(result, kelvin...)
+	if err := payload.UnmarshalBinary(m.payload); err != nil {
+		if short, ok := err.(*ShortPayloadError); ok {
+			short.Type = m.Header.Type()
+		}
+		return nil, err
+	}
 
-	return result
+	return payload, nil
 }
 
-func encodeDurationToBytes(duration uint32) []byte {
-	result := make([]byte, 4)
-	binary.LittleEndian.PutUint32(result, duration)
-
-	return result
+// SetPayload sets the specified payload in the message.
+func (m *Message) SetPayload(payload []byte) *Message {
+	m.payload = payload
+	return m
 }
@@ -0,0 +1,236 @@
+package lifx
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// ApplicationRequest determines when the colors sent to the zones of a multizone device are applied.
+	ApplicationRequest uint8
+
+	// zonesPayload is a payload containing the colors of consecutive zones of a multizone device.
+	zonesPayload interface {
+		// zones returns the number of zones of the device, the index of the first zone
+		// of the payload and the colors of the zones of the payload.
+		zones() (int, int, []HSBK)
+	}
+)
+
+const (
+	// NoApply stores the colors, without applying them.
+	NoApply ApplicationRequest = 0
+	// Apply applies the colors, with the ones previously stored.
+	Apply ApplicationRequest = 1
+	// ApplyOnly applies the stored colors, ignoring the sent ones.
+	ApplyOnly ApplicationRequest = 2
+)
+
+// readZones reads the colors of every zone of a multizone device.
+// The extended messages are used if the device handles them.
+// The caller must hold the commands lock.
+func (l *Lifx) readZones(ctx context.Context, extended bool) ([]HSBK, error) {
+	message := func(start, end int) *Message { return GetColorZonesMessage(uint8(start), uint8(end-1)) }
+	expected := []MessageType{StateZone, StateMultiZone}
+	if extended {
+		message = func(start, end int) *Message { return GetMessageWithoutPayload(GetExtendedColorZones) }
+		expected = []MessageType{StateExtendedColorZones}
+	}
+
+	// The number of zones is unknown before the first reply.
+	payloads, err := l.requestAll(ctx, message(0, 256), 1, expected...)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, received := assembleZones(nil, nil, payloads)
+	if zones == nil {
+		return nil, errors.Errorf("missing zones in the reply of device %s", l.UUID)
+	}
+
+	// If the zones do not fit in a single reply, the missing zones are requested,
+	// and requested again until every zone has been received.
+	start, end := missingRange(received)
+	for requests := 0; start < end; requests++ {
+		if requests >= missingRequests {
+			return nil, errors.Errorf("missing zones from %d to %d in the replies of device %s", start, end-1, l.UUID)
+		}
+
+		// The extended messages always contain every zone.
+		// Otherwise the zones are sent by blocks, from a multiple of the size of the blocks.
+		replies := (len(zones) + zonesPerExtended - 1) / zonesPerExtended
+		if !extended {
+			start -= start % zonesPerMultiZone
+			replies = (end - start + zonesPerMultiZone - 1) / zonesPerMultiZone
+		}
+
+		payloads, err := l.requestAll(ctx, message(start, end), replies, expected...)
+		if err != nil {
+			return nil, err
+		}
+
+		zones, received = assembleZones(zones, received, payloads)
+		start, end = missingRange(received)
+	}
+
+	return zones, nil
+}
+
+// assembleZones adds the colors of the zones contained by the payloads to the zones already received.
+// If no zone has been received yet, the zones are allocated from the number of zones of the device.
+// It returns the zones, and whether each zone has been received.
+func assembleZones(zones []HSBK, received []bool, payloads []Payload) ([]HSBK, []bool) {
+	for _, payload := range payloads {
+		p, ok := payload.(zonesPayload)
+		if !ok {
+			continue
+		}
+
+		total, index, colors := p.zones()
+		if zones == nil {
+			zones = make([]HSBK, total)
+			received = make([]bool, total)
+		}
+
+		for i, color := range colors {
+			if index+i < len(zones) {
+				zones[index+i] = color
+				received[index+i] = true
+			}
+		}
+	}
+
+	return zones, received
+}
+
+// SetZoneRange sets the zones of a multizone device from start to end, included, to the given color.
+// If the device handles the extended messages, the colors are sent by 82 zones.
+// Else, the zones cannot exceed 255.
+// It returns the statistics of the delivered messages.
+func (l *Lifx) SetZoneRange(start, end uint16, hsbk *HSBK, duration uint32, apply ApplicationRequest) (*Delivery, error) {
+	return l.SetZoneRangeContext(context.Background(), start, end, hsbk, duration, apply)
+}
+
+// SetZoneRangeContext is like SetZoneRange but it stops sending messages when the context is done.
+func (l *Lifx) SetZoneRangeContext(ctx context.Context, start, end uint16, hsbk *HSBK, duration uint32, apply ApplicationRequest) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	capabilities := l.capabilities()
+	if !capabilities.HasMultiZone {
+		return nil, errors.NotSupportedf("zones on device %s", l.UUID)
+	}
+
+	if end < start {
+		return nil, errors.NotValidf("zones from %d to %d", start, end)
+	}
+
+	colors := make([]HSBK, int(end)-int(start)+1)
+	for i := range colors {
+		colors[i] = *hsbk
+	}
+
+	if capabilities.HasExtendedMultiZone {
+		return l.setZones(ctx, start, colors, duration, apply)
+	}
+
+	if end > 255 {
+		return nil, errors.NotValidf("zones from %d to %d on device %s", start, end, l.UUID)
+	}
+
+	// Sends a SetColorZones message to the device
+	delivery, err := l.deliver(ctx, SetColorZonesMessage(uint8(start), uint8(end), hsbk, duration, apply))
+	if err != nil {
+		return delivery, errors.Annotate(err, "setting zones")
+	}
+
+	l.storeZones(int(start), colors)
+
+	return delivery, nil
+}
+
+// SetZones sets the zones of a multizone device, from the index, to the given colors.
+// If the device handles the extended messages, the colors are sent by 82 zones.
+// Else, a message is sent for each zone.
+// Only the last message applies the colors, as requested.
+// It returns the statistics of the delivered messages.
+func (l *Lifx) SetZones(index uint16, colors []HSBK, duration uint32, apply ApplicationRequest) (*Delivery, error) {
+	return l.SetZonesContext(context.Background(), index, colors, duration, apply)
+}
+
+// SetZonesContext is like SetZones but it stops sending messages when the context is done.
+func (l *Lifx) SetZonesContext(ctx context.Context, index uint16, colors []HSBK, duration uint32, apply ApplicationRequest) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	return l.setZones(ctx, index, colors, duration, apply)
+}
+
+// setZones is the implementation of SetZonesContext.
+// The caller must hold the commands lock.
+func (l *Lifx) setZones(ctx context.Context, index uint16, colors []HSBK, duration uint32, apply ApplicationRequest) (*Delivery, error) {
+	capabilities := l.capabilities()
+	if !capabilities.HasMultiZone {
+		return nil, errors.NotSupportedf("zones on device %s", l.UUID)
+	}
+
+	if len(colors) == 0 {
+		return nil, errors.NotValidf("empty list of zones")
+	}
+
+	perMessage := 1
+	if capabilities.HasExtendedMultiZone {
+		perMessage = zonesPerExtended
+	} else if int(index)+len(colors) > 256 {
+		return nil, errors.NotValidf("%d zones from %d", len(colors), index)
+	}
+
+	delivery := &Delivery{}
+	for sent := 0; sent < len(colors); sent += perMessage {
+		end := sent + perMessage
+		if end > len(colors) {
+			end = len(colors)
+		}
+
+		// The colors are only applied with the last message.
+		request := NoApply
+		if end == len(colors) {
+			request = apply
+		}
+
+		var message *Message
+		if capabilities.HasExtendedMultiZone {
+			message = SetExtendedColorZonesMessage(index+uint16(sent), colors[sent:end], duration, request)
+		} else {
+			zone := uint8(int(index) + sent)
+			message = SetColorZonesMessage(zone, zone, &colors[sent], duration, request)
+		}
+
+		d, err := l.deliver(ctx, message)
+		delivery.add(d)
+		if err != nil {
+			return delivery, errors.Annotate(err, "setting zones")
+		}
+	}
+
+	l.storeZones(int(index), colors)
+
+	return delivery, nil
+}
+
+// storeZones updates the known zones of the device with the given colors, from the index.
+// The zones are copied, so the snapshots of the device are not modified.
+// The colors outside of the known zones are ignored.
+func (l *Lifx) storeZones(index int, colors []HSBK) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	zones := append([]HSBK{}, l.Zones...)
+	for i, color := range colors {
+		if index+i < len(zones) {
+			zones[index+i] = color
+		}
+	}
+	l.Zones = zones
+}
@@ -0,0 +1,276 @@
+package lifx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fberrez/horus/client"
+)
+
+// zonesDevice is a fake multizone device answering each request for zones with the messages
+// returned by the function, from the number of the request and the requested range of zones.
+// The received packets are sent to the returned channel.
+// It returns the device and its socket.
+func zonesDevice(t *testing.T, replies func(request, start, end int) []*Message) (*Lifx, *net.UDPConn, chan []byte) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 16)
+	go func() {
+		for request := 0; ; request++ {
+			buffer := make([]byte, 2048)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			received <- buffer[:n]
+
+			start, end := requestedZones(buffer[:n])
+			for _, reply := range replies(request, start, end) {
+				packet := reply.EncodeToBytes()
+				copy(packet[4:8], buffer[4:8])
+				packet[23] = buffer[23]
+				conn.WriteToUDP(packet, from)
+			}
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	device := &Lifx{Address: &addr.IP, Port: strconv.Itoa(addr.Port), Protocol: client.UDP}
+	return device, conn, received
+}
+
+// requestedZones returns the range of the zones requested by a packet, from start to end, excluded.
+// The extended messages always request every zone.
+func requestedZones(packet []byte) (int, int) {
+	_, payload, err := DecodeToPayload(packet, GetColorZones)
+	if err != nil {
+		return 0, 65536
+	}
+
+	p := payload.(*GetColorZonesPayload)
+	return int(p.StartIndex), int(p.EndIndex) + 1
+}
+
+// multiZoneReplies returns the StateMultiZone (506) messages of the zones from start to end, excluded,
+// of a device of the given number of zones, by blocks of 8 zones.
+func multiZoneReplies(count, start, end int) []*Message {
+	if end > count {
+		end = count
+	}
+
+	messages := []*Message{}
+	for index := start; index < end; index += zonesPerMultiZone {
+		p := &StateMultiZonePayload{Count: uint8(count), Index: uint8(index)}
+		for i := range p.Colors {
+			p.Colors[i] = sampleColor(index + i)
+		}
+		messages = append(messages, NewMessageWithPayload(StateMultiZone, p))
+	}
+
+	return messages
+}
+
+// extendedReplies returns the StateExtendedColorZones (512) messages of every zone
+// of a device of the given number of zones, by blocks of 82 zones.
+func extendedReplies(count int) []*Message {
+	messages := []*Message{}
+	for index := 0; index < count; index += zonesPerExtended {
+		p := &StateExtendedColorZonesPayload{Count: uint16(count), Index: uint16(index), ColorsCount: zonesPerExtended}
+		if count-index < zonesPerExtended {
+			p.ColorsCount = uint8(count - index)
+		}
+
+		for i := 0; i < int(p.ColorsCount); i++ {
+			p.Colors[i] = sampleColor(index + i)
+		}
+		messages = append(messages, NewMessageWithPayload(StateExtendedColorZones, p))
+	}
+
+	return messages
+}
+
+func TestMissingRange(t *testing.T) {
+	tests := []struct {
+		received   []bool
+		start, end int
+	}{
+		{nil, 0, 0},
+		{[]bool{true, true, true}, 0, 0},
+		{[]bool{false, true, true}, 0, 1},
+		{[]bool{true, true, false}, 2, 3},
+		{[]bool{true, false, true, false, true}, 1, 4},
+		{[]bool{false, false, false}, 0, 3},
+	}
+
+	for _, test := range tests {
+		start, end := missingRange(test.received)
+		if start != test.start || end != test.end {
+			t.Errorf("received %v: expected the range [%d, %d), got [%d, %d)", test.received, test.start, test.end, start, end)
+		}
+	}
+}
+
+func TestAssembleZones(t *testing.T) {
+	multiZone := &StateMultiZonePayload{Count: 10, Index: 8}
+	sampleColors(multiZone.Colors[:])
+
+	extended := &StateExtendedColorZonesPayload{Count: 100, Index: 82, ColorsCount: 3}
+	sampleColors(extended.Colors[:])
+
+	tests := []struct {
+		name     string
+		zones    []HSBK
+		received []bool
+		payloads []Payload
+		expected []bool
+	}{
+		{
+			name:     "zones allocated from the first payload, and the zones beyond the device ignored",
+			payloads: []Payload{&PowerPayload{}, multiZone},
+			expected: []bool{false, false, false, false, false, false, false, false, true, true},
+		},
+		{
+			name:     "colors limited to the colors of the extended message",
+			payloads: []Payload{extended},
+			expected: append(make([]bool, 82), true, true, true, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false),
+		},
+		{
+			name:     "zones added to the received ones",
+			zones:    make([]HSBK, 12),
+			received: []bool{true, false, false, false, false, false, false, false, false, false, false, true},
+			payloads: []Payload{multiZone, &StateZonePayload{Count: 10, Index: 1, Color: sampleColor(1)}},
+			expected: []bool{true, true, false, false, false, false, false, false, true, true, true, true},
+		},
+	}
+
+	for _, test := range tests {
+		zones, received := assembleZones(test.zones, test.received, test.payloads)
+		if !reflect.DeepEqual(received, test.expected) {
+			t.Errorf("%s: expected the received zones %v, got %v", test.name, test.expected, received)
+			continue
+		}
+
+		if len(zones) != len(received) {
+			t.Errorf("%s: expected %d zones, got %d", test.name, len(received), len(zones))
+		}
+	}
+
+	// The colors are stored at the index of their zone.
+	zones, _ := assembleZones(nil, nil, []Payload{multiZone})
+	if zones[8] != sampleColor(0) || zones[9] != sampleColor(1) {
+		t.Errorf("unexpected zones %v", zones[8:])
+	}
+}
+
+func TestReadZones(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    int
+		extended bool
+		replies  func(request, start, end int) []*Message
+		requests [][2]int
+		err      string
+	}{
+		{
+			name:  "every zone in the first reply",
+			count: 6,
+			replies: func(request, start, end int) []*Message {
+				return multiZoneReplies(6, start, end)
+			},
+			requests: [][2]int{{0, 256}},
+		},
+		{
+			name:  "partial first reply",
+			count: 20,
+			replies: func(request, start, end int) []*Message {
+				return multiZoneReplies(20, start, end)
+			},
+			requests: [][2]int{{0, 256}, {8, 20}},
+		},
+		{
+			name:  "missing zones requested from a multiple of 8",
+			count: 30,
+			replies: func(request, start, end int) []*Message {
+				// The last block of the second reply only contains its first zone.
+				if request == 1 {
+					return append(multiZoneReplies(30, 8, 24), NewMessageWithPayload(StateZone, &StateZonePayload{Count: 30, Index: 24, Color: sampleColor(24)}))
+				}
+
+				return multiZoneReplies(30, start, end)
+			},
+			requests: [][2]int{{0, 256}, {8, 30}, {24, 30}},
+		},
+		{
+			name:     "extended messages",
+			count:    100,
+			extended: true,
+			replies: func(request, start, end int) []*Message {
+				return extendedReplies(100)
+			},
+			requests: [][2]int{{0, 65536}, {0, 65536}},
+		},
+		{
+			name:  "zones never received",
+			count: 16,
+			replies: func(request, start, end int) []*Message {
+				return multiZoneReplies(16, 0, 8)
+			},
+			requests: [][2]int{{0, 256}, {8, 16}, {8, 16}, {8, 16}},
+			err:      "missing zones from 8 to 15",
+		},
+	}
+
+	for _, test := range tests {
+		device, conn, received := zonesDevice(t, test.replies)
+		zones, err := device.readZones(context.Background(), test.extended)
+		conn.Close()
+
+		requests := [][2]int{}
+		for len(received) > 0 {
+			packet := <-received
+			if msgType := MessageType(binary.LittleEndian.Uint16(packet[32:34])); test.extended != (msgType == GetExtendedColorZones) {
+				t.Errorf("%s: unexpected request of type %d", test.name, msgType)
+			}
+
+			start, end := requestedZones(packet)
+			requests = append(requests, [2]int{start, end})
+		}
+
+		if !reflect.DeepEqual(requests, test.requests) {
+			t.Errorf("%s: expected the requests %v, got %v", test.name, test.requests, requests)
+		}
+
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected the error `%s`, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(zones) != test.count {
+			t.Errorf("%s: expected %d zones, got %d", test.name, test.count, len(zones))
+			continue
+		}
+
+		for i, zone := range zones {
+			if zone != sampleColor(i) {
+				t.Errorf("%s: zone %d: expected %v, got %v", test.name, i, sampleColor(i), zone)
+				break
+			}
+		}
+	}
+}
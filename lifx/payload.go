@@ -0,0 +1,1300 @@
+package lifx
+
+import "time"
+
+type (
+	// EmptyPayload is the payload of messages without any data, such as Get messages.
+	EmptyPayload struct{}
+
+	// StateServicePayload is the payload of a StateService (3) message.
+	StateServicePayload struct {
+		// Service is the service exposed by the device. 1 means UDP.
+		Service uint8
+
+		// Port is the port on which the service is exposed.
+		Port uint32
+	}
+
+	// SignalPayload is the payload of StateHostInfo (13) and StateWifiInfo (17) messages.
+	SignalPayload struct {
+		// Signal is the radio receive signal strength in milliwatts.
+		Signal float32
+
+		// Tx is the number of bytes transmitted since power on.
+		Tx uint32
+
+		// Rx is the number of bytes received since power on.
+		Rx uint32
+	}
+
+	// FirmwarePayload is the payload of StateHostFirmware (15) and StateWifiFirmware (19) messages.
+	FirmwarePayload struct {
+		// Build is the firmware build time (absolute time in nanoseconds since epoch).
+		Build uint64
+
+		// VersionMinor is the minor version of the firmware.
+		VersionMinor uint16
+
+		// VersionMajor is the major version of the firmware.
+		VersionMajor uint16
+	}
+
+	// PowerPayload is the payload of SetPowerDevice (21), StatePower (22) and StatePowerLight (118) messages.
+	PowerPayload struct {
+		// Level is the power level. It is either 0 or 65535.
+		Level uint16
+	}
+
+	// InfraredPayload is the payload of StateInfrared (121) and SetInfrared (122) messages.
+	InfraredPayload struct {
+		// Brightness is the brightness of the infrared channel, from 0 to 65535.
+		Brightness uint16
+	}
+
+	// SetHevCyclePayload is the payload of a SetHevCycle (143) message.
+	SetHevCyclePayload struct {
+		// Enable determines if a cycle is started or stopped.
+		Enable bool
+
+		// Duration is the duration of the cycle in seconds.
+		// The default duration of the device is used if it is 0.
+		Duration uint32
+	}
+
+	// StateHevCyclePayload is the payload of a StateHevCycle (144) message.
+	StateHevCyclePayload struct {
+		// Duration is the duration of the current cycle in seconds.
+		Duration uint32
+
+		// Remaining is the remaining duration of the current cycle in seconds.
+		// It is 0 if no cycle is running.
+		Remaining uint32
+
+		// LastPower is the power of the light before the cycle started.
+		LastPower bool
+	}
+
+	// HevCycleConfigurationPayload is the payload of SetHevCycleConfiguration (146)
+	// and StateHevCycleConfiguration (147) messages.
+	HevCycleConfigurationPayload struct {
+		// Indication determines if the light briefly flashes when a cycle ends.
+		Indication bool
+
+		// Duration is the default duration of a cycle in seconds.
+		Duration uint32
+	}
+
+	// StateLastHevCycleResultPayload is the payload of a StateLastHevCycleResult (149) message.
+	StateLastHevCycleResultPayload struct {
+		// Result is the result of the last cycle.
+		Result uint8
+	}
+
+	// LabelPayload is the payload of SetLabel (24) and StateLabel (25) messages.
+	LabelPayload struct {
+		// Label is the label of the device.
+		Label string
+	}
+
+	// StateVersionPayload is the payload of a StateVersion (33) message.
+	StateVersionPayload struct {
+		// Vendor is the vendor ID.
+		Vendor uint32
+
+		// Product is the product ID.
+		Product uint32
+
+		// Version is the hardware version.
+		Version uint32
+	}
+
+	// StateInfoPayload is the payload of a StateInfo (35) message.
+	StateInfoPayload struct {
+		// Time is the current time (absolute time in nanoseconds since epoch).
+		Time uint64
+
+		// UpTime is the time since last power on (relative time in nanoseconds).
+		UpTime uint64
+
+		// DownTime is the last power off period, 5 second accuracy (in nanoseconds).
+		DownTime uint64
+	}
+
+	// LocationPayload is the payload of SetLocation (49) and StateLocation (50) messages.
+	LocationPayload struct {
+		// ID is the ID of the location.
+		ID [16]byte
+
+		// Label is the name of the location.
+		Label string
+
+		// UpdatedAt is the last update time (absolute time in nanoseconds since epoch).
+		UpdatedAt uint64
+	}
+
+	// GroupPayload is the payload of SetGroup (52) and StateGroup (53) messages.
+	GroupPayload struct {
+		// ID is the ID of the group.
+		ID [16]byte
+
+		// Label is the name of the group.
+		Label string
+
+		// UpdatedAt is the last update time (absolute time in nanoseconds since epoch).
+		UpdatedAt uint64
+	}
+
+	// EchoPayload is the payload of EchoRequest (58) and EchoResponse (59) messages.
+	EchoPayload struct {
+		// Payload is the data echoed by the device.
+		Payload [64]byte
+	}
+
+	// SetColorPayload is the payload of a SetColor (102) message.
+	SetColorPayload struct {
+		// Color is the new color of the light.
+		Color HSBK
+
+		// Duration is the color transition time in milliseconds.
+		Duration uint32
+	}
+
+	// SetWaveformPayload is the payload of a SetWaveform (103) message.
+	SetWaveformPayload struct {
+		// Transient determines if the color returns to its original value after the effect.
+		Transient bool
+
+		// Color is the color of the effect.
+		Color HSBK
+
+		// Period is the duration of a cycle in milliseconds.
+		Period uint32
+
+		// Cycles is the number of cycles.
+		Cycles float32
+
+		// SkewRatio is the waveform skew, from -32768 to 32767.
+		SkewRatio int16
+
+		// Waveform is the waveform to use.
+		Waveform uint8
+	}
+
+	// SetWaveformOptionalPayload is the payload of a SetWaveformOptional (119) message.
+	// It is a SetWaveform payload which only applies on the selected color channels.
+	SetWaveformOptionalPayload struct {
+		SetWaveformPayload
+
+		// SetHue determines if the hue of the light is changed by the effect.
+		SetHue bool
+
+		// SetSaturation determines if the saturation of the light is changed by the effect.
+		SetSaturation bool
+
+		// SetBrightness determines if the brightness of the light is changed by the effect.
+		SetBrightness bool
+
+		// SetKelvin determines if the kelvin of the light is changed by the effect.
+		SetKelvin bool
+	}
+
+	// SetColorZonesPayload is the payload of a SetColorZones (501) message.
+	SetColorZonesPayload struct {
+		// StartIndex is the index of the first zone to set.
+		StartIndex uint8
+
+		// EndIndex is the index of the last zone to set.
+		EndIndex uint8
+
+		// Color is the new color of the zones.
+		Color HSBK
+
+		// Duration is the color transition time in milliseconds.
+		Duration uint32
+
+		// Apply determines when the color is applied.
+		Apply uint8
+	}
+
+	// GetColorZonesPayload is the payload of a GetColorZones (502) message.
+	GetColorZonesPayload struct {
+		// StartIndex is the index of the first requested zone.
+		StartIndex uint8
+
+		// EndIndex is the index of the last requested zone.
+		EndIndex uint8
+	}
+
+	// StateZonePayload is the payload of a StateZone (503) message.
+	StateZonePayload struct {
+		// Count is the number of zones of the device.
+		Count uint8
+
+		// Index is the index of the zone.
+		Index uint8
+
+		// Color is the color of the zone.
+		Color HSBK
+	}
+
+	// StateMultiZonePayload is the payload of a StateMultiZone (506) message.
+	StateMultiZonePayload struct {
+		// Count is the number of zones of the device.
+		Count uint8
+
+		// Index is the index of the first zone.
+		Index uint8
+
+		// Colors contains the colors of 8 zones, from the first one.
+		Colors [zonesPerMultiZone]HSBK
+	}
+
+	// SetExtendedColorZonesPayload is the payload of a SetExtendedColorZones (510) message.
+	SetExtendedColorZonesPayload struct {
+		// Duration is the color transition time in milliseconds.
+		Duration uint32
+
+		// Apply determines when the colors are applied.
+		Apply uint8
+
+		// Index is the index of the first zone to set.
+		Index uint16
+
+		// ColorsCount is the number of colors to set.
+		ColorsCount uint8
+
+		// Colors contains the colors of the zones, from the first one.
+		Colors [zonesPerExtended]HSBK
+	}
+
+	// StateExtendedColorZonesPayload is the payload of a StateExtendedColorZones (512) message.
+	StateExtendedColorZonesPayload struct {
+		// Count is the number of zones of the device.
+		Count uint16
+
+		// Index is the index of the first zone.
+		Index uint16
+
+		// ColorsCount is the number of colors of the message.
+		ColorsCount uint8
+
+		// Colors contains the colors of the zones, from the first one.
+		Colors [zonesPerExtended]HSBK
+	}
+
+	// TileDevice contains the informations of a tile of a device chain.
+	TileDevice struct {
+		// AccelMeasX is the measure of the accelerometer on the X axis.
+		AccelMeasX int16
+
+		// AccelMeasY is the measure of the accelerometer on the Y axis.
+		AccelMeasY int16
+
+		// AccelMeasZ is the measure of the accelerometer on the Z axis.
+		AccelMeasZ int16
+
+		// UserX is the horizontal position of the tile, set by the user.
+		UserX float32
+
+		// UserY is the vertical position of the tile, set by the user.
+		UserY float32
+
+		// Width is the number of zones of a row of the tile.
+		Width uint8
+
+		// Height is the number of rows of the tile.
+		Height uint8
+
+		// Vendor is the vendor ID of the tile.
+		Vendor uint32
+
+		// Product is the product ID of the tile.
+		Product uint32
+
+		// FirmwareBuild is the build time of the firmware of the tile, in nanoseconds since epoch.
+		FirmwareBuild uint64
+
+		// FirmwareMinor is the minor version of the firmware of the tile.
+		FirmwareMinor uint16
+
+		// FirmwareMajor is the major version of the firmware of the tile.
+		FirmwareMajor uint16
+	}
+
+	// StateDeviceChainPayload is the payload of a StateDeviceChain (702) message.
+	StateDeviceChainPayload struct {
+		// StartIndex is the index of the first tile.
+		StartIndex uint8
+
+		// Tiles contains the tiles of the chain, from the first one.
+		Tiles [tilesPerChain]TileDevice
+
+		// TilesCount is the number of tiles of the chain.
+		TilesCount uint8
+	}
+
+	// Get64Payload is the payload of a Get64 (707) message.
+	Get64Payload struct {
+		// TileIndex is the index of the first tile.
+		TileIndex uint8
+
+		// Length is the number of tiles, from the first one.
+		Length uint8
+
+		// X is the column of the first zone.
+		X uint8
+
+		// Y is the row of the first zone.
+		Y uint8
+
+		// Width is the width of the rectangle of zones.
+		Width uint8
+	}
+
+	// State64Payload is the payload of a State64 (711) message.
+	State64Payload struct {
+		// TileIndex is the index of the tile.
+		TileIndex uint8
+
+		// X is the column of the first zone.
+		X uint8
+
+		// Y is the row of the first zone.
+		Y uint8
+
+		// Width is the width of the rectangle of zones.
+		Width uint8
+
+		// Colors contains the colors of the zones, row by row.
+		Colors [zonesPerTileMessage]HSBK
+	}
+
+	// Set64Payload is the payload of a Set64 (715) message.
+	Set64Payload struct {
+		// TileIndex is the index of the first tile.
+		TileIndex uint8
+
+		// Length is the number of tiles, from the first one.
+		Length uint8
+
+		// X is the column of the first zone.
+		X uint8
+
+		// Y is the row of the first zone.
+		Y uint8
+
+		// Width is the width of the rectangle of zones.
+		Width uint8
+
+		// Duration is the color transition time in milliseconds.
+		Duration uint32
+
+		// Colors contains the colors of the zones, row by row.
+		Colors [zonesPerTileMessage]HSBK
+	}
+
+	// MultiZoneEffectPayload is the payload of the SetMultiZoneEffect (508)
+	// and StateMultiZoneEffect (509) messages.
+	MultiZoneEffectPayload struct {
+		// InstanceID identifies the running effect.
+		InstanceID uint32
+
+		// Type is the type of the effect.
+		Type uint8
+
+		// Speed is the duration of a cycle of the effect in milliseconds.
+		Speed uint32
+
+		// Duration is the duration of the effect in nanoseconds. The effect never stops if it is 0.
+		Duration uint64
+
+		// Parameters contains the parameters of the effect, depending on its type.
+		Parameters [effectParameters]uint32
+	}
+
+	// TileEffectPayload contains the settings of an effect of a matrix device.
+	TileEffectPayload struct {
+		// InstanceID identifies the running effect.
+		InstanceID uint32
+
+		// Type is the type of the effect.
+		Type uint8
+
+		// Speed is the duration of a cycle of the effect in milliseconds.
+		Speed uint32
+
+		// Duration is the duration of the effect in nanoseconds. The effect never stops if it is 0.
+		Duration uint64
+
+		// Parameters contains the parameters of the effect, depending on its type.
+		Parameters [effectParameters]uint32
+
+		// PaletteCount is the number of colors of the palette.
+		PaletteCount uint8
+
+		// Palette contains the colors used by the effect.
+		Palette [paletteSize]HSBK
+	}
+
+	// GetTileEffectPayload is the payload of a GetTileEffect (718) message.
+	// It only contains reserved bytes.
+	GetTileEffectPayload struct{}
+
+	// SetTileEffectPayload is the payload of a SetTileEffect (719) message.
+	SetTileEffectPayload struct {
+		TileEffectPayload
+	}
+
+	// StateTileEffectPayload is the payload of a StateTileEffect (720) message.
+	StateTileEffectPayload struct {
+		TileEffectPayload
+	}
+
+	// StateLightPayload is the payload of a StateLight (107) message.
+	StateLightPayload struct {
+		// Color is the current color of the light.
+		Color HSBK
+
+		// Power is the current power level of the light.
+		Power uint16
+
+		// Label is the label of the light.
+		Label string
+	}
+
+	// SetPowerLightPayload is the payload of a SetPowerLight (117) message.
+	SetPowerLightPayload struct {
+		// Level is the power level. It is either 0 or 65535.
+		Level uint16
+
+		// Duration is the power level transition time in milliseconds.
+		Duration uint32
+	}
+)
+
+// newEmptyPayload returns a new EmptyPayload.
+func newEmptyPayload() Payload {
+	return &EmptyPayload{}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *EmptyPayload) MarshalBinary() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *EmptyPayload) UnmarshalBinary(data []byte) error {
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateServicePayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(5).uint8(p.Service).uint32(p.Port).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateServicePayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 5); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Service = d.uint8()
+	p.Port = d.uint32()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SignalPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(14).float32(p.Signal).uint32(p.Tx).uint32(p.Rx).reserved(2).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SignalPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 14); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Signal = d.float32()
+	p.Tx = d.uint32()
+	p.Rx = d.uint32()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *FirmwarePayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(20).uint64(p.Build).reserved(8).uint16(p.VersionMinor).uint16(p.VersionMajor).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *FirmwarePayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 20); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Build = d.uint64()
+	d.reserved(8)
+	p.VersionMinor = d.uint16()
+	p.VersionMajor = d.uint16()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *PowerPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(2).uint16(p.Level).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *PowerPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 2); err != nil {
+		return err
+	}
+
+	p.Level = newDecoder(data).uint16()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *InfraredPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(2).uint16(p.Brightness).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *InfraredPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 2); err != nil {
+		return err
+	}
+
+	p.Brightness = newDecoder(data).uint16()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetHevCyclePayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(5).bool(p.Enable).uint32(p.Duration).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetHevCyclePayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 5); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Enable = d.bool()
+	p.Duration = d.uint32()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateHevCyclePayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(9).uint32(p.Duration).uint32(p.Remaining).bool(p.LastPower).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateHevCyclePayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 9); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Duration = d.uint32()
+	p.Remaining = d.uint32()
+	p.LastPower = d.bool()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *HevCycleConfigurationPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(5).bool(p.Indication).uint32(p.Duration).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *HevCycleConfigurationPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 5); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Indication = d.bool()
+	p.Duration = d.uint32()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateLastHevCycleResultPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(1).uint8(p.Result).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateLastHevCycleResultPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 1); err != nil {
+		return err
+	}
+
+	p.Result = newDecoder(data).uint8()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *LabelPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(labelSize).string(p.Label, labelSize).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *LabelPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, labelSize); err != nil {
+		return err
+	}
+
+	p.Label = newDecoder(data).string(labelSize)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateVersionPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(12).uint32(p.Vendor).uint32(p.Product).uint32(p.Version).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateVersionPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 12); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Vendor = d.uint32()
+	p.Product = d.uint32()
+	p.Version = d.uint32()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateInfoPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(24).uint64(p.Time).uint64(p.UpTime).uint64(p.DownTime).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateInfoPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 24); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Time = d.uint64()
+	p.UpTime = d.uint64()
+	p.DownTime = d.uint64()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *LocationPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(56).bytes(p.ID[:]).string(p.Label, labelSize).uint64(p.UpdatedAt).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *LocationPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 56); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	d.bytes(p.ID[:])
+	p.Label = d.string(labelSize)
+	p.UpdatedAt = d.uint64()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *GroupPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(56).bytes(p.ID[:]).string(p.Label, labelSize).uint64(p.UpdatedAt).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *GroupPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 56); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	d.bytes(p.ID[:])
+	p.Label = d.string(labelSize)
+	p.UpdatedAt = d.uint64()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *EchoPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(64).bytes(p.Payload[:]).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *EchoPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 64); err != nil {
+		return err
+	}
+
+	newDecoder(data).bytes(p.Payload[:])
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetColorPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(13).reserved(1).hsbk(p.Color).uint32(p.Duration).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetColorPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 13); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	d.reserved(1)
+	p.Color = d.hsbk()
+	p.Duration = d.uint32()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetWaveformPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(21).reserved(1).bool(p.Transient).hsbk(p.Color)
+	return e.uint32(p.Period).float32(p.Cycles).int16(p.SkewRatio).uint8(p.Waveform).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetWaveformPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 21); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	d.reserved(1)
+	p.Transient = d.bool()
+	p.Color = d.hsbk()
+	p.Period = d.uint32()
+	p.Cycles = d.float32()
+	p.SkewRatio = d.int16()
+	p.Waveform = d.uint8()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetWaveformOptionalPayload) MarshalBinary() ([]byte, error) {
+	waveform, _ := p.SetWaveformPayload.MarshalBinary()
+	e := newEncoder(25).bytes(waveform)
+	return e.bool(p.SetHue).bool(p.SetSaturation).bool(p.SetBrightness).bool(p.SetKelvin).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetWaveformOptionalPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 25); err != nil {
+		return err
+	}
+
+	if err := p.SetWaveformPayload.UnmarshalBinary(data[0:21]); err != nil {
+		return err
+	}
+
+	d := newDecoder(data[21:])
+	p.SetHue = d.bool()
+	p.SetSaturation = d.bool()
+	p.SetBrightness = d.bool()
+	p.SetKelvin = d.bool()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateLightPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(52).hsbk(p.Color).reserved(2).uint16(p.Power)
+	return e.string(p.Label, labelSize).reserved(8).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateLightPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 52); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Color = d.hsbk()
+	d.reserved(2)
+	p.Power = d.uint16()
+	p.Label = d.string(labelSize)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetPowerLightPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(6).uint16(p.Level).uint32(p.Duration).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetPowerLightPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 6); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Level = d.uint16()
+	p.Duration = d.uint32()
+	return nil
+}
+
+// State returns the State equivalent of the payload.
+func (p *StateLightPayload) State() *State {
+	hsbk := p.Color
+	return &State{
+		HSBK:  &hsbk,
+		Power: powerFromLevel(p.Power),
+		Label: p.Label,
+	}
+}
+
+// Group returns the Group equivalent of the payload.
+func (p *GroupPayload) Group() *Group {
+	return &Group{
+		ID:        ID(p.ID),
+		Label:     p.Label,
+		UpdatedAt: time.Unix(0, int64(p.UpdatedAt)).UTC(),
+	}
+}
+
+// Location returns the Location equivalent of the payload.
+func (p *LocationPayload) Location() *Location {
+	return &Location{
+		ID:        ID(p.ID),
+		Label:     p.Label,
+		UpdatedAt: time.Unix(0, int64(p.UpdatedAt)).UTC(),
+	}
+}
+
+// Info returns the Info equivalent of the payload.
+func (p *StateInfoPayload) Info() *Info {
+	return &Info{
+		Time:     p.Time,
+		UpTime:   p.UpTime,
+		DownTime: p.DownTime,
+	}
+}
+
+// Level returns the power level of a Power.
+func (p Power) Level() uint16 {
+	if p == PowerOn {
+		return 65535
+	}
+
+	return 0
+}
+
+// powerFromLevel returns the Power corresponding to a power level.
+func powerFromLevel(level uint16) Power {
+	if level == 65535 {
+		return PowerOn
+	}
+
+	return PowerOff
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetColorZonesPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(15).uint8(p.StartIndex).uint8(p.EndIndex).hsbk(p.Color)
+	return e.uint32(p.Duration).uint8(p.Apply).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetColorZonesPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 15); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.StartIndex = d.uint8()
+	p.EndIndex = d.uint8()
+	p.Color = d.hsbk()
+	p.Duration = d.uint32()
+	p.Apply = d.uint8()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *GetColorZonesPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(2).uint8(p.StartIndex).uint8(p.EndIndex).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *GetColorZonesPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 2); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.StartIndex = d.uint8()
+	p.EndIndex = d.uint8()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateZonePayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(10).uint8(p.Count).uint8(p.Index).hsbk(p.Color).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateZonePayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 10); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Count = d.uint8()
+	p.Index = d.uint8()
+	p.Color = d.hsbk()
+	return nil
+}
+
+// zones implements zonesPayload.
+func (p *StateZonePayload) zones() (int, int, []HSBK) {
+	return int(p.Count), int(p.Index), []HSBK{p.Color}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateMultiZonePayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(2 + zonesPerMultiZone*hsbkSize).uint8(p.Count).uint8(p.Index)
+	for _, color := range p.Colors {
+		e.hsbk(color)
+	}
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateMultiZonePayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 2+zonesPerMultiZone*hsbkSize); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Count = d.uint8()
+	p.Index = d.uint8()
+	for i := range p.Colors {
+		p.Colors[i] = d.hsbk()
+	}
+	return nil
+}
+
+// zones implements zonesPayload.
+func (p *StateMultiZonePayload) zones() (int, int, []HSBK) {
+	return int(p.Count), int(p.Index), p.Colors[:]
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetExtendedColorZonesPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(8 + zonesPerExtended*hsbkSize).uint32(p.Duration).uint8(p.Apply)
+	e.uint16(p.Index).uint8(p.ColorsCount)
+	for _, color := range p.Colors {
+		e.hsbk(color)
+	}
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetExtendedColorZonesPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 8+zonesPerExtended*hsbkSize); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Duration = d.uint32()
+	p.Apply = d.uint8()
+	p.Index = d.uint16()
+	p.ColorsCount = d.uint8()
+	for i := range p.Colors {
+		p.Colors[i] = d.hsbk()
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateExtendedColorZonesPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(5 + zonesPerExtended*hsbkSize).uint16(p.Count).uint16(p.Index).uint8(p.ColorsCount)
+	for _, color := range p.Colors {
+		e.hsbk(color)
+	}
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateExtendedColorZonesPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 5+zonesPerExtended*hsbkSize); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.Count = d.uint16()
+	p.Index = d.uint16()
+	p.ColorsCount = d.uint8()
+	for i := range p.Colors {
+		p.Colors[i] = d.hsbk()
+	}
+	return nil
+}
+
+// zones implements zonesPayload.
+func (p *StateExtendedColorZonesPayload) zones() (int, int, []HSBK) {
+	count := int(p.ColorsCount)
+	if count > zonesPerExtended {
+		count = zonesPerExtended
+	}
+
+	return int(p.Count), int(p.Index), p.Colors[:count]
+}
+
+// encode encodes the tile device.
+func (t *TileDevice) encode(e *encoder) {
+	e.int16(t.AccelMeasX).int16(t.AccelMeasY).int16(t.AccelMeasZ).reserved(2)
+	e.float32(t.UserX).float32(t.UserY).uint8(t.Width).uint8(t.Height).reserved(1)
+	e.uint32(t.Vendor).uint32(t.Product).reserved(4).uint64(t.FirmwareBuild).reserved(8)
+	e.uint16(t.FirmwareMinor).uint16(t.FirmwareMajor).reserved(4)
+}
+
+// decode decodes the tile device.
+func (t *TileDevice) decode(d *decoder) {
+	t.AccelMeasX = d.int16()
+	t.AccelMeasY = d.int16()
+	t.AccelMeasZ = d.int16()
+	d.reserved(2)
+	t.UserX = d.float32()
+	t.UserY = d.float32()
+	t.Width = d.uint8()
+	t.Height = d.uint8()
+	d.reserved(1)
+	t.Vendor = d.uint32()
+	t.Product = d.uint32()
+	d.reserved(4)
+	t.FirmwareBuild = d.uint64()
+	d.reserved(8)
+	t.FirmwareMinor = d.uint16()
+	t.FirmwareMajor = d.uint16()
+	d.reserved(4)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateDeviceChainPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(2 + tilesPerChain*tileDeviceSize).uint8(p.StartIndex)
+	for i := range p.Tiles {
+		p.Tiles[i].encode(e)
+	}
+
+	return e.uint8(p.TilesCount).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateDeviceChainPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 2+tilesPerChain*tileDeviceSize); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.StartIndex = d.uint8()
+	for i := range p.Tiles {
+		p.Tiles[i].decode(d)
+	}
+	p.TilesCount = d.uint8()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *Get64Payload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(6).uint8(p.TileIndex).uint8(p.Length).reserved(1)
+	return e.uint8(p.X).uint8(p.Y).uint8(p.Width).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *Get64Payload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 6); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.TileIndex = d.uint8()
+	p.Length = d.uint8()
+	d.reserved(1)
+	p.X = d.uint8()
+	p.Y = d.uint8()
+	p.Width = d.uint8()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *State64Payload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(5 + zonesPerTileMessage*hsbkSize).uint8(p.TileIndex).reserved(1)
+	e.uint8(p.X).uint8(p.Y).uint8(p.Width)
+	for _, color := range p.Colors {
+		e.hsbk(color)
+	}
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *State64Payload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 5+zonesPerTileMessage*hsbkSize); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.TileIndex = d.uint8()
+	d.reserved(1)
+	p.X = d.uint8()
+	p.Y = d.uint8()
+	p.Width = d.uint8()
+	for i := range p.Colors {
+		p.Colors[i] = d.hsbk()
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *Set64Payload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(10 + zonesPerTileMessage*hsbkSize).uint8(p.TileIndex).uint8(p.Length).reserved(1)
+	e.uint8(p.X).uint8(p.Y).uint8(p.Width).uint32(p.Duration)
+	for _, color := range p.Colors {
+		e.hsbk(color)
+	}
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *Set64Payload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 10+zonesPerTileMessage*hsbkSize); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.TileIndex = d.uint8()
+	p.Length = d.uint8()
+	d.reserved(1)
+	p.X = d.uint8()
+	p.Y = d.uint8()
+	p.Width = d.uint8()
+	p.Duration = d.uint32()
+	for i := range p.Colors {
+		p.Colors[i] = d.hsbk()
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *MultiZoneEffectPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(27 + effectParameters*4).uint32(p.InstanceID).uint8(p.Type).reserved(2)
+	e.uint32(p.Speed).uint64(p.Duration).reserved(8)
+	for _, parameter := range p.Parameters {
+		e.uint32(parameter)
+	}
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *MultiZoneEffectPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, 27+effectParameters*4); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	p.InstanceID = d.uint32()
+	p.Type = d.uint8()
+	d.reserved(2)
+	p.Speed = d.uint32()
+	p.Duration = d.uint64()
+	d.reserved(8)
+	for i := range p.Parameters {
+		p.Parameters[i] = d.uint32()
+	}
+	return nil
+}
+
+// encode encodes the settings of the tile effect.
+func (p *TileEffectPayload) encode(e *encoder) {
+	e.uint32(p.InstanceID).uint8(p.Type).uint32(p.Speed).uint64(p.Duration).reserved(8)
+	for _, parameter := range p.Parameters {
+		e.uint32(parameter)
+	}
+
+	e.uint8(p.PaletteCount)
+	for _, color := range p.Palette {
+		e.hsbk(color)
+	}
+}
+
+// decode decodes the settings of the tile effect.
+func (p *TileEffectPayload) decode(d *decoder) {
+	p.InstanceID = d.uint32()
+	p.Type = d.uint8()
+	p.Speed = d.uint32()
+	p.Duration = d.uint64()
+	d.reserved(8)
+	for i := range p.Parameters {
+		p.Parameters[i] = d.uint32()
+	}
+
+	p.PaletteCount = d.uint8()
+	for i := range p.Palette {
+		p.Palette[i] = d.hsbk()
+	}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *GetTileEffectPayload) MarshalBinary() ([]byte, error) {
+	return newEncoder(2).reserved(2).buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *GetTileEffectPayload) UnmarshalBinary(data []byte) error {
+	return verifySize(data, 2)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *SetTileEffectPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(tileEffectSize + 2).reserved(2)
+	p.encode(e)
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *SetTileEffectPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, tileEffectSize+2); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	d.reserved(2)
+	p.decode(d)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *StateTileEffectPayload) MarshalBinary() ([]byte, error) {
+	e := newEncoder(tileEffectSize + 1).reserved(1)
+	p.encode(e)
+
+	return e.buffer, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *StateTileEffectPayload) UnmarshalBinary(data []byte) error {
+	if err := verifySize(data, tileEffectSize+1); err != nil {
+		return err
+	}
+
+	d := newDecoder(data)
+	d.reserved(1)
+	p.decode(d)
+	return nil
+}
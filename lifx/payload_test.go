@@ -0,0 +1,240 @@
+package lifx
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sampleColor returns a color whose channels all differ, so a swapped channel is detected.
+func sampleColor(i int) HSBK {
+	return HSBK{
+		Hue:        uint16(1000 + i),
+		Saturation: uint16(2000 + i),
+		Brightness: uint16(3000 + i),
+		Kelvin:     uint16(4000 + i),
+	}
+}
+
+// sampleColors fills the colors with distinct colors.
+func sampleColors(colors []HSBK) {
+	for i := range colors {
+		colors[i] = sampleColor(i)
+	}
+}
+
+// sampleTileEffect returns the settings of a tile effect whose fields are all set.
+func sampleTileEffect() TileEffectPayload {
+	p := TileEffectPayload{InstanceID: 7, Type: 2, Speed: 3000, Duration: 1 << 40, PaletteCount: paletteSize}
+	for i := range p.Parameters {
+		p.Parameters[i] = uint32(i + 1)
+	}
+	sampleColors(p.Palette[:])
+
+	return p
+}
+
+// samplePayloads returns a payload whose fields are all set for each message type
+// which does not have an empty payload, with the size of the payload in bytes.
+func samplePayloads() []struct {
+	msgType MessageType
+	payload Payload
+	size    int
+} {
+	multiZone := &StateMultiZonePayload{Count: 16, Index: 8}
+	sampleColors(multiZone.Colors[:])
+
+	setExtended := &SetExtendedColorZonesPayload{Duration: 1500, Apply: 1, Index: 300, ColorsCount: zonesPerExtended}
+	sampleColors(setExtended.Colors[:])
+
+	stateExtended := &StateExtendedColorZonesPayload{Count: 400, Index: 82, ColorsCount: zonesPerExtended}
+	sampleColors(stateExtended.Colors[:])
+
+	chain := &StateDeviceChainPayload{StartIndex: 1, TilesCount: 5}
+	for i := range chain.Tiles {
+		chain.Tiles[i] = TileDevice{
+			AccelMeasX:    int16(-i),
+			AccelMeasY:    int16(i * 2),
+			AccelMeasZ:    -100,
+			UserX:         float32(i) / 2,
+			UserY:         -1.5,
+			Width:         8,
+			Height:        8,
+			Vendor:        1,
+			Product:       55,
+			FirmwareBuild: 1548977726000000000,
+			FirmwareMinor: 50,
+			FirmwareMajor: 3,
+		}
+	}
+
+	state64 := &State64Payload{TileIndex: 3, X: 1, Y: 2, Width: 8}
+	sampleColors(state64.Colors[:])
+
+	set64 := &Set64Payload{TileIndex: 4, Length: 1, X: 0, Y: 0, Width: 8, Duration: 250}
+	sampleColors(set64.Colors[:])
+
+	multiZoneEffect := &MultiZoneEffectPayload{InstanceID: 9, Type: 1, Speed: 2000, Duration: 1 << 35}
+	for i := range multiZoneEffect.Parameters {
+		multiZoneEffect.Parameters[i] = uint32(100 + i)
+	}
+
+	echo := &EchoPayload{}
+	for i := range echo.Payload {
+		echo.Payload[i] = byte(i + 1)
+	}
+
+	waveform := SetWaveformPayload{Transient: true, Color: sampleColor(0), Period: 1000, Cycles: 2.5, SkewRatio: -16384, Waveform: 4}
+	group := &GroupPayload{ID: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, Label: "Kitchen", UpdatedAt: 1553350342028441856}
+	location := &LocationPayload{ID: [16]byte{16, 15, 14}, Label: "Home", UpdatedAt: 1553350342028441856}
+
+	return []struct {
+		msgType MessageType
+		payload Payload
+		size    int
+	}{
+		{StateService, &StateServicePayload{Service: 1, Port: 56700}, 5},
+		{StateHostInfo, &SignalPayload{Signal: 3.16e-5, Tx: 1234, Rx: 5678}, 14},
+		{StateWifiInfo, &SignalPayload{Signal: 1e-6, Tx: 1, Rx: 2}, 14},
+		{StateHostFirmware, &FirmwarePayload{Build: 1548977726000000000, VersionMinor: 70, VersionMajor: 3}, 20},
+		{StateWifiFirmware, &FirmwarePayload{Build: 1456093684000000000, VersionMinor: 1, VersionMajor: 2}, 20},
+		{SetPowerDevice, &PowerPayload{Level: 65535}, 2},
+		{StatePower, &PowerPayload{Level: 65535}, 2},
+		{StatePowerLight, &PowerPayload{Level: 65535}, 2},
+		{SetLabel, &LabelPayload{Label: "Desk"}, labelSize},
+		{StateLabel, &LabelPayload{Label: "a label of exactly 32 characters"}, labelSize},
+		{StateVersion, &StateVersionPayload{Vendor: 1, Product: 31, Version: 2}, 12},
+		{StateInfo, &StateInfoPayload{Time: 1553350342028441856, UpTime: 1e12, DownTime: 5e9}, 24},
+		{SetLocation, location, 56},
+		{StateLocation, location, 56},
+		{SetGroup, group, 56},
+		{StateGroup, group, 56},
+		{EchoRequest, echo, 64},
+		{EchoResponse, echo, 64},
+		{SetColor, &SetColorPayload{Color: sampleColor(1), Duration: 1024}, 13},
+		{SetWaveform, &waveform, 21},
+		{SetWaveformOptional, &SetWaveformOptionalPayload{SetWaveformPayload: waveform, SetHue: true, SetBrightness: true}, 25},
+		{StateLight, &StateLightPayload{Color: sampleColor(2), Power: 65535, Label: "Desk"}, 52},
+		{SetPowerLight, &SetPowerLightPayload{Level: 65535, Duration: 500}, 6},
+		{SetColorZones, &SetColorZonesPayload{StartIndex: 2, EndIndex: 9, Color: sampleColor(3), Duration: 100, Apply: 1}, 15},
+		{GetColorZones, &GetColorZonesPayload{StartIndex: 0, EndIndex: 255}, 2},
+		{StateZone, &StateZonePayload{Count: 16, Index: 3, Color: sampleColor(4)}, 10},
+		{StateMultiZone, multiZone, 66},
+		{SetExtendedColorZones, setExtended, 664},
+		{StateExtendedColorZones, stateExtended, 661},
+		{StateDeviceChain, chain, 882},
+		{Get64, &Get64Payload{TileIndex: 1, Length: 5, X: 0, Y: 4, Width: 8}, 6},
+		{State64, state64, 517},
+		{Set64, set64, 522},
+		{SetMultiZoneEffect, multiZoneEffect, 59},
+		{StateMultiZoneEffect, multiZoneEffect, 59},
+		{GetTileEffect, &GetTileEffectPayload{}, 2},
+		{SetTileEffect, &SetTileEffectPayload{TileEffectPayload: sampleTileEffect()}, 188},
+		{StateTileEffect, &StateTileEffectPayload{TileEffectPayload: sampleTileEffect()}, 187},
+		{StateInfrared, &InfraredPayload{Brightness: 32768}, 2},
+		{SetInfrared, &InfraredPayload{Brightness: 65535}, 2},
+		{SetHevCycle, &SetHevCyclePayload{Enable: true, Duration: 7200}, 5},
+		{StateHevCycle, &StateHevCyclePayload{Duration: 7200, Remaining: 3600, LastPower: true}, 9},
+		{SetHevCycleConfiguration, &HevCycleConfigurationPayload{Indication: true, Duration: 3600}, 5},
+		{StateHevCycleConfiguration, &HevCycleConfigurationPayload{Indication: true, Duration: 3600}, 5},
+		{StateLastHevCycleResult, &StateLastHevCycleResultPayload{Result: 3}, 1},
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	tested := map[MessageType]bool{}
+	for _, test := range samplePayloads() {
+		tested[test.msgType] = true
+
+		data, err := test.payload.MarshalBinary()
+		if err != nil {
+			t.Errorf("message type %d: unexpected error: %v", test.msgType, err)
+			continue
+		}
+
+		if len(data) != test.size {
+			t.Errorf("message type %d: expected a payload of %d bytes, got %d", test.msgType, test.size, len(data))
+			continue
+		}
+
+		decoded, err := NewPayload(test.msgType)
+		if err != nil {
+			t.Errorf("message type %d: unexpected error: %v", test.msgType, err)
+			continue
+		}
+
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Errorf("message type %d: unexpected error: %v", test.msgType, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(decoded, test.payload) {
+			t.Errorf("message type %d: expected %+v, got %+v", test.msgType, test.payload, decoded)
+		}
+
+		// A shorter payload is rejected.
+		err = decoded.UnmarshalBinary(data[:test.size-1])
+		if short, ok := err.(*ShortPayloadError); !ok || short.Expected != test.size || short.Size != test.size-1 {
+			t.Errorf("message type %d: expected a short payload error, got %v", test.msgType, err)
+		}
+	}
+
+	// The other message types must have an empty payload.
+	for msgType := range payloads {
+		if tested[msgType] {
+			continue
+		}
+
+		payload, _ := NewPayload(msgType)
+		if _, ok := payload.(*EmptyPayload); !ok {
+			t.Errorf("message type %d: payload %T not tested", msgType, payload)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	for _, test := range samplePayloads() {
+		message := NewMessageWithPayload(test.msgType, test.payload)
+		message.Header.SetTarget([8]byte{0xd0, 0x73, 0xd5, 1, 2, 3})
+		packet := message.EncodeToBytes()
+		if len(packet) != HeaderSize+test.size {
+			t.Errorf("message type %d: expected a packet of %d bytes, got %d", test.msgType, HeaderSize+test.size, len(packet))
+			continue
+		}
+
+		decoded, payload, err := DecodeToPayload(packet, test.msgType)
+		if err != nil {
+			t.Errorf("message type %d: unexpected error: %v", test.msgType, err)
+			continue
+		}
+
+		if decoded.Header.Target() != message.Header.Target() {
+			t.Errorf("message type %d: expected target %x, got %x", test.msgType, message.Header.Target(), decoded.Header.Target())
+		}
+
+		if !reflect.DeepEqual(payload, test.payload) {
+			t.Errorf("message type %d: expected %+v, got %+v", test.msgType, test.payload, payload)
+		}
+
+		// The short payload errors contain the message type.
+		truncated := append([]byte{}, packet[:len(packet)-1]...)
+		truncated[0], truncated[1] = byte(len(truncated)), byte(len(truncated)>>8)
+		_, _, err = DecodeToPayload(truncated, test.msgType)
+		if short, ok := err.(*ShortPayloadError); !ok || short.Type != test.msgType {
+			t.Errorf("message type %d: expected a short payload error, got %v", test.msgType, err)
+		}
+	}
+
+	// A packet whose size does not match its header is rejected.
+	packet := NewMessageWithPayload(StatePower, &PowerPayload{Level: 65535}).EncodeToBytes()
+	if _, err := DecodeToMessage(packet[:len(packet)-1]); err == nil {
+		t.Errorf("expected an invalid header error")
+	} else if _, ok := err.(*InvalidHeaderError); !ok {
+		t.Errorf("expected an invalid header error, got %v", err)
+	}
+
+	if _, _, err := DecodeToPayload(packet, StateLight); err == nil {
+		t.Errorf("expected an unexpected type error")
+	} else if unexpected, ok := err.(*UnexpectedTypeError); !ok || unexpected.Got != StatePower {
+		t.Errorf("expected an unexpected type error, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package lifx
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Registry contains the known devices. It is safe for concurrent use.
+// The devices it returns are shared: they can be used to send commands,
+// but their fields must be read from a Snapshot.
+type Registry struct {
+	// mu guards devices.
+	mu sync.RWMutex
+
+	// devices contains the known devices, in their order of addition.
+	devices []*Lifx
+}
+
+// NewRegistry returns a registry containing the given devices.
+func NewRegistry(devices []*Lifx) *Registry {
+	return &Registry{
+		devices: append([]*Lifx{}, devices...),
+	}
+}
+
+// Len returns the number of known devices.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.devices)
+}
+
+// Devices returns the known devices.
+// The returned slice is a copy, so it is not modified when a device is added.
+func (r *Registry) Devices() []*Lifx {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]*Lifx{}, r.devices...)
+}
+
+// Snapshots returns a snapshot of every known device.
+func (r *Registry) Snapshots() []*Lifx {
+	devices := r.Devices()
+	snapshots := make([]*Lifx, len(devices))
+	for i, device := range devices {
+		snapshots[i] = device.Snapshot()
+	}
+
+	return snapshots
+}
+
+// Select returns the devices which match the given function.
+// The function is called with a snapshot of each device.
+func (r *Registry) Select(match func(snapshot *Lifx) bool) []*Lifx {
+	devices := []*Lifx{}
+	for _, device := range r.Devices() {
+		if match(device.Snapshot()) {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices
+}
+
+// Merge adds a discovered device to the registry, unless a device with the same serial,
+// or the same address and port, is already known.
+// If a known device does not have any serial yet, it takes the serial of the discovered one.
+// If a known device has a new address, it is relocated.
+// It returns true if the device has been added, and true if a known device has been updated.
+func (r *Registry) Merge(device *Lifx) (bool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, known := range r.devices {
+		if same, updated := known.merge(device); same {
+			return false, updated
+		}
+	}
+
+	r.devices = append(r.devices, device)
+	return true, false
+}
+
+// merge merges a discovered device into the device, if they are the same.
+// It returns true if they are the same device, and true if the device has been updated.
+func (l *Lifx) merge(device *Lifx) (bool, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.Serial.IsZero() && l.Serial == device.Serial {
+		if device.Address != nil && (l.Address == nil || !l.Address.Equal(*device.Address) || l.Port != device.Port) {
+			log.WithFields(log.Fields{
+				"uuid":     l.UUID,
+				"serial":   l.Serial.String(),
+				"previous": fmt.Sprintf("%s:%s", l.Address, l.Port),
+				"current":  fmt.Sprintf("%s:%s", device.Address, device.Port),
+			}).Warn("Device relocated")
+			l.Address = device.Address
+			l.Port = device.Port
+			return true, true
+		}
+		return true, false
+	}
+
+	if l.Address != nil && device.Address != nil && l.Address.Equal(*device.Address) && l.Port == device.Port {
+		if l.Serial.IsZero() {
+			l.Serial = device.Serial
+			return true, true
+		}
+		return true, false
+	}
+
+	return false, false
+}
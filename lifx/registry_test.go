@@ -0,0 +1,162 @@
+package lifx
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+// device returns a Lifx device with the given serial, address and port.
+func device(serial Serial, address string, port string) *Lifx {
+	ip := net.ParseIP(address)
+	return &Lifx{Serial: serial, Address: &ip, Port: port}
+}
+
+func TestRegistryMerge(t *testing.T) {
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x00, 0x01}
+	other := Serial{0xd0, 0x73, 0xd5, 0x00, 0x00, 0x02}
+
+	tests := []struct {
+		name       string
+		known      *Lifx
+		discovered *Lifx
+		added      bool
+		updated    bool
+		expected   *Lifx
+	}{
+		{
+			name:       "same serial, same address",
+			known:      device(serial, "192.168.1.10", "56700"),
+			discovered: device(serial, "192.168.1.10", "56700"),
+			expected:   device(serial, "192.168.1.10", "56700"),
+		},
+		{
+			name:       "same serial, new address",
+			known:      device(serial, "192.168.1.10", "56700"),
+			discovered: device(serial, "192.168.1.20", "56700"),
+			updated:    true,
+			expected:   device(serial, "192.168.1.20", "56700"),
+		},
+		{
+			name:       "same serial, new port",
+			known:      device(serial, "192.168.1.10", "56700"),
+			discovered: device(serial, "192.168.1.10", "56701"),
+			updated:    true,
+			expected:   device(serial, "192.168.1.10", "56701"),
+		},
+		{
+			name:       "same serial, no known address",
+			known:      &Lifx{Serial: serial},
+			discovered: device(serial, "192.168.1.10", "56700"),
+			updated:    true,
+			expected:   device(serial, "192.168.1.10", "56700"),
+		},
+		{
+			name:       "same serial, no discovered address",
+			known:      device(serial, "192.168.1.10", "56700"),
+			discovered: &Lifx{Serial: serial},
+			expected:   device(serial, "192.168.1.10", "56700"),
+		},
+		{
+			name:       "same address, zero serial",
+			known:      device(Serial{}, "192.168.1.10", "56700"),
+			discovered: device(serial, "192.168.1.10", "56700"),
+			updated:    true,
+			expected:   device(serial, "192.168.1.10", "56700"),
+		},
+		{
+			name:       "same address, different serial",
+			known:      device(other, "192.168.1.10", "56700"),
+			discovered: device(serial, "192.168.1.10", "56700"),
+			expected:   device(other, "192.168.1.10", "56700"),
+		},
+		{
+			name:       "new device",
+			known:      device(other, "192.168.1.10", "56700"),
+			discovered: device(serial, "192.168.1.20", "56700"),
+			added:      true,
+			expected:   device(other, "192.168.1.10", "56700"),
+		},
+		{
+			name:       "new device without address",
+			known:      device(Serial{}, "192.168.1.10", "56700"),
+			discovered: &Lifx{Serial: serial},
+			added:      true,
+			expected:   device(Serial{}, "192.168.1.10", "56700"),
+		},
+	}
+
+	for _, test := range tests {
+		r := NewRegistry([]*Lifx{test.known})
+		added, updated := r.Merge(test.discovered)
+		if added != test.added || updated != test.updated {
+			t.Errorf("%s: expected (%t, %t), got (%t, %t)", test.name, test.added, test.updated, added, updated)
+		}
+
+		devices := r.Devices()
+		if test.added && (len(devices) != 2 || devices[1] != test.discovered) {
+			t.Errorf("%s: expected the device to be appended, got %d devices", test.name, len(devices))
+		}
+
+		if !test.added && len(devices) != 1 {
+			t.Errorf("%s: expected 1 device, got %d", test.name, len(devices))
+		}
+
+		known := devices[0].Snapshot()
+		if known.Serial != test.expected.Serial || !known.Address.Equal(*test.expected.Address) || known.Port != test.expected.Port {
+			t.Errorf("%s: expected %s at %s:%s, got %s at %s:%s", test.name,
+				test.expected.Serial, test.expected.Address, test.expected.Port,
+				known.Serial, known.Address, known.Port)
+		}
+	}
+}
+
+func TestRegistryDevices(t *testing.T) {
+	kitchen := &Lifx{Label: "Kitchen"}
+	r := NewRegistry([]*Lifx{kitchen})
+
+	// The returned devices are not modified when a device is added.
+	devices := r.Devices()
+	r.Merge(device(Serial{1}, "192.168.1.10", "56700"))
+	if len(devices) != 1 || r.Len() != 2 {
+		t.Fatalf("expected 1 returned device and 2 known devices, got %d and %d", len(devices), r.Len())
+	}
+
+	selected := r.Select(func(snapshot *Lifx) bool {
+		return snapshot.Label == "Kitchen"
+	})
+	if len(selected) != 1 || selected[0] != kitchen {
+		t.Fatalf("expected the shared kitchen device, got %d devices", len(selected))
+	}
+
+	// A snapshot is a copy of the device.
+	snapshot := r.Snapshots()[0]
+	snapshot.Label = "Desk"
+	if snapshot == kitchen || kitchen.Snapshot().Label != "Kitchen" {
+		t.Errorf("expected the snapshot to be a copy")
+	}
+}
+
+func TestRegistryConcurrency(t *testing.T) {
+	r := NewRegistry(nil)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			r.Merge(device(Serial{byte(i + 1)}, "192.168.1.10", strconv.Itoa(i)))
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		for _, snapshot := range r.Snapshots() {
+			if snapshot.Address == nil {
+				t.Fatalf("expected an address")
+			}
+		}
+	}
+
+	<-done
+	if r.Len() != 100 {
+		t.Errorf("expected 100 devices, got %d", r.Len())
+	}
+}
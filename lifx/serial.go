@@ -0,0 +1,75 @@
+package lifx
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Serial is the serial number of a device. It is the MAC address of the device
+// and it is used as the target of the messages sent to this one.
+// It is written as an hexadecimal string (ex: d073d5000000).
+type Serial [6]byte
+
+// ParseSerial parses an hexadecimal string and returns its Serial equivalent.
+func ParseSerial(value string) (Serial, error) {
+	serial := Serial{}
+	bytes, err := hex.DecodeString(strings.Replace(strings.ToLower(value), ":", "", -1))
+	if err != nil || len(bytes) != len(serial) {
+		return serial, errors.NotValidf("serial `%s`", value)
+	}
+
+	copy(serial[:], bytes)
+	return serial, nil
+}
+
+// SerialFromTarget returns the serial contained in the target of a header.
+func SerialFromTarget(target [8]byte) Serial {
+	serial := Serial{}
+	copy(serial[:], target[0:6])
+	return serial
+}
+
+// Target returns the serial as the target of a header.
+// The two last bytes are always equal to 0.
+func (s Serial) Target() [8]byte {
+	target := [8]byte{}
+	copy(target[:], s[:])
+	return target
+}
+
+// IsZero returns true if the serial has not been initialized.
+func (s Serial) IsZero() bool {
+	return s == Serial{}
+}
+
+// String returns the hexadecimal representation of the serial.
+func (s Serial) String() string {
+	if s.IsZero() {
+		return ""
+	}
+
+	return hex.EncodeToString(s[:])
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Serial) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Serial) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*s = Serial{}
+		return nil
+	}
+
+	serial, err := ParseSerial(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = serial
+	return nil
+}
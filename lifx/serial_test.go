@@ -0,0 +1,195 @@
+package lifx
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/fberrez/horus/client"
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParseSerial(t *testing.T) {
+	expected := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+	for _, value := range []string{"d073d5001337", "D073D5001337", "d0:73:d5:00:13:37"} {
+		serial, err := ParseSerial(value)
+		if err != nil {
+			t.Errorf("serial `%s`: unexpected error: %v", value, err)
+			continue
+		}
+
+		if serial != expected {
+			t.Errorf("serial `%s`: expected %s, got %s", value, expected, serial)
+		}
+	}
+
+	for _, value := range []string{"", "d073d5", "d073d500133700", "zz73d5001337"} {
+		if _, err := ParseSerial(value); !errors.IsNotValid(err) {
+			t.Errorf("serial `%s`: expected a not valid error, got %v", value, err)
+		}
+	}
+}
+
+func TestSerialTarget(t *testing.T) {
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+	target := serial.Target()
+	if target != [8]byte{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37, 0, 0} {
+		t.Errorf("unexpected target %x", target)
+	}
+
+	if SerialFromTarget(target) != serial {
+		t.Errorf("expected serial %s, got %s", serial, SerialFromTarget(target))
+	}
+
+	if !(Serial{}).IsZero() || serial.IsZero() {
+		t.Errorf("only the empty serial must be zero")
+	}
+
+	if (Serial{}).String() != "" || serial.String() != "d073d5001337" {
+		t.Errorf("unexpected strings `%s` and `%s`", Serial{}, serial)
+	}
+}
+
+func TestSerialEncoding(t *testing.T) {
+	type device struct {
+		Serial Serial `yaml:"serial" json:"serial"`
+	}
+
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+	data, err := json.Marshal(&device{Serial: serial})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `{"serial":"d073d5001337"}` {
+		t.Errorf("unexpected json %s", data)
+	}
+
+	decoded := &device{}
+	if err := yaml.Unmarshal([]byte("serial: d0:73:d5:00:13:37"), decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Serial != serial {
+		t.Errorf("expected serial %s, got %s", serial, decoded.Serial)
+	}
+
+	// An empty serial is unknown.
+	decoded = &device{Serial: serial}
+	if err := json.Unmarshal([]byte(`{"serial":""}`), decoded); err != nil || !decoded.Serial.IsZero() {
+		t.Errorf("expected a zero serial, got %s (%v)", decoded.Serial, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"serial":"nope"}`), decoded); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// rawDevice is a fake device answering each received packet with the packet returned by the function,
+// unless it is nil.
+// The received packets are sent to the returned channel.
+// It returns the socket and the port of the device.
+func rawDevice(t *testing.T, reply func(packet []byte) []byte) (*net.UDPConn, string, chan []byte) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 16)
+	go func() {
+		for {
+			buffer := make([]byte, 2048)
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			received <- buffer[:n]
+
+			// Like a device, the reply has the source and the sequence of the received packet.
+			answer := reply(buffer[:n])
+			if answer == nil {
+				continue
+			}
+
+			copy(answer[4:8], buffer[4:8])
+			answer[23] = buffer[23]
+			conn.WriteToUDP(answer, from)
+		}
+	}()
+
+	return conn, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port), received
+}
+
+func TestLearnSerial(t *testing.T) {
+	serial := Serial{0xd0, 0x73, 0xd5, 0x00, 0x13, 0x37}
+	other := Serial{0xd0, 0x73, 0xd5, 0x00, 0x00, 0x01}
+
+	// The device replies to the GetService messages and to the messages addressed to it,
+	// and another device listening on the same address replies to the other messages.
+	conn, port, received := rawDevice(t, func(packet []byte) []byte {
+		if MessageType(binary.LittleEndian.Uint16(packet[32:34])) == GetService {
+			return servicePacket(serial.Target(), serviceUDP, 56700)
+		}
+
+		reply := NewMessageWithPayload(StatePower, &PowerPayload{Level: 65535})
+		reply.Header.SetTarget(other.Target())
+		if SerialFromTarget(target(packet)) == serial {
+			reply.Header.SetTarget(serial.Target())
+		}
+
+		return reply.EncodeToBytes()
+	})
+	defer conn.Close()
+
+	ip := net.IPv4(127, 0, 0, 1)
+	l := &Lifx{Address: &ip, Port: port, Protocol: client.UDP}
+
+	// The serial is not learnt from the other replies.
+	if _, err := l.Request(GetMessageWithoutPayload(GetPowerDevice), StatePower); err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.Serial.IsZero() {
+		t.Fatalf("expected no serial, got %s", l.Serial)
+	}
+
+	packet := <-received
+	if packet[3]&0x20 == 0 || SerialFromTarget(target(packet)) != (Serial{}) {
+		t.Errorf("expected a tagged message sent to every device, got frame %x and target %x", packet[2:4], packet[8:16])
+	}
+
+	if err := l.identify(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+
+	if l.Serial != serial {
+		t.Fatalf("expected serial %s, got %s", serial, l.Serial)
+	}
+
+	// Once the serial is known, the messages are addressed to the device.
+	if _, err := l.Request(GetMessageWithoutPayload(GetPowerDevice), StatePower); err != nil {
+		t.Fatal(err)
+	}
+
+	packet = <-received
+	if packet[3]&0x20 != 0 || SerialFromTarget(target(packet)) != serial {
+		t.Errorf("expected a message addressed to %s, got frame %x and target %x", serial, packet[2:4], packet[8:16])
+	}
+
+	if l.Serial != serial {
+		t.Errorf("expected serial %s, got %s", serial, l.Serial)
+	}
+}
+
+// target returns the target of the header of a packet.
+func target(packet []byte) [8]byte {
+	target := [8]byte{}
+	copy(target[:], packet[8:16])
+	return target
+}
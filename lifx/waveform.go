@@ -0,0 +1,189 @@
+package lifx
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+)
+
+type (
+	// Waveform is the shape of the color transitions of an effect.
+	Waveform uint8
+
+	// Effect contains the settings of a waveform effect.
+	// During each cycle, the light goes from its current color to the color of the effect,
+	// following the waveform.
+	Effect struct {
+		// Waveform is the shape of the effect.
+		Waveform Waveform
+
+		// Color is the color of the effect.
+		Color *HSBK
+
+		// FromColor is the color set before the effect starts.
+		// If it is nil, the effect starts from the current color of the light.
+		FromColor *HSBK
+
+		// Period is the duration of a cycle in milliseconds.
+		Period uint32
+
+		// Cycles is the number of cycles.
+		Cycles float32
+
+		// SkewRatio is the waveform skew, from -32768 to 32767.
+		// With a pulse, it defines the time spent on each color.
+		// Else, it defines when the color of the effect is reached during a cycle.
+		SkewRatio int16
+
+		// Persist determines if the light keeps the last color of the effect.
+		// Else, the light returns to its color before the effect.
+		Persist bool
+
+		// PowerOn determines if the light is turned on before the effect,
+		// if it is off.
+		PowerOn bool
+
+		// Channels contains the color channels changed by the effect.
+		// If it is nil, every channel is changed.
+		Channels *Channels
+	}
+
+	// Channels contains the color channels of a light changed by an effect.
+	// The other channels keep their current value.
+	Channels struct {
+		// Hue determines if the hue is changed.
+		Hue bool
+
+		// Saturation determines if the saturation is changed.
+		Saturation bool
+
+		// Brightness determines if the brightness is changed.
+		Brightness bool
+
+		// Kelvin determines if the kelvin is changed.
+		Kelvin bool
+	}
+)
+
+const (
+	// WaveformSaw goes linearly to the color of the effect and jumps back.
+	WaveformSaw Waveform = 0
+	// WaveformSine goes smoothly to the color of the effect and back.
+	WaveformSine Waveform = 1
+	// WaveformHalfSine goes smoothly to the color of the effect and jumps back.
+	WaveformHalfSine Waveform = 2
+	// WaveformTriangle goes linearly to the color of the effect and back.
+	WaveformTriangle Waveform = 3
+	// WaveformPulse switches between the two colors.
+	WaveformPulse Waveform = 4
+)
+
+// SkewRatio converts a ratio, from 0 to 1, to the skew ratio of a SetWaveform message.
+// A ratio of 0.5 gives a symmetrical waveform.
+func SkewRatio(ratio float64) int16 {
+	if ratio <= 0 {
+		return -32768
+	}
+
+	if ratio >= 1 {
+		return 32767
+	}
+
+	return int16(ratio*65535 - 32768)
+}
+
+// apply returns the given color, with the channels of the effect color.
+func (c *Channels) apply(color HSBK, effect HSBK) HSBK {
+	if c.Hue {
+		color.Hue = effect.Hue
+	}
+
+	if c.Saturation {
+		color.Saturation = effect.Saturation
+	}
+
+	if c.Brightness {
+		color.Brightness = effect.Brightness
+	}
+
+	if c.Kelvin {
+		color.Kelvin = effect.Kelvin
+	}
+
+	return color
+}
+
+// SetWaveform performs a waveform effect on the device.
+// If the effect requires it, the light is turned on and set to its starting color before.
+// If the effect only changes some color channels, a SetWaveformOptional (119) message is sent
+// and the starting color only applies on these channels.
+// It returns the statistics of the delivered messages.
+func (l *Lifx) SetWaveform(effect *Effect) (*Delivery, error) {
+	return l.SetWaveformContext(context.Background(), effect)
+}
+
+// SetWaveformContext is like SetWaveform but it stops sending messages when the context is done.
+func (l *Lifx) SetWaveformContext(ctx context.Context, effect *Effect) (*Delivery, error) {
+	l.commands.Lock()
+	defer l.commands.Unlock()
+
+	if effect.Color == nil {
+		return nil, errors.NewNotValid(nil, "color of the effect has not been initialized")
+	}
+
+	delivery := &Delivery{}
+	l.mu.RLock()
+	isOn := l.Power == PowerOn
+	current := l.HSBK
+	l.mu.RUnlock()
+
+	// Turns on the light, so the effect can be seen.
+	if effect.PowerOn && !isOn {
+		d, err := l.setPower(ctx, PowerOn, 0)
+		delivery.add(d)
+		if err != nil {
+			return delivery, errors.Annotate(err, "performing effect")
+		}
+	}
+
+	// Sets the starting color of the effect.
+	// If the current color of the light is not known, every channel is set.
+	if effect.FromColor != nil {
+		from := effect.FromColor
+		if effect.Channels != nil && current != nil {
+			color := effect.Channels.apply(*current, *effect.FromColor)
+			from = &color
+		}
+
+		d, err := l.setHSBK(ctx, from, 0)
+		delivery.add(d)
+		if err != nil {
+			return delivery, errors.Annotate(err, "performing effect")
+		}
+	}
+
+	// Sends a SetWaveform or a SetWaveformOptional message to the device
+	message := SetWaveformMessage(effect)
+	if effect.Channels != nil {
+		message = SetWaveformOptionalMessage(effect)
+	}
+
+	d, err := l.deliver(ctx, message)
+	delivery.add(d)
+	if err != nil {
+		return delivery, errors.Annotate(err, "performing effect")
+	}
+
+	// Updates device with the color kept after the effect
+	if effect.Persist {
+		color := *effect.Color
+		l.mu.Lock()
+		if effect.Channels != nil && l.HSBK != nil {
+			color = effect.Channels.apply(*l.HSBK, *effect.Color)
+		}
+		l.HSBK = &color
+		l.mu.Unlock()
+	}
+
+	return delivery, nil
+}
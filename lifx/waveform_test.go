@@ -0,0 +1,219 @@
+package lifx
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestSkewRatio(t *testing.T) {
+	tests := []struct {
+		ratio     float64
+		skewRatio int16
+	}{
+		{-1, -32768},
+		{0, -32768},
+		{0.25, -16384},
+		{0.5, 0},
+		{0.75, 16383},
+		{1, 32767},
+		{2, 32767},
+	}
+
+	for _, test := range tests {
+		if skewRatio := SkewRatio(test.ratio); skewRatio != test.skewRatio {
+			t.Errorf("ratio %v: expected %d, got %d", test.ratio, test.skewRatio, skewRatio)
+		}
+	}
+}
+
+func TestSetWaveform(t *testing.T) {
+	color := &HSBK{Hue: 21845, Saturation: 65535, Brightness: 65535, Kelvin: 3500}
+	from := &HSBK{Hue: 0, Saturation: 0, Brightness: 32768, Kelvin: 2700}
+
+	tests := []struct {
+		name     string
+		power    Power
+		effect   *Effect
+		messages []MessageType
+	}{
+		{
+			name:     "light on",
+			power:    PowerOn,
+			effect:   &Effect{Waveform: WaveformPulse, Color: color, PowerOn: true},
+			messages: []MessageType{SetWaveform},
+		},
+		{
+			name:     "light off",
+			power:    PowerOff,
+			effect:   &Effect{Waveform: WaveformPulse, Color: color, PowerOn: true},
+			messages: []MessageType{SetPowerLight, SetWaveform},
+		},
+		{
+			name:     "light kept off",
+			power:    PowerOff,
+			effect:   &Effect{Waveform: WaveformPulse, Color: color},
+			messages: []MessageType{SetWaveform},
+		},
+		{
+			name:     "starting color",
+			power:    PowerOff,
+			effect:   &Effect{Waveform: WaveformSine, Color: color, FromColor: from, PowerOn: true, Persist: true},
+			messages: []MessageType{SetPowerLight, SetColor, SetWaveform},
+		},
+	}
+
+	for _, test := range tests {
+		l, conn, received := ackingDevice(t)
+		l.Power = test.power
+		l.HSBK = from
+
+		test.effect.Period = 1000
+		test.effect.Cycles = 3
+		test.effect.SkewRatio = SkewRatio(0.5)
+		delivery, err := l.SetWaveform(test.effect)
+		conn.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if delivery.Attempts != len(test.messages) {
+			t.Errorf("%s: expected %d attempts, got %d", test.name, len(test.messages), delivery.Attempts)
+		}
+
+		messages := receivedMessages(t, received)
+		if len(messages) != len(test.messages) {
+			t.Errorf("%s: expected %d messages, got %d", test.name, len(test.messages), len(messages))
+			continue
+		}
+
+		for i, message := range messages {
+			if message.Header.Type() != test.messages[i] {
+				t.Errorf("%s: message %d: expected type %d, got %d", test.name, i, test.messages[i], message.Header.Type())
+			}
+		}
+
+		payload, err := messages[len(messages)-1].Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := &SetWaveformPayload{
+			Transient: !test.effect.Persist,
+			Color:     *color,
+			Period:    1000,
+			Cycles:    3,
+			SkewRatio: 0,
+			Waveform:  uint8(test.effect.Waveform),
+		}
+		if *payload.(*SetWaveformPayload) != *expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, expected, payload)
+		}
+
+		// The light keeps the color of a persistent effect.
+		if test.effect.Persist && *l.HSBK != *color || !test.effect.Persist && *l.HSBK != *from {
+			t.Errorf("%s: unexpected color %+v", test.name, l.HSBK)
+		}
+	}
+}
+
+func TestSetWaveformWithoutColor(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	if _, err := l.SetWaveform(&Effect{Waveform: WaveformPulse}); !errors.IsNotValid(err) {
+		t.Errorf("expected a not valid error, got %v", err)
+	}
+
+	if len(received) != 0 {
+		t.Errorf("expected no message, got %d", len(received))
+	}
+}
+
+func TestChannelsApply(t *testing.T) {
+	color := HSBK{Hue: 1, Saturation: 2, Brightness: 3, Kelvin: 4}
+	effect := HSBK{Hue: 10, Saturation: 20, Brightness: 30, Kelvin: 40}
+
+	tests := []struct {
+		channels Channels
+		expected HSBK
+	}{
+		{Channels{}, color},
+		{Channels{Hue: true}, HSBK{Hue: 10, Saturation: 2, Brightness: 3, Kelvin: 4}},
+		{Channels{Saturation: true, Kelvin: true}, HSBK{Hue: 1, Saturation: 20, Brightness: 3, Kelvin: 40}},
+		{Channels{Brightness: true}, HSBK{Hue: 1, Saturation: 2, Brightness: 30, Kelvin: 4}},
+		{Channels{Hue: true, Saturation: true, Brightness: true, Kelvin: true}, effect},
+	}
+
+	for _, test := range tests {
+		if applied := test.channels.apply(color, effect); applied != test.expected {
+			t.Errorf("channels %+v: expected %+v, got %+v", test.channels, test.expected, applied)
+		}
+	}
+}
+
+func TestSetWaveformOptional(t *testing.T) {
+	l, conn, received := ackingDevice(t)
+	defer conn.Close()
+
+	current := &HSBK{Hue: 1, Saturation: 2, Brightness: 3, Kelvin: 4}
+	l.Power = PowerOn
+	l.HSBK = current
+
+	// Only the brightness is changed by the effect, from its starting value.
+	effect := &Effect{
+		Waveform:  WaveformSine,
+		Color:     &HSBK{Hue: 10, Saturation: 20, Brightness: 30, Kelvin: 40},
+		FromColor: &HSBK{Hue: 100, Saturation: 200, Brightness: 300, Kelvin: 400},
+		Period:    1000,
+		Cycles:    1,
+		Persist:   true,
+		Channels:  &Channels{Brightness: true},
+	}
+	if _, err := l.SetWaveform(effect); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receivedMessages(t, received)
+	if len(messages) != 2 || messages[0].Header.Type() != SetColor || messages[1].Header.Type() != SetWaveformOptional {
+		t.Fatalf("expected a SetColor and a SetWaveformOptional messages, got %d messages", len(messages))
+	}
+
+	payload, err := messages[0].Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if from := payload.(*SetColorPayload).Color; from != (HSBK{Hue: 1, Saturation: 2, Brightness: 300, Kelvin: 4}) {
+		t.Errorf("unexpected starting color %+v", from)
+	}
+
+	payload, err = messages[1].Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	optional := payload.(*SetWaveformOptionalPayload)
+	if optional.SetHue || optional.SetSaturation || !optional.SetBrightness || optional.SetKelvin || optional.Color != *effect.Color {
+		t.Errorf("unexpected payload %+v", optional)
+	}
+
+	if *l.HSBK != (HSBK{Hue: 1, Saturation: 2, Brightness: 30, Kelvin: 4}) {
+		t.Errorf("unexpected color %+v", l.HSBK)
+	}
+}
+
+func TestSetWaveformOptionalMessage(t *testing.T) {
+	// Without channels, every channel is changed.
+	message := SetWaveformOptionalMessage(&Effect{Color: &HSBK{Hue: 10}})
+	payload, err := message.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	optional := payload.(*SetWaveformOptionalPayload)
+	if !optional.SetHue || !optional.SetSaturation || !optional.SetBrightness || !optional.SetKelvin || !optional.Transient {
+		t.Errorf("unexpected payload %+v", optional)
+	}
+}